@@ -0,0 +1,162 @@
+package apperrors
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Error is a domain error that knows which HTTP status it maps to. The
+// error middleware renders it as an RFC 7807 problem document; Cause is
+// logged but never sent to the client.
+type Error struct {
+	Status int
+	Detail string
+	Fields []FieldError
+	Cause  error
+}
+
+// FieldError describes one invalid field of a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Detail + ": " + e.Cause.Error()
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is makes errors.Is(err, apperrors.ErrNotFound) and friends match any
+// error of the same kind, whatever its detail.
+func (e *Error) Is(target error) bool {
+	kind, ok := target.(*Error)
+	return ok && kind.Detail == "" && kind.Status == e.Status
+}
+
+// Kinds to compare against with errors.Is.
+var (
+	ErrBadRequest           = &Error{Status: http.StatusBadRequest}
+	ErrUnauthorized         = &Error{Status: http.StatusUnauthorized}
+	ErrForbidden            = &Error{Status: http.StatusForbidden}
+	ErrNotFound             = &Error{Status: http.StatusNotFound}
+	ErrConflict             = &Error{Status: http.StatusConflict}
+	ErrPreconditionFailed   = &Error{Status: http.StatusPreconditionFailed}
+	ErrUnprocessable        = &Error{Status: http.StatusUnprocessableEntity}
+	ErrPreconditionRequired = &Error{Status: http.StatusPreconditionRequired}
+	ErrInternal             = &Error{Status: http.StatusInternalServerError}
+)
+
+func BadRequest(detail string) *Error {
+	return &Error{Status: http.StatusBadRequest, Detail: detail}
+}
+
+func Unauthorized(detail string) *Error {
+	return &Error{Status: http.StatusUnauthorized, Detail: detail}
+}
+
+func Forbidden(detail string) *Error {
+	return &Error{Status: http.StatusForbidden, Detail: detail}
+}
+
+func NotFound(detail string) *Error {
+	return &Error{Status: http.StatusNotFound, Detail: detail}
+}
+
+func Conflict(detail string) *Error {
+	return &Error{Status: http.StatusConflict, Detail: detail}
+}
+
+func PreconditionFailed(detail string) *Error {
+	return &Error{Status: http.StatusPreconditionFailed, Detail: detail}
+}
+
+func PreconditionRequired(detail string) *Error {
+	return &Error{Status: http.StatusPreconditionRequired, Detail: detail}
+}
+
+func UnsupportedMediaType(detail string) *Error {
+	return &Error{Status: http.StatusUnsupportedMediaType, Detail: detail}
+}
+
+func TooLarge(detail string) *Error {
+	return &Error{Status: http.StatusRequestEntityTooLarge, Detail: detail}
+}
+
+// Gone is for links and tokens that worked once but no longer do.
+func Gone(detail string) *Error {
+	return &Error{Status: http.StatusGone, Detail: detail}
+}
+
+// Unprocessable is for well-formed requests that break a business rule,
+// such as ordering a food that is not currently served.
+func Unprocessable(detail string) *Error {
+	return &Error{Status: http.StatusUnprocessableEntity, Detail: detail}
+}
+
+// Internal wraps an unexpected failure. detail is what the client sees.
+func Internal(cause error, detail string) *Error {
+	if detail == "" {
+		detail = "An unexpected error occurred"
+	}
+	return &Error{Status: http.StatusInternalServerError, Detail: detail, Cause: cause}
+}
+
+// Validation turns the result of validator.Struct into a 400 listing every
+// invalid field. Other errors become a plain bad request.
+func Validation(err error) *Error {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return BadRequest(err.Error())
+	}
+
+	fields := make([]FieldError, 0, len(validationErrors))
+	for _, fieldErr := range validationErrors {
+		fields = append(fields, FieldError{
+			Field:   fieldPath(fieldErr),
+			Rule:    fieldErr.Tag(),
+			Message: message(fieldErr),
+		})
+	}
+	return &Error{Status: http.StatusBadRequest, Detail: "The request body is invalid", Fields: fields}
+}
+
+// InvalidFields reports field problems found outside struct validation,
+// such as a range whose end is before its start.
+func InvalidFields(fields []FieldError) *Error {
+	return &Error{Status: http.StatusBadRequest, Detail: "The request body is invalid", Fields: fields}
+}
+
+// fieldPath drops the struct name from the namespace, so Food.Price
+// becomes Price and OrderItemPack.Order_items[0].Quantity keeps its index.
+func fieldPath(fieldErr validator.FieldError) string {
+	namespace := fieldErr.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+func message(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		return "must be at least " + fieldErr.Param()
+	case "max":
+		return "must be at most " + fieldErr.Param()
+	case "eq", "oneof":
+		return "has an unsupported value"
+	default:
+		return "failed the " + fieldErr.Tag() + " rule"
+	}
+}
@@ -0,0 +1,39 @@
+package apperrors
+
+import (
+	"errors"
+	"net/http"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details document.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// From returns err as an *Error, treating anything untyped as internal.
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return Internal(err, "")
+}
+
+// ToProblem builds the problem document for err on the given request path.
+func ToProblem(err *Error, instance string) Problem {
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(err.Status),
+		Status:   err.Status,
+		Detail:   err.Detail,
+		Instance: instance,
+		Errors:   err.Fields,
+	}
+}
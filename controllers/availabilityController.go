@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"restorent-management/apperrors"
+	"restorent-management/events"
+	"restorent-management/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// availabilityEvents carries an AvailabilityEvent every time a food's 86
+// state changes, either by the kitchen or by limited portions selling.
+var availabilityEvents = events.NewBroker()
+
+// AvailabilityEvent is sent to POS clients when a food's availability
+// changes.
+type AvailabilityEvent struct {
+	Food_id      string                   `json:"food_id"`
+	Name         string                   `json:"name"`
+	Orderable    bool                     `json:"orderable"`
+	Availability *models.FoodAvailability `json:"availability"`
+}
+
+func availabilityEvent(food models.Food) AvailabilityEvent {
+	event := AvailabilityEvent{
+		Food_id:      food.Food_id,
+		Orderable:    food.IsOrderableAt(time.Now()),
+		Availability: food.Availability,
+	}
+	if food.Name != nil {
+		event.Name = *food.Name
+	}
+	return event
+}
+
+// SetFoodAvailability handles PUT /foods/:food_id/availability, which the
+// kitchen uses to 86 a food, bring it back or limit it to a number of
+// portions.
+func SetFoodAvailability() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var availability models.FoodAvailability
+		if err := c.ShouldBindJSON(&availability); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		if err := validate.Struct(availability); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+		availability.Updated_at = time.Now()
+		availability.Updated_by = c.GetString("uid")
+
+		filter := notDeleted(bson.M{"food_id": c.Param("food_id")})
+		var current models.Food
+		err := foodCollection.FindOne(ctx, filter).Decode(&current)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Food not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the food"))
+			}
+			return
+		}
+		if err := checkIfMatch(c, current.Version); err != nil {
+			c.Error(err)
+			return
+		}
+		filter["version"] = current.Version
+
+		update := bson.M{
+			"$set": bson.M{"availability": availability, "updated_at": availability.Updated_at},
+			"$inc": bson.M{"version": 1},
+		}
+		var food models.Food
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = foodCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&food)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Food was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while updating the availability"))
+			}
+			return
+		}
+
+		availabilityEvents.Publish(availabilityEvent(food))
+
+		c.Header("ETag", etag(food.Version))
+		c.JSON(http.StatusOK, food)
+	}
+}
+
+// GetUnavailableFoods handles GET /foods/availability, the 86 list: every
+// food that is sold out or limited, with whether it can still be ordered.
+func GetUnavailableFoods() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		filter := notDeleted(bson.M{"availability.status": bson.M{
+			"$in": bson.A{models.AvailabilitySoldOut, models.AvailabilityLimited},
+		}})
+		cursor, err := foodCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing the foods"))
+			return
+		}
+
+		var foods []models.Food
+		if err := cursor.All(ctx, &foods); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding the foods"))
+			return
+		}
+
+		list := make([]AvailabilityEvent, 0, len(foods))
+		for _, food := range foods {
+			list = append(list, availabilityEvent(food))
+		}
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// StreamAvailability handles GET /foods/availability/stream, sending each
+// availability change to the client as a server-sent event.
+func StreamAvailability() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		changes, unsubscribe := availabilityEvents.Subscribe()
+		defer unsubscribe()
+
+		keepAlive := time.NewTicker(30 * time.Second)
+		defer keepAlive.Stop()
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case event, ok := <-changes:
+				if !ok {
+					return false
+				}
+				c.SSEvent("availability", event)
+				return true
+			case <-keepAlive.C:
+				// A comment line keeps proxies from closing an idle stream
+				fmt.Fprint(w, ": keep-alive\n\n")
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	}
+}
+
+// reservePortions takes the ordered portions of limited foods off their
+// remaining counts. Each decrement only applies while enough portions are
+// left, so two orders cannot sell the same last portion. If any food runs
+// short the portions already taken are put back.
+func reservePortions(ctx context.Context, portions map[string]int) error {
+	reserved := map[string]int{}
+	for foodID, count := range portions {
+		filter := notDeleted(bson.M{
+			"food_id":                foodID,
+			"availability.status":    models.AvailabilityLimited,
+			"availability.remaining": bson.M{"$gte": count},
+		})
+		update := bson.M{
+			"$inc": bson.M{"availability.remaining": -count, "version": 1},
+			"$set": bson.M{"availability.updated_at": time.Now()},
+		}
+
+		var food models.Food
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err := foodCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&food)
+		if err != nil {
+			releasePortions(ctx, reserved)
+			if err == mongo.ErrNoDocuments {
+				return portionsConflict(ctx, foodID, count)
+			}
+			return apperrors.Internal(err, "error occured while reserving portions")
+		}
+
+		reserved[foodID] = count
+		availabilityEvents.Publish(availabilityEvent(food))
+	}
+	return nil
+}
+
+// portionsConflict explains why portions of a food could not be reserved,
+// after another order took the last of them.
+func portionsConflict(ctx context.Context, foodID string, count int) error {
+	var food models.Food
+	if err := foodCollection.FindOne(ctx, bson.M{"food_id": foodID}).Decode(&food); err == nil {
+		if err := food.CheckAvailable(time.Now(), count); err != nil {
+			return apperrors.Conflict(err.Error())
+		}
+	}
+	return apperrors.Conflict("there are not enough portions left of food " + foodID)
+}
+
+// releasePortions puts portions back on limited foods, for example when an
+// order could not be saved after its portions were reserved.
+func releasePortions(ctx context.Context, portions map[string]int) {
+	for foodID, count := range portions {
+		filter := bson.M{"food_id": foodID, "availability.status": models.AvailabilityLimited}
+		update := bson.M{
+			"$inc": bson.M{"availability.remaining": count, "version": 1},
+			"$set": bson.M{"availability.updated_at": time.Now()},
+		}
+
+		var food models.Food
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		if err := foodCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&food); err == nil {
+			availabilityEvents.Publish(availabilityEvent(food))
+		}
+	}
+}
@@ -0,0 +1,590 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"restorent-management/apperrors"
+	"restorent-management/database"
+	"restorent-management/models"
+	"restorent-management/query"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var couponCollection *mongo.Collection = database.OpenCollection(database.Client, "coupon")
+var couponRedemptionCollection *mongo.Collection = database.OpenCollection(database.Client, "couponRedemption")
+
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9_-]{2,31}$`)
+
+// normalizeCouponCode makes codes case-insensitive: they are stored and
+// looked up in upper case.
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// validateCoupon checks what the struct tags cannot: the code's characters
+// and the validity window.
+func validateCoupon(coupon models.Coupon) error {
+	var fields []apperrors.FieldError
+
+	if !couponCodePattern.MatchString(*coupon.Code) {
+		fields = append(fields, apperrors.FieldError{Field: "Code", Rule: "pattern", Message: "must be letters, digits, '-' or '_'"})
+	}
+	if coupon.Starts_at != nil && coupon.Expires_at != nil && !coupon.Expires_at.After(*coupon.Starts_at) {
+		fields = append(fields, apperrors.FieldError{Field: "Expires_at", Rule: "gtfield", Message: "must be after starts_at"})
+	}
+
+	if len(fields) > 0 {
+		return apperrors.InvalidFields(fields)
+	}
+	return nil
+}
+
+func CreateCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var coupon models.Coupon
+		if err := c.ShouldBindJSON(&coupon); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+
+		if err := validate.Struct(coupon); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+		code := normalizeCouponCode(*coupon.Code)
+		coupon.Code = &code
+		if err := validateCoupon(coupon); err != nil {
+			c.Error(err)
+			return
+		}
+
+		now := time.Now()
+		coupon.Created_at = now
+		coupon.Updated_at = now
+		coupon.ID = primitive.NewObjectID()
+		coupon.Version = 1
+		coupon.Coupon_id = coupon.ID.Hex()
+		coupon.Used_count = 0
+		coupon.Discount_total = 0
+		coupon.Customer_uses = map[string]int{}
+
+		result, err := couponCollection.InsertOne(ctx, coupon)
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				c.Error(apperrors.Conflict(fmt.Sprintf("coupon code %s is already in use", code)))
+				return
+			}
+			c.Error(apperrors.Internal(err, "coupon was not created"))
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+var couponListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "code", Field: "code", Kind: query.String},
+		{Param: "discount_type", Field: "discount_type", Kind: query.String},
+		{Param: "starts_at", Field: "starts_at", Kind: query.Date},
+		{Param: "expires_at", Field: "expires_at", Kind: query.Date},
+	},
+	Sortable:    []string{"code", "expires_at", "used_count", "discount_total", "created_at"},
+	DefaultSort: "code",
+}
+
+func GetCoupons() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, couponListSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		notDeleted(list.Filter)
+
+		page, err := query.Find[models.Coupon](ctx, couponCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing coupons"))
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func GetCouponByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var coupon models.Coupon
+		err := couponCollection.FindOne(ctx, notDeleted(bson.M{"coupon_id": c.Param("coupon_id")})).Decode(&coupon)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Coupon not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the coupon"))
+			}
+			return
+		}
+		if notModified(c, coupon.Version) {
+			return
+		}
+
+		c.JSON(http.StatusOK, coupon)
+	}
+}
+
+func UpdateCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var coupon models.Coupon
+		filter := notDeleted(bson.M{"coupon_id": c.Param("coupon_id")})
+
+		err := couponCollection.FindOne(ctx, filter).Decode(&coupon)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Coupon not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the coupon"))
+			}
+			return
+		}
+		original := coupon
+
+		if err := checkIfMatch(c, coupon.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		if err := applyMergePatch(c, &coupon); err != nil {
+			c.Error(err)
+			return
+		}
+
+		coupon.ID = original.ID
+		coupon.Coupon_id = original.Coupon_id
+		coupon.Created_at = original.Created_at
+		coupon.Version = original.Version + 1
+		coupon.Deleted_at = original.Deleted_at
+		coupon.Deleted_by = original.Deleted_by
+		// Usage is counted by redemptions. Each redemption bumps the version,
+		// so the replace below cannot overwrite a count it did not see.
+		coupon.Used_count = original.Used_count
+		coupon.Discount_total = original.Discount_total
+		coupon.Customer_uses = original.Customer_uses
+
+		if err := validate.Struct(coupon); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+		code := normalizeCouponCode(*coupon.Code)
+		coupon.Code = &code
+		if err := validateCoupon(coupon); err != nil {
+			c.Error(err)
+			return
+		}
+
+		coupon.Updated_at = time.Now()
+
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = couponCollection.FindOneAndReplace(ctx, filter, coupon, opts).Decode(&coupon)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Coupon was modified by another request"))
+			} else if mongo.IsDuplicateKeyError(err) {
+				c.Error(apperrors.Conflict(fmt.Sprintf("coupon code %s is already in use", code)))
+			} else {
+				c.Error(apperrors.Internal(err, "Coupon update failed"))
+			}
+			return
+		}
+
+		c.Header("ETag", etag(coupon.Version))
+		c.JSON(http.StatusOK, coupon)
+	}
+}
+
+// RedeemCouponRequest is the body of POST /invoices/:invoice_id/coupon.
+// The customer is needed for coupons limited per customer.
+type RedeemCouponRequest struct {
+	Code        string  `json:"code" validate:"required,max=64"`
+	Customer_id *string `json:"customer_id" validate:"omitempty,alphanum,max=64"`
+}
+
+// couponUse is the change one redemption makes to a coupon's counters;
+// reversing a redemption applies it negated.
+func couponUse(discount float64, customerID *string, sign int) bson.M {
+	inc := bson.M{"used_count": sign, "discount_total": float64(sign) * discount, "version": 1}
+	if customerID != nil {
+		inc["customer_uses."+*customerID] = sign
+	}
+	return bson.M{"$inc": inc, "$set": bson.M{"updated_at": time.Now()}}
+}
+
+// claimCouponUse counts one use of the coupon unless that would take it
+// past its usage or per-customer limit. The limits are checked in the same
+// update that counts the use, so concurrent redemptions cannot both take
+// the last one. It reports whether the use was counted.
+func claimCouponUse(ctx context.Context, coupon models.Coupon, discount float64, customerID *string) (bool, error) {
+	limits := bson.A{
+		bson.M{"$or": bson.A{
+			bson.M{"usage_limit": nil},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$used_count", "$usage_limit"}}},
+		}},
+	}
+	if customerID != nil {
+		limits = append(limits, bson.M{"$or": bson.A{
+			bson.M{"per_customer_limit": nil},
+			bson.M{"$expr": bson.M{"$lt": bson.A{
+				bson.M{"$ifNull": bson.A{"$customer_uses." + *customerID, 0}},
+				"$per_customer_limit",
+			}}},
+		}})
+	} else {
+		limits = append(limits, bson.M{"per_customer_limit": nil})
+	}
+
+	filter := notDeleted(bson.M{"coupon_id": coupon.Coupon_id, "$and": limits})
+	result, err := couponCollection.UpdateOne(ctx, filter, couponUse(discount, customerID, 1))
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// RedeemCoupon handles POST /invoices/:invoice_id/coupon. The coupon's
+// discount is worked out from what is due on the invoice now and kept on
+// the invoice, which must still be pending and have no coupon.
+func RedeemCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var request RedeemCouponRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		var invoice models.Invoice
+		err := invoiceCollection.FindOne(ctx, notDeleted(bson.M{"invoice_id": c.Param("invoice_id")})).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Invoice not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the invoice"))
+			}
+			return
+		}
+		if err := checkIfMatch(c, invoice.Version); err != nil {
+			c.Error(err)
+			return
+		}
+		if *invoice.Payment_status != "PENDING" {
+			c.Error(apperrors.Conflict("coupons can only be redeemed against pending invoices"))
+			return
+		}
+		if invoice.Coupon != nil {
+			c.Error(apperrors.Conflict(fmt.Sprintf("coupon %s is already redeemed against this invoice", invoice.Coupon.Code)))
+			return
+		}
+
+		var coupon models.Coupon
+		code := normalizeCouponCode(request.Code)
+		err = couponCollection.FindOne(ctx, notDeleted(bson.M{"code": code})).Decode(&coupon)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Coupon not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the coupon"))
+			}
+			return
+		}
+		if coupon.Per_customer_limit != nil && request.Customer_id == nil {
+			c.Error(apperrors.InvalidFields([]apperrors.FieldError{{Field: "Customer_id", Rule: "required", Message: "is required for coupons limited per customer"}}))
+			return
+		}
+		if err := checkCustomer(ctx, request.Customer_id); err != nil {
+			c.Error(err)
+			return
+		}
+
+		view, err := viewInvoice(ctx, invoice)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		now := time.Now()
+		if err := coupon.CheckRedeemable(now, view.Payment_due); err != nil {
+			c.Error(apperrors.Unprocessable(err.Error()))
+			return
+		}
+		discount := coupon.DiscountOn(view.Payment_due)
+		if discount <= 0 {
+			c.Error(apperrors.Unprocessable("nothing is left to pay on this invoice"))
+			return
+		}
+
+		claimed, err := claimCouponUse(ctx, coupon, discount, request.Customer_id)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while counting the coupon use"))
+			return
+		}
+		if !claimed {
+			if coupon.Per_customer_limit != nil && coupon.Customer_uses[*request.Customer_id] >= *coupon.Per_customer_limit {
+				c.Error(apperrors.Conflict(fmt.Sprintf("coupon %s has been used the most times allowed for this customer", code)))
+			} else {
+				c.Error(apperrors.Conflict(fmt.Sprintf("coupon %s has reached its usage limit", code)))
+			}
+			return
+		}
+		// Gives the use back if the coupon does not end up on the invoice
+		release := func() {
+			couponCollection.UpdateOne(ctx, bson.M{"coupon_id": coupon.Coupon_id}, couponUse(discount, request.Customer_id, -1))
+		}
+
+		redemption := models.CouponRedemption{
+			ID:          primitive.NewObjectID(),
+			Coupon_id:   coupon.Coupon_id,
+			Code:        code,
+			Invoice_id:  invoice.Invoice_id,
+			Order_id:    invoice.Order_id,
+			Customer_id: request.Customer_id,
+			Discount:    discount,
+			Redeemed_at: now,
+			Redeemed_by: c.GetString("uid"),
+		}
+		redemption.Redemption_id = redemption.ID.Hex()
+		if _, err := couponRedemptionCollection.InsertOne(ctx, redemption); err != nil {
+			release()
+			c.Error(apperrors.Internal(err, "coupon redemption was not recorded"))
+			return
+		}
+
+		invoiceCoupon := models.InvoiceCoupon{
+			Coupon_id:     coupon.Coupon_id,
+			Code:          code,
+			Redemption_id: redemption.Redemption_id,
+			Discount:      discount,
+		}
+		filter := notDeleted(bson.M{"invoice_id": invoice.Invoice_id, "payment_status": "PENDING", "coupon": nil})
+		filter["version"] = invoice.Version
+		update := bson.M{
+			"$set": bson.M{"coupon": invoiceCoupon, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = invoiceCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&invoice)
+		if err != nil {
+			release()
+			couponRedemptionCollection.DeleteOne(ctx, bson.M{"redemption_id": redemption.Redemption_id})
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.Conflict("Invoice was paid or given a coupon by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "coupon was not redeemed"))
+			}
+			return
+		}
+
+		view, err = viewInvoice(ctx, invoice)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.Header("ETag", etag(invoice.Version))
+		c.JSON(http.StatusOK, view)
+	}
+}
+
+// RemoveCoupon handles DELETE /invoices/:invoice_id/coupon, taking the
+// coupon off a pending invoice. The redemption is kept, marked reversed,
+// and no longer counts towards the coupon's limits.
+func RemoveCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var invoice models.Invoice
+		err := invoiceCollection.FindOne(ctx, notDeleted(bson.M{"invoice_id": c.Param("invoice_id")})).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Invoice not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the invoice"))
+			}
+			return
+		}
+		if err := checkIfMatch(c, invoice.Version); err != nil {
+			c.Error(err)
+			return
+		}
+		if invoice.Coupon == nil {
+			c.Error(apperrors.NotFound("No coupon is redeemed against this invoice"))
+			return
+		}
+		if *invoice.Payment_status != "PENDING" {
+			c.Error(apperrors.Conflict("coupons can only be removed from pending invoices"))
+			return
+		}
+		redeemed := *invoice.Coupon
+
+		now := time.Now()
+		filter := notDeleted(bson.M{"invoice_id": invoice.Invoice_id, "payment_status": "PENDING", "coupon.redemption_id": redeemed.Redemption_id})
+		filter["version"] = invoice.Version
+		update := bson.M{
+			"$set": bson.M{"coupon": nil, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = invoiceCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.Conflict("Invoice was paid or changed by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "coupon was not removed"))
+			}
+			return
+		}
+
+		// Only a redemption reversed here gives its use back, so a repeated
+		// request cannot give it back twice
+		var redemption models.CouponRedemption
+		err = couponRedemptionCollection.FindOneAndUpdate(ctx,
+			bson.M{"redemption_id": redeemed.Redemption_id, "reversed_at": nil},
+			bson.M{"$set": bson.M{"reversed_at": now, "reversed_by": c.GetString("uid")}},
+		).Decode(&redemption)
+		if err == nil {
+			_, err = couponCollection.UpdateOne(ctx, bson.M{"coupon_id": redemption.Coupon_id}, couponUse(redemption.Discount, redemption.Customer_id, -1))
+		}
+		if err != nil && err != mongo.ErrNoDocuments {
+			c.Error(apperrors.Internal(err, "coupon use was not given back"))
+			return
+		}
+
+		view, err := viewInvoice(ctx, invoice)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.Header("ETag", etag(invoice.Version))
+		c.JSON(http.StatusOK, view)
+	}
+}
+
+var couponRedemptionListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "invoice_id", Field: "invoice_id", Kind: query.String},
+		{Param: "customer_id", Field: "customer_id", Kind: query.String},
+		{Param: "redeemed_at", Field: "redeemed_at", Kind: query.Date},
+	},
+	Sortable:    []string{"redeemed_at", "discount"},
+	DefaultSort: "-redeemed_at",
+}
+
+// GetCouponRedemptions handles GET /coupons/:coupon_id/redemptions,
+// including the ones that were reversed.
+func GetCouponRedemptions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, couponRedemptionListSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		list.Filter["coupon_id"] = c.Param("coupon_id")
+
+		page, err := query.Find[models.CouponRedemption](ctx, couponRedemptionCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing coupon redemptions"))
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// CouponUsage is how much one coupon was used over a period.
+type CouponUsage struct {
+	Coupon_id   string  `json:"coupon_id" bson:"_id"`
+	Code        string  `json:"code" bson:"code"`
+	Redemptions int     `json:"redemptions" bson:"redemptions"`
+	Customers   int     `json:"customers" bson:"customers"`
+	Discount    float64 `json:"discount" bson:"discount"`
+}
+
+// GetCouponReport handles GET /coupons/report?from=&to=, the coupons
+// redeemed between two RFC 3339 times (the last 30 days by default) with
+// how often and what they cost, most costly first. Reversed redemptions are
+// left out.
+func GetCouponReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		from, to, err := reportPeriod(c)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		pipeline := bson.A{
+			bson.M{"$match": bson.M{"redeemed_at": bson.M{"$gte": from, "$lt": to}, "reversed_at": nil}},
+			bson.M{"$group": bson.M{
+				"_id":         "$coupon_id",
+				"code":        bson.M{"$first": "$code"},
+				"redemptions": bson.M{"$sum": 1},
+				"customers":   bson.M{"$addToSet": "$customer_id"},
+				"discount":    bson.M{"$sum": "$discount"},
+			}},
+			// Redemptions without a customer are not counted as one
+			bson.M{"$set": bson.M{"customers": bson.M{"$size": bson.M{"$filter": bson.M{
+				"input": "$customers", "as": "customer", "cond": bson.M{"$ne": bson.A{"$$customer", nil}},
+			}}}}},
+			bson.M{"$sort": bson.D{{Key: "discount", Value: -1}, {Key: "_id", Value: 1}}},
+		}
+
+		cursor, err := couponRedemptionCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while adding up coupon use"))
+			return
+		}
+		usage := []CouponUsage{}
+		if err := cursor.All(ctx, &usage); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding coupon use"))
+			return
+		}
+		for i := range usage {
+			usage[i].Discount = toFixed(usage[i].Discount, 2)
+		}
+
+		c.JSON(http.StatusOK, usage)
+	}
+}
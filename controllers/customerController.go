@@ -0,0 +1,728 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"restorent-management/apperrors"
+	"restorent-management/database"
+	"restorent-management/models"
+	"restorent-management/query"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var customerCollection *mongo.Collection = database.OpenCollection(database.Client, "customer")
+
+// checkCustomer checks that a customer linked to an order, coupon
+// redemption or gift card exists. No customer is fine.
+func checkCustomer(ctx context.Context, customerID *string) error {
+	if customerID == nil {
+		return nil
+	}
+	err := customerCollection.FindOne(ctx, notDeleted(bson.M{"customer_id": *customerID})).Err()
+	if err == mongo.ErrNoDocuments {
+		return apperrors.BadRequest("Customer was not found")
+	} else if err != nil {
+		return apperrors.Internal(err, "error occured while fetching the customer")
+	}
+	return nil
+}
+
+// findDuplicateCustomer returns another customer with the same phone or
+// email, if there is one.
+func findDuplicateCustomer(ctx context.Context, customer models.Customer) (*models.Customer, error) {
+	var same bson.A
+	if customer.Phone != nil {
+		same = append(same, bson.M{"phone": *customer.Phone})
+	}
+	if customer.Email != nil {
+		same = append(same, bson.M{"email": *customer.Email})
+	}
+	filter := notDeleted(bson.M{"$or": same, "customer_id": bson.M{"$ne": customer.Customer_id}})
+
+	var duplicate models.Customer
+	err := customerCollection.FindOne(ctx, filter).Decode(&duplicate)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, apperrors.Internal(err, "error occured while checking for duplicate customers")
+	}
+	return &duplicate, nil
+}
+
+// duplicateCustomerError says which customer already has the phone or
+// email, so the client can use or merge it instead.
+func duplicateCustomerError(customer, duplicate models.Customer) error {
+	if customer.Phone != nil && duplicate.Phone != nil && *customer.Phone == *duplicate.Phone {
+		return apperrors.Conflict("customer " + duplicate.Customer_id + " already has this phone number")
+	}
+	return apperrors.Conflict("customer " + duplicate.Customer_id + " already has this email")
+}
+
+// duplicateKeyCustomerError is the answer when the unique indexes catch a
+// duplicate the check above missed, either because of a concurrent request
+// or because the duplicate is deleted.
+func duplicateKeyCustomerError(err error) error {
+	if strings.Contains(err.Error(), "customer_phone_unique") {
+		return apperrors.Conflict("this phone number is already in use")
+	}
+	return apperrors.Conflict("this email is already in use")
+}
+
+func CreateCustomer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var customer models.Customer
+		if err := c.ShouldBindJSON(&customer); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+
+		customer.Normalize()
+		if err := validate.Struct(customer); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		duplicate, err := findDuplicateCustomer(ctx, customer)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if duplicate != nil {
+			c.Error(duplicateCustomerError(customer, *duplicate))
+			return
+		}
+
+		now := time.Now()
+		customer.Created_at = now
+		customer.Updated_at = now
+		customer.ID = primitive.NewObjectID()
+		customer.Version = 1
+		customer.Customer_id = customer.ID.Hex()
+		customer.Merged_into = nil
+		customer.Merging_from = nil
+		customer.Loyalty = models.LoyaltyAccount{}
+
+		result, err := customerCollection.InsertOne(ctx, customer)
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				c.Error(duplicateKeyCustomerError(err))
+				return
+			}
+			c.Error(apperrors.Internal(err, "customer was not created"))
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+var customerListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "name", Field: "name", Kind: query.String},
+		{Param: "phone", Field: "phone", Kind: query.String},
+		{Param: "email", Field: "email", Kind: query.String},
+		{Param: "allergy", Field: "allergies", Kind: query.String},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"name", "created_at"},
+	DefaultSort: "name",
+}
+
+func GetCustomers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, customerListSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		notDeleted(list.Filter)
+		// Phone and email filters match however the number or address was typed
+		normalizeFilter(list.Filter, "phone", models.NormalizePhone)
+		normalizeFilter(list.Filter, "email", models.NormalizeEmail)
+
+		page, err := query.Find[models.Customer](ctx, customerCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing customers"))
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// normalizeFilter applies normalize to the value, or each of the values, a
+// list filter matches field against.
+func normalizeFilter(filter bson.M, field string, normalize func(string) string) {
+	switch value := filter[field].(type) {
+	case string:
+		filter[field] = normalize(value)
+	case bson.M:
+		if values, ok := value["$in"].(bson.A); ok {
+			for i := range values {
+				if s, ok := values[i].(string); ok {
+					values[i] = normalize(s)
+				}
+			}
+		}
+	}
+}
+
+func GetCustomerByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var customer models.Customer
+		err := customerCollection.FindOne(ctx, notDeleted(bson.M{"customer_id": c.Param("customer_id")})).Decode(&customer)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Customer not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the customer"))
+			}
+			return
+		}
+		if notModified(c, customer.Version) {
+			return
+		}
+
+		c.JSON(http.StatusOK, customer)
+	}
+}
+
+func UpdateCustomer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var customer models.Customer
+		filter := notDeleted(bson.M{"customer_id": c.Param("customer_id")})
+
+		err := customerCollection.FindOne(ctx, filter).Decode(&customer)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Customer not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the customer"))
+			}
+			return
+		}
+		original := customer
+
+		if err := checkIfMatch(c, customer.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		if err := applyMergePatch(c, &customer); err != nil {
+			c.Error(err)
+			return
+		}
+
+		customer.ID = original.ID
+		customer.Customer_id = original.Customer_id
+		customer.Created_at = original.Created_at
+		customer.Version = original.Version + 1
+		customer.Deleted_at = original.Deleted_at
+		customer.Deleted_by = original.Deleted_by
+		customer.Merged_into = original.Merged_into
+		customer.Merging_from = original.Merging_from
+		// Points only move through the loyalty ledger. Each move bumps the
+		// version, so the replace below cannot undo one it did not see.
+		customer.Loyalty = original.Loyalty
+
+		customer.Normalize()
+		if err := validate.Struct(customer); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		duplicate, err := findDuplicateCustomer(ctx, customer)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if duplicate != nil {
+			c.Error(duplicateCustomerError(customer, *duplicate))
+			return
+		}
+
+		customer.Updated_at = time.Now()
+
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = customerCollection.FindOneAndReplace(ctx, filter, customer, opts).Decode(&customer)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Customer was modified by another request"))
+			} else if mongo.IsDuplicateKeyError(err) {
+				c.Error(duplicateKeyCustomerError(err))
+			} else {
+				c.Error(apperrors.Internal(err, "Customer update failed"))
+			}
+			return
+		}
+
+		c.Header("ETag", etag(customer.Version))
+		c.JSON(http.StatusOK, customer)
+	}
+}
+
+// MergeCustomerRequest is the body of POST /customers/:customer_id/merge.
+type MergeCustomerRequest struct {
+	Duplicate_id string `json:"duplicate_id" validate:"required"`
+}
+
+// customerLinks are the collections that link to customers by customer_id,
+// all moved to the kept customer by a merge.
+var customerLinks = []*mongo.Collection{orderCollection, invoiceCollection, couponRedemptionCollection, giftCardCollection, loyaltyEntryCollection, feedbackCollection}
+
+// MergeCustomers handles POST /customers/:customer_id/merge, folding a
+// duplicate profile into this one. The kept customer takes the duplicate's
+// phone, email and notes where it has none, and both customers'
+// preferences, allergies and loyalty points. The duplicate's orders,
+// invoices, coupon redemptions, gift cards, loyalty ledger and feedback move
+// to the kept customer, and the duplicate is deleted.
+// The kept customer records the merge until every record has moved, so a
+// merge that stops partway is finished by making the same request again.
+// If-Match is checked against the kept customer.
+func MergeCustomers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		var request MergeCustomerRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+		if request.Duplicate_id == c.Param("customer_id") {
+			c.Error(apperrors.BadRequest("a customer cannot be merged into itself"))
+			return
+		}
+
+		var customer, duplicate models.Customer
+		filter := notDeleted(bson.M{"customer_id": c.Param("customer_id")})
+		err := customerCollection.FindOne(ctx, filter).Decode(&customer)
+		if err == nil && customer.Merging_from == nil {
+			err = customerCollection.FindOne(ctx, notDeleted(bson.M{"customer_id": request.Duplicate_id})).Decode(&duplicate)
+		}
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Customer not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the customers"))
+			}
+			return
+		}
+		if err := checkIfMatch(c, customer.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		// A merge that stopped partway is finished before any other
+		if customer.Merging_from != nil {
+			if *customer.Merging_from != request.Duplicate_id {
+				c.Error(apperrors.Conflict("the merge of customer " + *customer.Merging_from + " into this one has not finished; merge it again first"))
+				return
+			}
+			customer, err = finishCustomerMerge(ctx, customer.Customer_id, request.Duplicate_id)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+			c.Header("ETag", etag(customer.Version))
+			c.JSON(http.StatusOK, customer)
+			return
+		}
+		original := customer
+
+		if customer.Phone == nil {
+			customer.Phone = duplicate.Phone
+		}
+		if customer.Email == nil {
+			customer.Email = duplicate.Email
+		}
+		if customer.Notes == nil {
+			customer.Notes = duplicate.Notes
+		}
+		for _, preference := range duplicate.Preferences {
+			if !slices.Contains(customer.Preferences, preference) && len(customer.Preferences) < 20 {
+				customer.Preferences = append(customer.Preferences, preference)
+			}
+		}
+		for _, allergy := range duplicate.Allergies {
+			if !slices.Contains(customer.Allergies, allergy) {
+				customer.Allergies = append(customer.Allergies, allergy)
+			}
+		}
+		customer.Loyalty.Points += duplicate.Loyalty.Points
+		customer.Loyalty.Lifetime_points += duplicate.Loyalty.Lifetime_points
+		if last := duplicate.Loyalty.Last_activity_at; last != nil && (customer.Loyalty.Last_activity_at == nil || last.After(*customer.Loyalty.Last_activity_at)) {
+			customer.Loyalty.Last_activity_at = last
+		}
+
+		// The duplicate gives up its phone and email first, so the kept
+		// customer can take them without tripping the unique indexes, and
+		// its points, which go with its ledger
+		now := time.Now()
+		duplicateFilter := bson.M{"customer_id": duplicate.Customer_id, "version": duplicate.Version}
+		result, err := customerCollection.UpdateOne(ctx, duplicateFilter, bson.M{
+			"$set":   bson.M{"deleted_at": now, "deleted_by": c.GetString("uid"), "merged_into": customer.Customer_id, "loyalty": models.LoyaltyAccount{}, "updated_at": now},
+			"$unset": bson.M{"phone": "", "email": ""},
+			"$inc":   bson.M{"version": 1},
+		})
+		if err != nil {
+			c.Error(apperrors.Internal(err, "duplicate customer was not removed"))
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.Error(apperrors.PreconditionFailed("Customer was modified by another request"))
+			return
+		}
+
+		customer.Version = original.Version + 1
+		customer.Updated_at = now
+		customer.Merging_from = &duplicate.Customer_id
+		filter["version"] = original.Version
+		err = customerCollection.FindOneAndReplace(ctx, filter, customer).Err()
+		if err != nil {
+			// Put the duplicate back as it was
+			if _, rollbackErr := customerCollection.ReplaceOne(ctx, bson.M{"customer_id": duplicate.Customer_id}, duplicate); rollbackErr != nil {
+				log.Printf("customers: duplicate %s could not be restored after a failed merge into %s: %v", duplicate.Customer_id, customer.Customer_id, rollbackErr)
+				c.Error(apperrors.Internal(rollbackErr, "Customer merge failed and the duplicate customer could not be restored"))
+				return
+			}
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Customer was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "Customer merge failed"))
+			}
+			return
+		}
+
+		customer, err = finishCustomerMerge(ctx, customer.Customer_id, duplicate.Customer_id)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.Header("ETag", etag(customer.Version))
+		c.JSON(http.StatusOK, customer)
+	}
+}
+
+// finishCustomerMerge moves a merged duplicate's records to the kept
+// customer and then clears the merge from it. Each step can be run again,
+// so a merge that failed partway is finished by calling it again.
+func finishCustomerMerge(ctx context.Context, customerID, duplicateID string) (models.Customer, error) {
+	var customer models.Customer
+	for _, collection := range customerLinks {
+		_, err := collection.UpdateMany(ctx,
+			bson.M{"customer_id": duplicateID},
+			bson.M{"$set": bson.M{"customer_id": customerID}},
+		)
+		if err != nil {
+			return customer, apperrors.Internal(err, "the duplicate customer's records were not moved; merge again to finish")
+		}
+	}
+	if err := moveCouponUses(ctx, duplicateID, customerID); err != nil {
+		return customer, apperrors.Internal(err, "the duplicate customer's coupon uses were not moved; merge again to finish")
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := customerCollection.FindOneAndUpdate(ctx,
+		bson.M{"customer_id": customerID, "merging_from": duplicateID},
+		bson.M{"$set": bson.M{"merging_from": nil, "updated_at": time.Now()}, "$inc": bson.M{"version": 1}},
+		opts,
+	).Decode(&customer)
+	if err == mongo.ErrNoDocuments {
+		// Another request finished the merge first
+		err = customerCollection.FindOne(ctx, bson.M{"customer_id": customerID}).Decode(&customer)
+	}
+	if err != nil {
+		return customer, apperrors.Internal(err, "error occured while finishing the merge")
+	}
+	return customer, nil
+}
+
+// moveCouponUses counts a merged customer's coupon uses as the kept
+// customer's, so a merge cannot be used to get around per-customer limits.
+func moveCouponUses(ctx context.Context, from, to string) error {
+	cursor, err := couponCollection.Find(ctx, bson.M{"customer_uses." + from: bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	var coupons []models.Coupon
+	if err := cursor.All(ctx, &coupons); err != nil {
+		return err
+	}
+	for _, coupon := range coupons {
+		// The uses are only moved if they are still there, so running
+		// this twice does not count them twice
+		filter := bson.M{"coupon_id": coupon.Coupon_id, "customer_uses." + from: bson.M{"$exists": true}}
+		_, err := couponCollection.UpdateOne(ctx, filter, bson.M{
+			"$inc":   bson.M{"customer_uses." + to: coupon.Customer_uses[from], "version": 1},
+			"$unset": bson.M{"customer_uses." + from: ""},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CustomerVisit is one of a customer's orders and what its invoice came
+// to. Orders without an invoice have no payment status.
+type CustomerVisit struct {
+	Order_id       string    `json:"order_id"`
+	Order_date     time.Time `json:"order_date"`
+	Table_id       *string   `json:"table_id"`
+	Total          float64   `json:"total"`
+	Payment_status *string   `json:"payment_status"`
+}
+
+// FavouriteItem is a food a customer has ordered, and how many times.
+type FavouriteItem struct {
+	Food_id   string `json:"food_id" bson:"_id"`
+	Food_name string `json:"food_name" bson:"food_name"`
+	Quantity  int    `json:"quantity" bson:"quantity"`
+}
+
+// CustomerProfile is what GET /customers/:customer_id/profile returns.
+type CustomerProfile struct {
+	Customer        models.Customer `json:"customer"`
+	Visits          int             `json:"visits"`
+	First_visit     *time.Time      `json:"first_visit"`
+	Last_visit      *time.Time      `json:"last_visit"`
+	Lifetime_spend  float64         `json:"lifetime_spend"`
+	Favourite_items []FavouriteItem `json:"favourite_items"`
+	Recent_visits   []CustomerVisit `json:"recent_visits"`
+}
+
+const (
+	profileRecentVisits   = 10
+	profileFavouriteItems = 5
+)
+
+// GetCustomerProfile handles GET /customers/:customer_id/profile: the
+// customer with their visit history, lifetime spend and the foods they
+// order most. Spend adds up the totals of their paid invoices, so every
+// discount, coupon and point redeemed is taken off and refunds are left
+// out. Foods ordered in a bundle count as ordered.
+func GetCustomerProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		var profile CustomerProfile
+		err := customerCollection.FindOne(ctx, notDeleted(bson.M{"customer_id": c.Param("customer_id")})).Decode(&profile.Customer)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Customer not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the customer"))
+			}
+			return
+		}
+
+		opts := options.Find().
+			SetSort(bson.D{{Key: "order_date", Value: -1}}).
+			SetProjection(bson.M{"order_id": 1, "order_date": 1, "table_id": 1})
+		cursor, err := orderCollection.Find(ctx, notDeleted(bson.M{"customer_id": profile.Customer.Customer_id}), opts)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while fetching the customer's orders"))
+			return
+		}
+		var orders []models.Order
+		if err := cursor.All(ctx, &orders); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding the customer's orders"))
+			return
+		}
+
+		orderIDs := make([]string, len(orders))
+		for i, order := range orders {
+			orderIDs[i] = order.Order_id
+		}
+		if profile.Lifetime_spend, err = lifetimeSpend(ctx, orderIDs); err != nil {
+			c.Error(err)
+			return
+		}
+
+		profile.Visits = len(orders)
+		if profile.Recent_visits, err = customerVisits(ctx, orders[:min(len(orders), profileRecentVisits)]); err != nil {
+			c.Error(err)
+			return
+		}
+		if len(orders) > 0 {
+			profile.Last_visit = &orders[0].Order_Date
+			profile.First_visit = &orders[len(orders)-1].Order_Date
+		}
+
+		pipeline := bson.A{bson.M{"$match": bson.M{"order_id": bson.M{"$in": orderIDs}, "deleted_at": nil}}}
+		pipeline = append(pipeline, soldFoodStages...)
+		pipeline = append(pipeline,
+			bson.M{"$group": bson.M{"_id": "$food_id", "quantity": bson.M{"$sum": 1}}},
+			bson.M{"$sort": bson.D{{Key: "quantity", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": profileFavouriteItems},
+			bson.M{"$lookup": bson.M{"from": "food", "localField": "_id", "foreignField": "food_id", "as": "food"}},
+			bson.M{"$set": bson.M{"food_name": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$food.name", 0}}, ""}}}},
+		)
+		cursor, err = orderItemCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while finding the customer's favourite items"))
+			return
+		}
+		profile.Favourite_items = []FavouriteItem{}
+		if err := cursor.All(ctx, &profile.Favourite_items); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding the customer's favourite items"))
+			return
+		}
+
+		c.JSON(http.StatusOK, profile)
+	}
+}
+
+// lifetimeSpend adds up the totals of the paid invoices of a customer's
+// orders in one aggregation, working each out the way viewInvoice does:
+// the items less the order wide discounts fixed when it was paid, then
+// the coupon and the points redeemed, never going below zero.
+func lifetimeSpend(ctx context.Context, orderIDs []string) (float64, error) {
+	if len(orderIDs) == 0 {
+		return 0, nil
+	}
+
+	due := bson.M{"$subtract": bson.A{bson.M{"$sum": "$items.amount"}, bson.M{"$sum": "$order_discounts.amount"}}}
+	due = bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{due, bson.M{"$ifNull": bson.A{"$coupon.discount", 0}}}}}}
+	due = bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{due, bson.M{"$ifNull": bson.A{"$points_redeemed.discount", 0}}}}}}
+	cursor, err := invoiceCollection.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"order_id": bson.M{"$in": orderIDs}, "payment_status": "PAID", "deleted_at": nil}},
+		bson.M{"$lookup": bson.M{
+			"from": "orderItem",
+			"let":  bson.M{"order_id": "$order_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$order_id", "$$order_id"}}, "deleted_at": nil}},
+				bson.M{"$lookup": bson.M{"from": "food", "localField": "food_id", "foreignField": "food_id", "as": "food"}},
+				bson.M{"$project": bson.M{"amount": bson.M{"$ifNull": bson.A{"$unit_price", bson.M{"$arrayElemAt": bson.A{"$food.price", 0}}}}}},
+			},
+			"as": "items",
+		}},
+		bson.M{"$group": bson.M{"_id": nil, "spend": bson.M{"$sum": bson.M{"$round": bson.A{due, 2}}}}},
+	})
+	if err != nil {
+		return 0, apperrors.Internal(err, "error occured while adding up the customer's spend")
+	}
+	var totals []struct {
+		Spend float64 `bson:"spend"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return 0, apperrors.Internal(err, "error occured while decoding the customer's spend")
+	}
+	if len(totals) == 0 {
+		return 0, nil
+	}
+	return toFixed(totals[0].Spend, 2), nil
+}
+
+// customerVisits lists orders as visits, with what their invoices came to
+// priced the way GET /invoices/:invoice_id prices them.
+func customerVisits(ctx context.Context, orders []models.Order) ([]CustomerVisit, error) {
+	visits := []CustomerVisit{}
+	if len(orders) == 0 {
+		return visits, nil
+	}
+	orderIDs := make([]string, len(orders))
+	for i, order := range orders {
+		orderIDs[i] = order.Order_id
+	}
+
+	cursor, err := invoiceCollection.Find(ctx, notDeleted(bson.M{"order_id": bson.M{"$in": orderIDs}}))
+	if err != nil {
+		return nil, apperrors.Internal(err, "error occured while fetching the customer's invoices")
+	}
+	var invoices []models.Invoice
+	if err := cursor.All(ctx, &invoices); err != nil {
+		return nil, apperrors.Internal(err, "error occured while decoding the customer's invoices")
+	}
+
+	for _, order := range orders {
+		visit := CustomerVisit{Order_id: order.Order_id, Order_date: order.Order_Date, Table_id: order.Table_id}
+		for _, invoice := range invoices {
+			if invoice.Order_id != order.Order_id {
+				continue
+			}
+			view, err := viewInvoice(ctx, invoice)
+			if err != nil {
+				return nil, err
+			}
+			visit.Total = toFixed(visit.Total+view.Total, 2)
+			visit.Payment_status = view.Payment_status
+		}
+		visits = append(visits, visit)
+	}
+	return visits, nil
+}
+
+var customerVisitSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "order_date", Field: "order_date", Kind: query.Date},
+	},
+	Sortable:    []string{"order_date"},
+	DefaultSort: "-order_date",
+}
+
+// GetCustomerVisits handles GET /customers/:customer_id/visits, a page of
+// the customer's orders with what each came to. The profile only shows
+// the most recent ones.
+func GetCustomerVisits() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, customerVisitSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		list.Filter["customer_id"] = c.Param("customer_id")
+		notDeleted(list.Filter)
+
+		orders, err := query.Find[models.Order](ctx, orderCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing the customer's orders"))
+			return
+		}
+		visits, err := customerVisits(ctx, orders.Data)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, query.Page[CustomerVisit]{
+			Data:        visits,
+			Total_count: orders.Total_count,
+			Next_cursor: orders.Next_cursor,
+			Limit:       orders.Limit,
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"restorent-management/apperrors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etag formats a document version as a strong entity tag.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// viewETag tags a response built from more than its own document, such as
+// an invoice priced from its order's items. It carries the document version
+// and a hash of the body, so it changes when either does. Writes match it by
+// the version alone.
+func viewETag(version int64, body interface{}) (string, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return `"` + strconv.FormatInt(version, 10) + "-" + hex.EncodeToString(sum[:8]) + `"`, nil
+}
+
+// matchesViewVersion reports whether an If-Match header holds a view tag
+// of version.
+func matchesViewVersion(header string, version int64) bool {
+	prefix := `"` + strconv.FormatInt(version, 10) + "-"
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.HasPrefix(strings.TrimPrefix(strings.TrimSpace(candidate), "W/"), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesETag reports whether an If-Match or If-None-Match header value
+// matches tag. Weak tags are compared by their opaque value.
+func matchesETag(header string, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag header for a read and writes 304 when the
+// client's If-None-Match already holds this version.
+func notModified(c *gin.Context, version int64) bool {
+	return notModifiedTag(c, etag(version))
+}
+
+// notModifiedTag is notModified for a tag made by viewETag.
+func notModifiedTag(c *gin.Context, tag string) bool {
+	c.Header("ETag", tag)
+
+	if header := c.GetHeader("If-None-Match"); header != "" && matchesETag(header, tag) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// checkIfMatch enforces the If-Match precondition on writes. It fails with
+// 428 when the header is missing and 412 when it names another version.
+func checkIfMatch(c *gin.Context, version int64) error {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return apperrors.PreconditionRequired("If-Match header is required")
+	}
+
+	if !matchesETag(header, etag(version)) && !matchesViewVersion(header, version) {
+		c.Header("ETag", etag(version))
+		return apperrors.PreconditionFailed("resource has been modified")
+	}
+	return nil
+}
@@ -0,0 +1,500 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"restorent-management/apperrors"
+	"restorent-management/database"
+	"restorent-management/models"
+	"restorent-management/query"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var feedbackCollection *mongo.Collection = database.OpenCollection(database.Client, "feedback")
+
+// feedbackLinkLifetime is how long a guest has to use the link on their
+// receipt.
+const feedbackLinkLifetime = 30 * 24 * time.Hour
+
+// feedbackBaseURL is what feedback tokens are appended to for the link
+// printed on receipts. It defaults to a path on this server.
+func feedbackBaseURL() string {
+	if url := os.Getenv("FEEDBACK_BASE_URL"); url != "" {
+		return url
+	}
+	return "/feedback/"
+}
+
+// newFeedbackToken returns a random token and the hash it is stored as.
+func newFeedbackToken() (string, string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+	return token, hashFeedbackToken(token), nil
+}
+
+func hashFeedbackToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// FeedbackLink is the link printed on a receipt. The token is only ever
+// returned here.
+type FeedbackLink struct {
+	Token      string    `json:"token"`
+	Url        string    `json:"url"`
+	Expires_at time.Time `json:"expires_at"`
+}
+
+// IssueFeedbackToken handles POST /invoices/:invoice_id/feedbackToken, the
+// one-time link for the invoice's receipt. Issuing a new link replaces the
+// one issued before, which stops working.
+func IssueFeedbackToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var invoice models.Invoice
+		err := invoiceCollection.FindOne(ctx, notDeleted(bson.M{"invoice_id": c.Param("invoice_id")})).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Invoice not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the invoice"))
+			}
+			return
+		}
+		if err := checkIfMatch(c, invoice.Version); err != nil {
+			c.Error(err)
+			return
+		}
+		if *invoice.Payment_status == "REFUNDED" {
+			c.Error(apperrors.Conflict("refunded invoices cannot be given feedback"))
+			return
+		}
+		if invoice.Feedback_token != nil && invoice.Feedback_token.Used_at != nil {
+			c.Error(apperrors.Conflict("feedback was already given for this invoice"))
+			return
+		}
+
+		token, hash, err := newFeedbackToken()
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while generating the feedback token"))
+			return
+		}
+		now := time.Now()
+		feedbackToken := models.FeedbackToken{
+			Token_hash: hash,
+			Issued_at:  now,
+			Expires_at: now.Add(feedbackLinkLifetime),
+		}
+
+		filter := notDeleted(bson.M{"invoice_id": invoice.Invoice_id, "version": invoice.Version})
+		update := bson.M{
+			"$set": bson.M{"feedback_token": feedbackToken, "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		}
+		result, err := invoiceCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while saving the feedback token"))
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.Error(apperrors.PreconditionFailed("invoice was modified by another request"))
+			return
+		}
+
+		c.Header("ETag", etag(invoice.Version+1))
+		c.JSON(http.StatusCreated, FeedbackLink{
+			Token:      token,
+			Url:        feedbackBaseURL() + token,
+			Expires_at: feedbackToken.Expires_at,
+		})
+	}
+}
+
+// feedbackInvoice finds the invoice a feedback token was issued for. Tokens
+// that were used or have expired are gone rather than not found, so guests
+// can be told why their link no longer works.
+func feedbackInvoice(ctx context.Context, token string) (models.Invoice, error) {
+	var invoice models.Invoice
+	err := invoiceCollection.FindOne(ctx, notDeleted(bson.M{"feedback_token.token_hash": hashFeedbackToken(token)})).Decode(&invoice)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return invoice, apperrors.NotFound("Feedback link not found")
+		}
+		return invoice, apperrors.Internal(err, "error occured while fetching the invoice")
+	}
+	if invoice.Feedback_token.Used_at != nil {
+		return invoice, apperrors.Gone("feedback was already given with this link")
+	}
+	if !time.Now().Before(invoice.Feedback_token.Expires_at) {
+		return invoice, apperrors.Gone("feedback link has expired")
+	}
+	return invoice, nil
+}
+
+// FeedbackDish is an item of the order a guest can rate.
+type FeedbackDish struct {
+	Order_item_id string  `json:"order_item_id"`
+	Food_id       string  `json:"food_id"`
+	Food_name     string  `json:"food_name"`
+	Quantity      *string `json:"quantity"`
+}
+
+// FeedbackForm is what a guest sees when they open their feedback link.
+type FeedbackForm struct {
+	Invoice_id string         `json:"invoice_id"`
+	Dishes     []FeedbackDish `json:"dishes"`
+	Expires_at time.Time      `json:"expires_at"`
+}
+
+// feedbackDishes lists the items of an order with the names of their foods.
+func feedbackDishes(ctx context.Context, orderID string) ([]FeedbackDish, error) {
+	var items []models.OrderItem
+	cursor, err := orderItemCollection.Find(ctx, notDeleted(bson.M{"order_id": orderID}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	foodIDs := []string{}
+	for _, item := range items {
+		foodIDs = append(foodIDs, *item.Food_id)
+	}
+	var foods []models.Food
+	cursor, err = foodCollection.Find(ctx, bson.M{"food_id": bson.M{"$in": foodIDs}})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &foods); err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, food := range foods {
+		names[food.Food_id] = *food.Name
+	}
+
+	dishes := []FeedbackDish{}
+	for _, item := range items {
+		dishes = append(dishes, FeedbackDish{
+			Order_item_id: item.Order_item_id,
+			Food_id:       *item.Food_id,
+			Food_name:     names[*item.Food_id],
+			Quantity:      item.Quantity,
+		})
+	}
+	return dishes, nil
+}
+
+// GetFeedbackForm handles GET /feedback/:token, which needs no account: the
+// token from the receipt is all a guest has.
+func GetFeedbackForm() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		invoice, err := feedbackInvoice(ctx, c.Param("token"))
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		dishes, err := feedbackDishes(ctx, invoice.Order_id)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing the order items"))
+			return
+		}
+
+		c.JSON(http.StatusOK, FeedbackForm{
+			Invoice_id: invoice.Invoice_id,
+			Dishes:     dishes,
+			Expires_at: invoice.Feedback_token.Expires_at,
+		})
+	}
+}
+
+// trimComment drops blank comments.
+func trimComment(comment *string) *string {
+	if comment == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*comment)
+	if trimmed == "" {
+		return nil
+	}
+	return &trimmed
+}
+
+// checkDishRatings fills in the food of each rated dish, which must be an
+// item of the order and be rated once.
+func checkDishRatings(ratings []models.DishRating, dishes []FeedbackDish) error {
+	byItem := map[string]FeedbackDish{}
+	for _, dish := range dishes {
+		byItem[dish.Order_item_id] = dish
+	}
+
+	var fields []apperrors.FieldError
+	rated := map[string]bool{}
+	for i := range ratings {
+		dish, ok := byItem[ratings[i].Order_item_id]
+		switch {
+		case !ok:
+			fields = append(fields, apperrors.FieldError{Field: fmt.Sprintf("Dishes[%d].Order_item_id", i), Rule: "exists", Message: "is not an item of this order"})
+		case rated[dish.Order_item_id]:
+			fields = append(fields, apperrors.FieldError{Field: fmt.Sprintf("Dishes[%d].Order_item_id", i), Rule: "unique", Message: "is rated more than once"})
+		default:
+			rated[dish.Order_item_id] = true
+			ratings[i].Food_id = dish.Food_id
+			ratings[i].Food_name = dish.Food_name
+			ratings[i].Comment = trimComment(ratings[i].Comment)
+		}
+	}
+
+	if len(fields) > 0 {
+		return apperrors.InvalidFields(fields)
+	}
+	return nil
+}
+
+// addFoodRating adds a dish rating to its food's running average. The
+// version is bumped since GET /foods/:food_id shows the rating.
+func addFoodRating(ctx context.Context, foodID string, rating int) error {
+	update := bson.A{
+		bson.M{"$set": bson.M{
+			"rating.count": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$rating.count", 0}}, 1}},
+			"rating.total": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$rating.total", 0}}, rating}},
+			"version":      bson.M{"$add": bson.A{"$version", 1}},
+		}},
+		bson.M{"$set": bson.M{
+			"rating.average": bson.M{"$round": bson.A{bson.M{"$divide": bson.A{"$rating.total", "$rating.count"}}, 2}},
+		}},
+	}
+	_, err := foodCollection.UpdateOne(ctx, bson.M{"food_id": foodID}, update)
+	return err
+}
+
+// SubmitFeedback handles POST /feedback/:token. The token is used up by the
+// first feedback given with it.
+func SubmitFeedback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var feedback models.Feedback
+		if err := c.ShouldBindJSON(&feedback); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		if err := validate.Struct(feedback); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		invoice, err := feedbackInvoice(ctx, c.Param("token"))
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		dishes, err := feedbackDishes(ctx, invoice.Order_id)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing the order items"))
+			return
+		}
+		if err := checkDishRatings(feedback.Dishes, dishes); err != nil {
+			c.Error(err)
+			return
+		}
+
+		// Use up the token first so the same link cannot be used twice
+		// by requests that arrive together
+		now := time.Now()
+		filter := notDeleted(bson.M{
+			"invoice_id":                invoice.Invoice_id,
+			"feedback_token.token_hash": invoice.Feedback_token.Token_hash,
+			"feedback_token.used_at":    nil,
+		})
+		result, err := invoiceCollection.UpdateOne(ctx, filter, bson.M{
+			"$set": bson.M{"feedback_token.used_at": now},
+			"$inc": bson.M{"version": 1},
+		})
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while using the feedback token"))
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.Error(apperrors.Gone("feedback was already given with this link"))
+			return
+		}
+
+		feedback.ID = primitive.NewObjectID()
+		feedback.Feedback_id = feedback.ID.Hex()
+		feedback.Invoice_id = invoice.Invoice_id
+		feedback.Order_id = invoice.Order_id
+		feedback.Customer_id = invoice.Customer_id
+		feedback.Comment = trimComment(feedback.Comment)
+		feedback.Created_at = now
+		if feedback.Dishes == nil {
+			feedback.Dishes = []models.DishRating{}
+		}
+
+		if _, err := feedbackCollection.InsertOne(ctx, feedback); err != nil {
+			// Give the token back so the guest can try again
+			invoiceCollection.UpdateOne(ctx, bson.M{"invoice_id": invoice.Invoice_id, "feedback_token.token_hash": invoice.Feedback_token.Token_hash}, bson.M{
+				"$set": bson.M{"feedback_token.used_at": nil},
+				"$inc": bson.M{"version": 1},
+			})
+			c.Error(apperrors.Internal(err, "feedback was not saved"))
+			return
+		}
+		for _, dish := range feedback.Dishes {
+			if err := addFoodRating(ctx, dish.Food_id, dish.Rating); err != nil {
+				c.Error(apperrors.Internal(err, "error occured while rating "+dish.Food_name))
+				return
+			}
+		}
+
+		c.JSON(http.StatusCreated, feedback)
+	}
+}
+
+var feedbackListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "invoice_id", Field: "invoice_id", Kind: query.String},
+		{Param: "customer_id", Field: "customer_id", Kind: query.String},
+		{Param: "food_id", Field: "dishes.food_id", Kind: query.String},
+		{Param: "overall_rating", Field: "overall_rating", Kind: query.Number},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"created_at", "overall_rating"},
+	DefaultSort: "-created_at",
+}
+
+func GetFeedback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, feedbackListSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+
+		page, err := query.Find[models.Feedback](ctx, feedbackCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing feedback"))
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// DishFeedback is how one food was rated over a period.
+type DishFeedback struct {
+	Food_id   string  `json:"food_id" bson:"_id"`
+	Food_name string  `json:"food_name" bson:"food_name"`
+	Ratings   int     `json:"ratings" bson:"ratings"`
+	Average   float64 `json:"average" bson:"average"`
+}
+
+// FeedbackReport is the feedback given over a period.
+type FeedbackReport struct {
+	From         time.Time      `json:"from"`
+	To           time.Time      `json:"to"`
+	Responses    int            `json:"responses"`
+	Average      float64        `json:"average"`
+	Distribution map[string]int `json:"distribution"`
+	Dishes       []DishFeedback `json:"dishes"`
+}
+
+// GetFeedbackReport handles GET /feedback/report?from=&to=, the feedback
+// given between two RFC 3339 times (the last 30 days by default): how many
+// guests answered, their overall ratings and how each dish was rated, worst
+// first.
+func GetFeedbackReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		from, to, err := reportPeriod(c)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		match := bson.M{"$match": bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}}
+
+		cursor, err := feedbackCollection.Aggregate(ctx, bson.A{
+			match,
+			bson.M{"$group": bson.M{"_id": "$overall_rating", "responses": bson.M{"$sum": 1}}},
+		})
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while adding up ratings"))
+			return
+		}
+		var ratings []struct {
+			Rating    int `bson:"_id"`
+			Responses int `bson:"responses"`
+		}
+		if err := cursor.All(ctx, &ratings); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding ratings"))
+			return
+		}
+
+		report := FeedbackReport{From: from, To: to, Distribution: map[string]int{}, Dishes: []DishFeedback{}}
+		for rating := 1; rating <= 5; rating++ {
+			report.Distribution[strconv.Itoa(rating)] = 0
+		}
+		total := 0
+		for _, rating := range ratings {
+			report.Distribution[strconv.Itoa(rating.Rating)] = rating.Responses
+			report.Responses += rating.Responses
+			total += rating.Rating * rating.Responses
+		}
+		if report.Responses > 0 {
+			report.Average = toFixed(float64(total)/float64(report.Responses), 2)
+		}
+
+		cursor, err = feedbackCollection.Aggregate(ctx, bson.A{
+			match,
+			bson.M{"$unwind": "$dishes"},
+			bson.M{"$group": bson.M{
+				"_id":       "$dishes.food_id",
+				"food_name": bson.M{"$last": "$dishes.food_name"},
+				"ratings":   bson.M{"$sum": 1},
+				"average":   bson.M{"$avg": "$dishes.rating"},
+			}},
+			bson.M{"$sort": bson.D{{Key: "average", Value: 1}, {Key: "ratings", Value: -1}, {Key: "_id", Value: 1}}},
+		})
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while adding up dish ratings"))
+			return
+		}
+		if err := cursor.All(ctx, &report.Dishes); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding dish ratings"))
+			return
+		}
+		for i := range report.Dishes {
+			report.Dishes[i].Average = toFixed(report.Dishes[i].Average, 2)
+		}
+
+		c.JSON(http.StatusOK, report)
+	}
+}
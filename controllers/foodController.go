@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"restorent-management/apperrors"
 	"restorent-management/database"
 	"restorent-management/models"
-	"strconv"
+	"restorent-management/query"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -27,6 +30,101 @@ func round(num float64) int {
 	return int(num + math.Copysign(0.5, num))
 }
 
+// prepareModifierGroups checks that each group's selection limits can be
+// met and gives new groups and options an ID. IDs already set are kept so
+// that existing order items still refer to the same options.
+func prepareModifierGroups(groups []models.ModifierGroup) error {
+	var fields []apperrors.FieldError
+	for i := range groups {
+		group := &groups[i]
+		field := fmt.Sprintf("Modifier_groups[%d]", i)
+
+		if group.Max_select > 0 && group.Max_select < group.MinSelections() {
+			fields = append(fields, apperrors.FieldError{Field: field + ".Max_select", Rule: "gtefield", Message: "max_select must not be less than min_select"})
+		}
+		if group.MinSelections() > len(group.Options) {
+			fields = append(fields, apperrors.FieldError{Field: field + ".Min_select", Rule: "lte", Message: "min_select is more than the number of options"})
+		}
+
+		if group.Group_id == "" {
+			group.Group_id = primitive.NewObjectID().Hex()
+		}
+		for j := range group.Options {
+			if group.Options[j].Option_id == "" {
+				group.Options[j].Option_id = primitive.NewObjectID().Hex()
+			}
+			group.Options[j].Price_delta = toFixed(group.Options[j].Price_delta, 2)
+		}
+	}
+
+	if len(fields) > 0 {
+		return apperrors.InvalidFields(fields)
+	}
+	return nil
+}
+
+// checkFoodSection makes sure a food is only placed in a section of its
+// own menu.
+func checkFoodSection(menu models.Menu, food models.Food) error {
+	if food.Section_id == nil || menu.HasSection(*food.Section_id) {
+		return nil
+	}
+	return apperrors.InvalidFields([]apperrors.FieldError{{Field: "Section_id", Rule: "exists", Message: "is not a section of the menu"}})
+}
+
+// prepareBundle gives new bundle slots an ID and checks that every option
+// is an existing food that is not a bundle itself and is offered in the
+// size the option fixes.
+func prepareBundle(ctx context.Context, food models.Food) error {
+	if food.Bundle == nil {
+		return nil
+	}
+
+	var fields []apperrors.FieldError
+	for i := range food.Bundle.Slots {
+		slot := &food.Bundle.Slots[i]
+		if slot.Slot_id == "" {
+			slot.Slot_id = primitive.NewObjectID().Hex()
+		}
+
+		for j := range slot.Options {
+			option := &slot.Options[j]
+			option.Price_delta = toFixed(option.Price_delta, 2)
+			field := fmt.Sprintf("Bundle.Slots[%d].Options[%d]", i, j)
+
+			var component models.Food
+			err := foodCollection.FindOne(ctx, notDeleted(bson.M{"food_id": option.Food_id})).Decode(&component)
+			if err == mongo.ErrNoDocuments || option.Food_id == food.Food_id {
+				fields = append(fields, apperrors.FieldError{Field: field + ".Food_id", Rule: "exists", Message: "is not a food that can be part of the bundle"})
+				continue
+			} else if err != nil {
+				return apperrors.Internal(err, "error occured while fetching the bundle's foods")
+			}
+
+			if component.Bundle != nil {
+				fields = append(fields, apperrors.FieldError{Field: field + ".Food_id", Rule: "excluded_with", Message: "is a bundle itself"})
+			}
+			if option.Size != nil {
+				if _, err := component.PriceFor(*option.Size); err != nil {
+					fields = append(fields, apperrors.FieldError{Field: field + ".Size", Rule: "oneof", Message: err.Error()})
+				}
+			}
+		}
+	}
+
+	if len(fields) > 0 {
+		return apperrors.InvalidFields(fields)
+	}
+	return nil
+}
+
+func roundVariantPrices(variants []models.FoodVariant) {
+	for i := range variants {
+		price := toFixed(*variants[i].Price, 2)
+		variants[i].Price = &price
+	}
+}
+
 var foodCollection *mongo.Collection = database.OpenCollection(database.Client, "food")
 
 func CreateFood() gin.HandlerFunc {
@@ -37,7 +135,7 @@ func CreateFood() gin.HandlerFunc {
 		var food models.Food
 
 		if err := c.ShouldBindJSON(&food); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
 
@@ -48,18 +146,32 @@ func CreateFood() gin.HandlerFunc {
 		err := validate.Struct(food)
 
 		if err != nil {
-			// Validation failed, handle the error
-			errors := err.(validator.ValidationErrors)
-			c.JSON(http.StatusBadRequest, gin.H{"error": errors})
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		if err := prepareModifierGroups(food.Modifier_groups); err != nil {
+			c.Error(err)
+			return
+		}
+		if err := prepareBundle(ctx, food); err != nil {
+			c.Error(err)
 			return
 		}
 
-		menudata := menuCollection.FindOne(ctx, bson.M{"menu_id": food.Menu_id}).Decode(&menu)
+		menudata := menuCollection.FindOne(ctx, notDeleted(bson.M{"menu_id": food.Menu_id})).Decode(&menu)
 		defer cancel()
 
-		if menudata != nil {
-			msg := fmt.Sprintf("menu was not found")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+		if menudata == mongo.ErrNoDocuments {
+			c.Error(apperrors.BadRequest("menu was not found"))
+			return
+		} else if menudata != nil {
+			c.Error(apperrors.Internal(menudata, "error occured while fetching the menu"))
+			return
+		}
+
+		if err := checkFoodSection(menu, food); err != nil {
+			c.Error(err)
 			return
 		}
 
@@ -68,14 +180,23 @@ func CreateFood() gin.HandlerFunc {
 		food.Created_at = now
 		food.Updated_at = now
 		food.ID = primitive.NewObjectID()
+		food.Version = 1
 		food.Food_id = food.ID.Hex()
+		// The image is uploaded once the food exists
+		food.Food_image = nil
+		food.Image = nil
+		food.Rating = models.FoodRating{}
+		if food.Availability != nil {
+			food.Availability.Updated_at = now
+			food.Availability.Updated_by = c.GetString("uid")
+		}
 		var num = toFixed(*food.Price, 2)
 		food.Price = &num
+		roundVariantPrices(food.Variants)
 		result, insertErr := foodCollection.InsertOne(ctx, food)
 
 		if insertErr != nil {
-			msg := insertErr.Error()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			c.Error(apperrors.Internal(insertErr, "food item was not created"))
 			return
 		}
 		defer cancel()
@@ -84,43 +205,75 @@ func CreateFood() gin.HandlerFunc {
 
 }
 
+var foodListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "menu_id", Field: "menu_id", Kind: query.String},
+		{Param: "name", Field: "name", Kind: query.String},
+		{Param: "price", Field: "price", Kind: query.Number},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"name", "price", "created_at", "updated_at"},
+	DefaultSort: "name",
+}
+
+// applyDietaryFilters narrows a food filter by the exclude_allergens and
+// dietary query parameters, each a comma separated list. Foods that contain
+// any excluded allergen are left out, and foods must carry every dietary
+// tag asked for. Foods without declared allergens are not excluded.
+func applyDietaryFilters(c *gin.Context, filter bson.M) error {
+	if raw := c.Query("exclude_allergens"); raw != "" {
+		allergens, err := parseKeys(raw, models.Allergens, "exclude_allergens")
+		if err != nil {
+			return err
+		}
+		filter["allergens"] = bson.M{"$nin": allergens}
+	}
+
+	if raw := c.Query("dietary"); raw != "" {
+		tags, err := parseKeys(raw, models.DietaryTags, "dietary")
+		if err != nil {
+			return err
+		}
+		filter["dietary_tags"] = bson.M{"$all": tags}
+	}
+	return nil
+}
+
+func parseKeys(raw string, allowed []string, param string) ([]string, error) {
+	var keys []string
+	for _, key := range strings.Split(raw, ",") {
+		key = strings.TrimSpace(key)
+		if !slices.Contains(allowed, key) {
+			return nil, apperrors.BadRequest(fmt.Sprintf("%s must be a comma separated list of %s", param, strings.Join(allowed, ", ")))
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
 func GetFoods() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		page, err := strconv.Atoi(c.Query("page"))
-		if err != nil || page < 1 {
-			page = 1
+		list, err := query.Parse(c, foodListSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
 		}
+		notDeleted(list.Filter)
 
-		limit, err := strconv.Atoi(c.Query("limit"))
-		if err != nil || limit < 1 || limit > 100 {
-			limit = 10
+		if err := applyDietaryFilters(c, list.Filter); err != nil {
+			c.Error(err)
+			return
 		}
 
-		foodFilter := bson.M{}
-
-		foodCollection := database.OpenCollection(database.Client, "food")
-
-		foodCursor, err := foodCollection.Find(ctx, foodFilter)
+		page, err := query.Find[bson.M](ctx, foodCollection, list)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Error(apperrors.Internal(err, ""))
 			return
 		}
-		defer foodCursor.Close(ctx)
-		var foodList []bson.M
-		for foodCursor.Next(ctx) {
-			var food bson.M
-			err := foodCursor.Decode(&food)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			foodList = append(foodList, food)
-		}
-		defer cancel()
-		c.JSON(http.StatusOK, foodList)
+		c.JSON(http.StatusOK, page)
 	}
 }
 
@@ -128,13 +281,22 @@ func GetFoodByID() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		foodId := c.Param("food_id")
-		var food models.Menu
+		var food models.Food
 
-		err := foodCollection.FindOne(ctx, bson.M{"food_id": foodId}).Decode(&food)
+		err := foodCollection.FindOne(ctx, notDeleted(bson.M{"food_id": foodId})).Decode(&food)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the menu"})
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Food not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the food"))
+			}
+			return
+		}
+		if notModified(c, food.Version) {
+			return
 		}
+
 		c.JSON(http.StatusOK, food)
 	}
 }
@@ -146,49 +308,90 @@ func UpdateFood() gin.HandlerFunc {
 
 		var food models.Food
 		foodID := c.Param("food_id")
+		filter := notDeleted(bson.M{"food_id": foodID})
 
-		if err := c.ShouldBindJSON(&food); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		err := foodCollection.FindOne(ctx, filter).Decode(&food)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Food not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "Error occurred while fetching the food"))
+			}
 			return
 		}
+		original := food
 
-		updateObj := bson.M{}
+		if err := checkIfMatch(c, food.Version); err != nil {
+			c.Error(err)
+			return
+		}
 
-		if food.Name != nil {
-			updateObj["name"] = *food.Name
+		if err := applyMergePatch(c, &food); err != nil {
+			c.Error(err)
+			return
 		}
 
-		if food.Price != nil {
-			updateObj["price"] = *food.Price
+		food.ID = original.ID
+		food.Food_id = original.Food_id
+		food.Created_at = original.Created_at
+		food.Version = original.Version + 1
+		// Availability is changed through PUT /foods/:food_id/availability
+		food.Availability = original.Availability
+		// Images are changed through POST /foods/:food_id/image
+		food.Food_image = original.Food_image
+		food.Image = original.Image
+		// Ratings are added up from guest feedback
+		food.Rating = original.Rating
+		food.Deleted_at = original.Deleted_at
+		food.Deleted_by = original.Deleted_by
+
+		if err := validate.Struct(food); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
 		}
 
-		if food.Food_image != nil {
-			updateObj["food_image"] = *food.Food_image
+		if err := prepareModifierGroups(food.Modifier_groups); err != nil {
+			c.Error(err)
+			return
+		}
+		if err := prepareBundle(ctx, food); err != nil {
+			c.Error(err)
+			return
 		}
 
-		if food.Menu_id != nil {
+		sectionChanged := food.Section_id != nil && (original.Section_id == nil || *food.Section_id != *original.Section_id)
+		if *food.Menu_id != *original.Menu_id || sectionChanged {
 			var menu models.Menu
-			err := menuCollection.FindOne(ctx, bson.M{"menu_id": *food.Menu_id}).Decode(&menu)
+			err := menuCollection.FindOne(ctx, notDeleted(bson.M{"menu_id": *food.Menu_id})).Decode(&menu)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Menu not found"})
+				c.Error(apperrors.BadRequest("Menu not found"))
+				return
+			}
+			if err := checkFoodSection(menu, food); err != nil {
+				c.Error(err)
 				return
 			}
-			updateObj["menu_id"] = *food.Menu_id
 		}
 
-		updateObj["updated_at"] = time.Now()
+		price := toFixed(*food.Price, 2)
+		food.Price = &price
+		roundVariantPrices(food.Variants)
+		food.Updated_at = time.Now()
 
-		filter := bson.M{"food_id": foodID}
-		update := bson.M{"$set": updateObj}
-
-		opts := options.Update().SetUpsert(true)
-
-		result, err := foodCollection.UpdateOne(ctx, filter, update, opts)
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = foodCollection.FindOneAndReplace(ctx, filter, food, opts).Decode(&food)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Food item update failed"})
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Food was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "Food item update failed"))
+			}
 			return
 		}
 
-		c.JSON(http.StatusOK, result)
+		c.Header("ETag", etag(food.Version))
+		c.JSON(http.StatusOK, food)
 	}
 }
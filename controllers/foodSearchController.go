@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"restorent-management/apperrors"
+	"restorent-management/models"
+	"restorent-management/query"
+	"restorent-management/search"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FoodSearch is the index GET /foods/search reads from. Tests can replace
+// it with a search.MemoryIndex.
+var FoodSearch search.Index = search.MongoIndex{Collection: foodCollection}
+
+// SearchFoods handles GET /foods/search?q=. With typeahead=true the last
+// characters typed match the start of words, for search-as-you-type on
+// the POS. Results can be narrowed by menu_id, category, price_from,
+// price_to, available, exclude_allergens and dietary.
+func SearchFoods() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		q, err := parseSearch(ctx, c)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		results, err := FoodSearch.Search(ctx, q)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while searching foods"))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": results, "limit": q.Limit})
+	}
+}
+
+func parseSearch(ctx context.Context, c *gin.Context) (search.Query, error) {
+	q := search.Query{Text: c.Query("q"), Limit: query.DefaultLimit}
+	if q.Text == "" {
+		return q, apperrors.BadRequest("q is required")
+	}
+
+	if raw := c.Query("typeahead"); raw != "" {
+		prefix, err := strconv.ParseBool(raw)
+		if err != nil {
+			return q, apperrors.BadRequest("typeahead must be true or false")
+		}
+		q.Prefix = prefix
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > query.MaxLimit {
+			return q, apperrors.BadRequest("limit must be between 1 and " + strconv.Itoa(query.MaxLimit))
+		}
+		q.Limit = limit
+	}
+
+	for param, target := range map[string]**float64{"price_from": &q.Price_from, "price_to": &q.Price_to} {
+		if raw := c.Query(param); raw != "" {
+			price, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return q, apperrors.BadRequest(param + " must be a number")
+			}
+			*target = &price
+		}
+	}
+
+	if raw := c.Query("available"); raw != "" {
+		available, err := strconv.ParseBool(raw)
+		if err != nil {
+			return q, apperrors.BadRequest("available must be true or false")
+		}
+		q.Available = &available
+	}
+
+	var err error
+	if raw := c.Query("exclude_allergens"); raw != "" {
+		if q.Exclude_allergens, err = parseKeys(raw, models.Allergens, "exclude_allergens"); err != nil {
+			return q, err
+		}
+	}
+	if raw := c.Query("dietary"); raw != "" {
+		if q.Dietary, err = parseKeys(raw, models.DietaryTags, "dietary"); err != nil {
+			return q, err
+		}
+	}
+
+	if menuID := c.Query("menu_id"); menuID != "" {
+		q.Menu_ids = []string{menuID}
+	}
+
+	// A category is searched as the menus in it
+	if category := c.Query("category"); category != "" {
+		filter := notDeleted(bson.M{"category": category})
+		if len(q.Menu_ids) > 0 {
+			filter["menu_id"] = q.Menu_ids[0]
+		}
+		ids, err := menuCollection.Distinct(ctx, "menu_id", filter)
+		if err != nil {
+			return q, apperrors.Internal(err, "error occured while fetching the menus")
+		}
+
+		// A category without menus leaves Menu_ids empty but not nil, so
+		// the search matches nothing
+		q.Menu_ids = []string{}
+		for _, id := range ids {
+			if id, ok := id.(string); ok {
+				q.Menu_ids = append(q.Menu_ids, id)
+			}
+		}
+	}
+
+	return q, nil
+}
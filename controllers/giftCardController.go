@@ -0,0 +1,696 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"restorent-management/apperrors"
+	"restorent-management/database"
+	"restorent-management/models"
+	"restorent-management/query"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var giftCardCollection *mongo.Collection = database.OpenCollection(database.Client, "giftCard")
+var giftCardEntryCollection *mongo.Collection = database.OpenCollection(database.Client, "giftCardEntry")
+
+// errGiftCardBalance is returned by moveGiftCardBalance when the card
+// cannot cover a payment, or can no longer be paid with.
+var errGiftCardBalance = errors.New("gift card balance is too low")
+
+// giftCardAlphabet leaves out characters that are easily misread.
+const giftCardAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// newGiftCardCode returns a random code of four groups of four characters.
+func newGiftCardCode() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	var code strings.Builder
+	for i, b := range random {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(giftCardAlphabet[int(b)%len(giftCardAlphabet)])
+	}
+	return code.String(), nil
+}
+
+// normalizeGiftCardCode accepts codes typed in lower case or with spaces in
+// place of the dashes.
+func normalizeGiftCardCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), " ", "-"))
+}
+
+// moveGiftCardBalance changes a card's balance by amount and appends the
+// ledger entry for it. A payment (negative amount) is only taken if the
+// card still covers it and can be paid with, checked in the same update
+// that takes it, so concurrent payments cannot spend the same balance
+// twice. Balances are rounded to cents on every change so they never drift.
+//
+// The entry is written as pending before the balance moves, and the card
+// holds on to its id until the entry is marked applied. A move cut off
+// half way is settled by jobs.SettleLedgers from whether the card holds
+// the id, so the ledger always adds up to the balance.
+func moveGiftCardBalance(ctx context.Context, cardID, entryType string, amount float64, invoiceID *string, by string) (models.GiftCard, models.GiftCardEntry, error) {
+	var card models.GiftCard
+	amount = toFixed(amount, 2)
+
+	entry := models.GiftCardEntry{
+		ID:           primitive.NewObjectID(),
+		Gift_card_id: cardID,
+		Type:         entryType,
+		Amount:       amount,
+		Invoice_id:   invoiceID,
+		Status:       models.EntryPending,
+		Created_at:   time.Now(),
+		Created_by:   by,
+	}
+	entry.Entry_id = entry.ID.Hex()
+	if _, err := giftCardEntryCollection.InsertOne(ctx, entry); err != nil {
+		return card, entry, err
+	}
+
+	filter := bson.M{"gift_card_id": cardID}
+	if amount < 0 {
+		now := time.Now()
+		filter["balance"] = bson.M{"$gte": -amount}
+		filter["disabled"] = false
+		filter["$or"] = bson.A{bson.M{"expires_at": nil}, bson.M{"expires_at": bson.M{"$gt": now}}}
+	}
+	update := bson.A{bson.M{"$set": bson.M{
+		"balance":         bson.M{"$round": bson.A{bson.M{"$add": bson.A{"$balance", amount}}, 2}},
+		"pending_entries": bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{"$pending_entries", bson.A{}}}, bson.A{entry.Entry_id}}},
+		"version":         bson.M{"$add": bson.A{"$version", 1}},
+		"updated_at":      "$$NOW",
+	}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := giftCardCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&card)
+	if err == mongo.ErrNoDocuments {
+		// Nothing moved, so the entry never happened
+		if _, err := giftCardEntryCollection.UpdateOne(ctx, bson.M{"entry_id": entry.Entry_id}, bson.M{"$set": bson.M{"status": models.EntryFailed}}); err != nil {
+			return card, entry, err
+		}
+		if amount < 0 {
+			return card, entry, errGiftCardBalance
+		}
+		return card, entry, mongo.ErrNoDocuments
+	} else if err != nil {
+		return card, entry, err
+	}
+
+	entry.Status = models.EntryApplied
+	entry.Balance_after = card.Balance
+	set := bson.M{"status": entry.Status, "balance_after": entry.Balance_after}
+	if _, err := giftCardEntryCollection.UpdateOne(ctx, bson.M{"entry_id": entry.Entry_id}, bson.M{"$set": set}); err != nil {
+		return card, entry, err
+	}
+	// Letting go of the id bumps the version too, so an update that read
+	// the card before cannot put the id back
+	update = bson.A{bson.M{"$set": bson.M{
+		"pending_entries": bson.M{"$setDifference": bson.A{"$pending_entries", bson.A{entry.Entry_id}}},
+		"version":         bson.M{"$add": bson.A{"$version", 1}},
+	}}}
+	err = giftCardCollection.FindOneAndUpdate(ctx, bson.M{"gift_card_id": cardID}, update, opts).Decode(&card)
+	return card, entry, err
+}
+
+// IssuedGiftCard is a newly issued card with its code, which is all it
+// takes to spend the card and is not returned by any other endpoint.
+type IssuedGiftCard struct {
+	models.GiftCard
+	Code string `json:"code"`
+}
+
+// IssueGiftCard handles POST /giftCards/create. The code is generated, and
+// the initial balance is credited through the ledger like any top-up.
+func IssueGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var card models.GiftCard
+		if err := c.ShouldBindJSON(&card); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+
+		if err := validate.Struct(card); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+		if err := checkCustomer(ctx, card.Customer_id); err != nil {
+			c.Error(err)
+			return
+		}
+
+		now := time.Now()
+		card.Created_at = now
+		card.Updated_at = now
+		card.ID = primitive.NewObjectID()
+		card.Version = 1
+		card.Gift_card_id = card.ID.Hex()
+		card.Balance = 0
+		card.Disabled = false
+
+		// A fresh code colliding with an existing one is unlikely, but the
+		// unique index decides
+		var err error
+		for attempt := 0; attempt < 3; attempt++ {
+			if card.Code, err = newGiftCardCode(); err != nil {
+				break
+			}
+			card.Card_ending = card.Ending()
+			if _, err = giftCardCollection.InsertOne(ctx, card); !mongo.IsDuplicateKeyError(err) {
+				break
+			}
+		}
+		if err != nil {
+			c.Error(apperrors.Internal(err, "gift card was not issued"))
+			return
+		}
+
+		card, _, err = moveGiftCardBalance(ctx, card.Gift_card_id, models.GiftCardIssue, *card.Initial_balance, nil, c.GetString("uid"))
+		if err != nil {
+			c.Error(apperrors.Internal(err, "gift card balance was not credited"))
+			return
+		}
+
+		c.Header("ETag", etag(card.Version))
+		c.JSON(http.StatusOK, IssuedGiftCard{GiftCard: card, Code: card.Code})
+	}
+}
+
+var giftCardListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "customer_id", Field: "customer_id", Kind: query.String},
+		{Param: "disabled", Field: "disabled", Kind: query.Bool},
+		{Param: "balance", Field: "balance", Kind: query.Number},
+		{Param: "expires_at", Field: "expires_at", Kind: query.Date},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"created_at", "balance", "expires_at"},
+	DefaultSort: "-created_at",
+}
+
+func GetGiftCards() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, giftCardListSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+
+		page, err := query.Find[models.GiftCard](ctx, giftCardCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing gift cards"))
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func GetGiftCardByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var card models.GiftCard
+		err := giftCardCollection.FindOne(ctx, bson.M{"gift_card_id": c.Param("gift_card_id")}).Decode(&card)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Gift card not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the gift card"))
+			}
+			return
+		}
+		if notModified(c, card.Version) {
+			return
+		}
+
+		c.JSON(http.StatusOK, card)
+	}
+}
+
+// UpdateGiftCard changes who a card belongs to, when it expires and whether
+// it is disabled. Gift cards are disabled rather than deleted, so that
+// their ledger stays whole.
+func UpdateGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var card models.GiftCard
+		filter := bson.M{"gift_card_id": c.Param("gift_card_id")}
+
+		err := giftCardCollection.FindOne(ctx, filter).Decode(&card)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Gift card not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the gift card"))
+			}
+			return
+		}
+		original := card
+
+		if err := checkIfMatch(c, card.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		if err := applyMergePatch(c, &card); err != nil {
+			c.Error(err)
+			return
+		}
+
+		card.ID = original.ID
+		card.Gift_card_id = original.Gift_card_id
+		card.Code = original.Code
+		card.Card_ending = original.Card_ending
+		card.Pending_entries = original.Pending_entries
+		card.Created_at = original.Created_at
+		card.Version = original.Version + 1
+		// The balance only moves through the ledger. Each move bumps the
+		// version, so the replace below cannot undo one it did not see.
+		card.Initial_balance = original.Initial_balance
+		card.Balance = original.Balance
+
+		if err := validate.Struct(card); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+		if card.Customer_id != nil && (original.Customer_id == nil || *card.Customer_id != *original.Customer_id) {
+			if err := checkCustomer(ctx, card.Customer_id); err != nil {
+				c.Error(err)
+				return
+			}
+		}
+
+		card.Updated_at = time.Now()
+
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = giftCardCollection.FindOneAndReplace(ctx, filter, card, opts).Decode(&card)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Gift card was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "Gift card update failed"))
+			}
+			return
+		}
+
+		c.Header("ETag", etag(card.Version))
+		c.JSON(http.StatusOK, card)
+	}
+}
+
+// GiftCardAmountRequest is the body of POST /giftCards/:gift_card_id/top-up.
+type GiftCardAmountRequest struct {
+	Amount *float64 `json:"amount" validate:"required,gt=0,lte=10000"`
+}
+
+// TopUpGiftCard handles POST /giftCards/:gift_card_id/top-up, adding to the
+// balance of a card that can still be paid with.
+func TopUpGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var request GiftCardAmountRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		var card models.GiftCard
+		err := giftCardCollection.FindOne(ctx, bson.M{"gift_card_id": c.Param("gift_card_id")}).Decode(&card)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Gift card not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the gift card"))
+			}
+			return
+		}
+		if !card.UsableAt(time.Now()) {
+			c.Error(apperrors.Unprocessable("gift card is disabled or has expired"))
+			return
+		}
+
+		card, _, err = moveGiftCardBalance(ctx, card.Gift_card_id, models.GiftCardTopUp, *request.Amount, nil, c.GetString("uid"))
+		if err != nil {
+			c.Error(apperrors.Internal(err, "gift card was not topped up"))
+			return
+		}
+
+		c.Header("ETag", etag(card.Version))
+		c.JSON(http.StatusOK, card)
+	}
+}
+
+// GiftCardBalance is what a balance check tells about a card.
+type GiftCardBalance struct {
+	Card_ending string     `json:"card_ending"`
+	Balance     float64    `json:"balance"`
+	Expires_at  *time.Time `json:"expires_at"`
+	Usable      bool       `json:"usable"`
+}
+
+type GiftCardBalanceRequest struct {
+	Code string `json:"code" validate:"required,max=64"`
+}
+
+// GetGiftCardBalance handles POST /giftCards/balance. The code is taken from
+// the body so it stays out of URLs and the access logs that record them.
+func GetGiftCardBalance() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var request GiftCardBalanceRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		var card models.GiftCard
+		err := giftCardCollection.FindOne(ctx, bson.M{"code": normalizeGiftCardCode(request.Code)}).Decode(&card)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Gift card not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the gift card"))
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, GiftCardBalance{
+			Card_ending: card.Ending(),
+			Balance:     card.Balance,
+			Expires_at:  card.Expires_at,
+			Usable:      card.UsableAt(time.Now()),
+		})
+	}
+}
+
+var giftCardLedgerSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "type", Field: "type", Kind: query.String},
+		{Param: "invoice_id", Field: "invoice_id", Kind: query.String},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"created_at"},
+	DefaultSort: "-created_at",
+}
+
+// GetGiftCardLedger handles GET /giftCards/:gift_card_id/ledger.
+func GetGiftCardLedger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, giftCardLedgerSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		list.Filter["gift_card_id"] = c.Param("gift_card_id")
+		list.Filter["status"] = bson.M{"$ne": models.EntryFailed}
+
+		page, err := query.Find[models.GiftCardEntry](ctx, giftCardEntryCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing the gift card ledger"))
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// GiftCardPaymentRequest is the body of POST /invoices/:invoice_id/giftCards.
+// Without an amount the card pays as much of the invoice as it can.
+type GiftCardPaymentRequest struct {
+	Code   string   `json:"code" validate:"required,max=64"`
+	Amount *float64 `json:"amount" validate:"omitempty,gt=0"`
+}
+
+// PayWithGiftCard handles POST /invoices/:invoice_id/giftCards, paying part
+// or all of a pending invoice from a gift card. An invoice paid in full is
+// marked PAID with GIFT_CARD as its payment method.
+func PayWithGiftCard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var request GiftCardPaymentRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		var invoice models.Invoice
+		err := invoiceCollection.FindOne(ctx, notDeleted(bson.M{"invoice_id": c.Param("invoice_id")})).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Invoice not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the invoice"))
+			}
+			return
+		}
+		if err := checkIfMatch(c, invoice.Version); err != nil {
+			c.Error(err)
+			return
+		}
+		if *invoice.Payment_status != "PENDING" {
+			c.Error(apperrors.Conflict("gift cards can only pay pending invoices"))
+			return
+		}
+
+		var card models.GiftCard
+		err = giftCardCollection.FindOne(ctx, bson.M{"code": normalizeGiftCardCode(request.Code)}).Decode(&card)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Gift card not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the gift card"))
+			}
+			return
+		}
+		now := time.Now()
+		if !card.UsableAt(now) {
+			c.Error(apperrors.Unprocessable("gift card is disabled or has expired"))
+			return
+		}
+
+		view, err := viewInvoice(ctx, invoice)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		due := view.Payment_due
+		if due <= 0 {
+			c.Error(apperrors.Unprocessable("nothing is left to pay on this invoice"))
+			return
+		}
+		amount := toFixed(math.Min(card.Balance, due), 2)
+		if request.Amount != nil {
+			amount = toFixed(*request.Amount, 2)
+			if amount > due {
+				c.Error(apperrors.Unprocessable(fmt.Sprintf("only %.2f is left to pay on this invoice", due)))
+				return
+			}
+		}
+		if amount <= 0 || amount > card.Balance {
+			c.Error(apperrors.Unprocessable(fmt.Sprintf("gift card balance is %.2f", card.Balance)))
+			return
+		}
+
+		// Paying the invoice in full fixes its discounts and earns its
+		// member loyalty points
+		earned := 0
+		paid := invoice
+		if amount >= due {
+			status := "PAID"
+			paid.Payment_status = &status
+			if err := fixOrderDiscounts(ctx, invoice, &paid); err != nil {
+				c.Error(err)
+				return
+			}
+			if earned, err = invoicePoints(ctx, paid); err != nil {
+				c.Error(err)
+				return
+			}
+		}
+
+		uid := c.GetString("uid")
+		card, entry, err := moveGiftCardBalance(ctx, card.Gift_card_id, models.GiftCardRedeem, -amount, &invoice.Invoice_id, uid)
+		if err == errGiftCardBalance {
+			c.Error(apperrors.Conflict("gift card balance was spent by another request"))
+			return
+		} else if err != nil {
+			c.Error(apperrors.Internal(err, "gift card was not charged"))
+			return
+		}
+
+		payment := models.InvoiceGiftCard{
+			Gift_card_id: card.Gift_card_id,
+			Card_ending:  card.Ending(),
+			Entry_id:     entry.Entry_id,
+			Amount:       amount,
+			Paid_at:      now,
+		}
+		set := bson.M{"updated_at": now}
+		if amount >= due {
+			set["payment_status"] = "PAID"
+			set["payment_method"] = "GIFT_CARD"
+			set["points_earned"] = earned
+			set["order_discounts"] = paid.Order_discounts
+			set["discounts_fixed_at"] = paid.Discounts_fixed_at
+		}
+		// The amount was checked against what was due on this version, so
+		// another payment in between must not be added to
+		filter := notDeleted(bson.M{"invoice_id": invoice.Invoice_id, "payment_status": "PENDING", "version": invoice.Version})
+		update := bson.M{
+			"$push": bson.M{"gift_cards": payment},
+			"$set":  set,
+			"$inc":  bson.M{"version": 1},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = invoiceCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&invoice)
+		if err != nil {
+			if _, _, refundErr := moveGiftCardBalance(ctx, card.Gift_card_id, models.GiftCardRefund, amount, &invoice.Invoice_id, uid); refundErr != nil {
+				log.Printf("giftCards: %.2f could not be given back to card %s after paying invoice %s failed: %v", amount, card.Gift_card_id, invoice.Invoice_id, refundErr)
+				c.Error(apperrors.Internal(refundErr, "gift card payment was not recorded and the card could not be refunded"))
+				return
+			}
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.Conflict("Invoice was paid or changed by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "gift card payment was not recorded"))
+			}
+			return
+		}
+
+		if err := settleInvoiceLoyalty(ctx, invoice, uid); err != nil {
+			c.Error(err)
+			return
+		}
+
+		view, err = viewInvoice(ctx, invoice)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.Header("ETag", etag(invoice.Version))
+		c.JSON(http.StatusOK, view)
+	}
+}
+
+// RefundGiftCardPayment handles DELETE /invoices/:invoice_id/giftCards/:entry_id,
+// taking a gift card payment off a pending invoice and giving the amount
+// back to the card.
+func RefundGiftCardPayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var invoice models.Invoice
+		err := invoiceCollection.FindOne(ctx, notDeleted(bson.M{"invoice_id": c.Param("invoice_id")})).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Invoice not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the invoice"))
+			}
+			return
+		}
+		if err := checkIfMatch(c, invoice.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		var payment *models.InvoiceGiftCard
+		for i := range invoice.Gift_cards {
+			if invoice.Gift_cards[i].Entry_id == c.Param("entry_id") {
+				payment = &invoice.Gift_cards[i]
+			}
+		}
+		if payment == nil {
+			c.Error(apperrors.NotFound("Gift card payment not found"))
+			return
+		}
+		refund := *payment
+		if *invoice.Payment_status != "PENDING" {
+			c.Error(apperrors.Conflict("gift card payments can only be refunded from pending invoices"))
+			return
+		}
+
+		// Pulling the payment first means only one request can refund it
+		filter := notDeleted(bson.M{"invoice_id": invoice.Invoice_id, "payment_status": "PENDING", "gift_cards.entry_id": refund.Entry_id})
+		filter["version"] = invoice.Version
+		update := bson.M{
+			"$pull": bson.M{"gift_cards": bson.M{"entry_id": refund.Entry_id}},
+			"$set":  bson.M{"updated_at": time.Now()},
+			"$inc":  bson.M{"version": 1},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = invoiceCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.Conflict("Invoice was paid or changed by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "gift card payment was not refunded"))
+			}
+			return
+		}
+
+		_, _, err = moveGiftCardBalance(ctx, refund.Gift_card_id, models.GiftCardRefund, refund.Amount, &invoice.Invoice_id, c.GetString("uid"))
+		if err != nil {
+			c.Error(apperrors.Internal(err, "gift card was not credited"))
+			return
+		}
+
+		view, err := viewInvoice(ctx, invoice)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.Header("ETag", etag(invoice.Version))
+		c.JSON(http.StatusOK, view)
+	}
+}
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"restorent-management/apperrors"
+	"restorent-management/imaging"
+	"restorent-management/models"
+	"restorent-management/storage"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Images holds uploaded images. It defaults to the directory named by
+// IMAGE_STORE_DIR, or ./uploads, and can be replaced by any other store.
+var Images storage.Store = storage.NewLocalStore(imageStoreDir())
+
+func imageStoreDir() string {
+	if dir := os.Getenv("IMAGE_STORE_DIR"); dir != "" {
+		return dir
+	}
+	return "uploads"
+}
+
+// maxImageBytes is the largest image file that can be uploaded.
+const maxImageBytes = 5 << 20
+
+// imageURLPrefix is where GET /images/*key serves stored images from.
+const imageURLPrefix = "/images/"
+
+// imageExtensions lists the upload types that are accepted, by their
+// sniffed content type.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// imageSizes are the resized variants made of every upload, by the
+// longest side in pixels.
+var imageSizes = []struct {
+	name    string
+	maxSide int
+}{
+	{"thumbnail", 200},
+	{"medium", 800},
+}
+
+// storedImage is an object to put in the image store.
+type storedImage struct {
+	key          string
+	data         []byte
+	content_type string
+}
+
+// readImageUpload reads the "image" file of a multipart upload, refusing
+// files over maxImageBytes and anything that does not sniff as a
+// supported image type.
+func readImageUpload(c *gin.Context) ([]byte, string, error) {
+	// Leave room for the multipart headers around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageBytes+64<<10)
+
+	file, _, err := c.Request.FormFile("image")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, "", apperrors.TooLarge("image must be at most 5 MB")
+		}
+		return nil, "", apperrors.BadRequest("expected a multipart form with an image file: " + err.Error())
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxImageBytes+1))
+	if err != nil {
+		return nil, "", apperrors.BadRequest("image could not be read")
+	}
+	if len(data) > maxImageBytes {
+		return nil, "", apperrors.TooLarge("image must be at most 5 MB")
+	}
+
+	// The client's Content-Type is not trusted, only the file's own bytes
+	contentType := http.DetectContentType(data)
+	if _, ok := imageExtensions[contentType]; !ok {
+		return nil, "", apperrors.UnsupportedMediaType("image must be a JPEG, PNG or GIF, got " + contentType)
+	}
+	return data, contentType, nil
+}
+
+// prepareImage decodes an upload and makes its resized variants. Keys are
+// derived from the file's hash, so a stored object never changes and can
+// be cached forever.
+func prepareImage(foodID string, data []byte, contentType string) (*models.FoodImage, []storedImage, error) {
+	img, err := imaging.Decode(data)
+	if err != nil {
+		return nil, nil, apperrors.Unprocessable("image could not be decoded: " + err.Error())
+	}
+
+	sum := sha256.Sum256(data)
+	base := "foods/" + foodID + "/" + hex.EncodeToString(sum[:16])
+	bounds := img.Bounds()
+
+	image := &models.FoodImage{
+		Key:          base + imageExtensions[contentType],
+		Content_type: contentType,
+		Size:         int64(len(data)),
+		Width:        bounds.Dx(),
+		Height:       bounds.Dy(),
+		Variants:     []models.ImageVariant{},
+	}
+	image.Url = imageURLPrefix + image.Key
+	objects := []storedImage{{key: image.Key, data: data, content_type: contentType}}
+
+	for _, size := range imageSizes {
+		resized := imaging.Fit(img, size.maxSide)
+		encoded, encodedType, err := imaging.Encode(resized)
+		if err != nil {
+			return nil, nil, apperrors.Internal(err, "image could not be resized")
+		}
+
+		variant := models.ImageVariant{
+			Name:         size.name,
+			Key:          base + "-" + size.name + imageExtensions[encodedType],
+			Content_type: encodedType,
+			Width:        resized.Rect.Dx(),
+			Height:       resized.Rect.Dy(),
+		}
+		variant.Url = imageURLPrefix + variant.Key
+		image.Variants = append(image.Variants, variant)
+		objects = append(objects, storedImage{key: variant.Key, data: encoded, content_type: encodedType})
+	}
+	return image, objects, nil
+}
+
+// deleteImages removes stored objects, except those in keep. Failures
+// only leave unused files behind, so they are logged.
+func deleteImages(ctx context.Context, keys []string, keep []string) {
+	for _, key := range keys {
+		if slices.Contains(keep, key) {
+			continue
+		}
+		if err := Images.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("images: could not delete %s: %v", key, err)
+		}
+	}
+}
+
+// UploadFoodImage handles POST /foods/:food_id/image, a multipart form
+// with the file in the image field. It replaces the food's image and
+// returns the updated food.
+func UploadFoodImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		var food models.Food
+		filter := notDeleted(bson.M{"food_id": c.Param("food_id")})
+		err := foodCollection.FindOne(ctx, filter).Decode(&food)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Food not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the food"))
+			}
+			return
+		}
+		if err := checkIfMatch(c, food.Version); err != nil {
+			c.Error(err)
+			return
+		}
+		// The image is only set on the food as read, so the old image's
+		// files are the ones removed below
+		filter["version"] = food.Version
+		previous := food.Image
+
+		data, contentType, err := readImageUpload(c)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		image, objects, err := prepareImage(food.Food_id, data, contentType)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		image.Uploaded_at = time.Now()
+		image.Uploaded_by = c.GetString("uid")
+
+		var oldKeys []string
+		if previous != nil {
+			oldKeys = previous.Keys()
+		}
+		for i, object := range objects {
+			if err := Images.Put(ctx, object.key, object.data, object.content_type); err != nil {
+				stored := make([]string, 0, i)
+				for _, o := range objects[:i] {
+					stored = append(stored, o.key)
+				}
+				deleteImages(ctx, stored, oldKeys)
+				c.Error(apperrors.Internal(err, "image could not be stored"))
+				return
+			}
+		}
+
+		update := bson.M{
+			"$set": bson.M{"food_image": image.Url, "image": image, "updated_at": image.Uploaded_at},
+			"$inc": bson.M{"version": 1},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = foodCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&food)
+		if err != nil {
+			deleteImages(ctx, image.Keys(), oldKeys)
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Food was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while updating the food image"))
+			}
+			return
+		}
+
+		// Uploading the same file again reuses its keys, which must be kept
+		deleteImages(ctx, oldKeys, image.Keys())
+
+		c.Header("ETag", etag(food.Version))
+		c.JSON(http.StatusOK, food)
+	}
+}
+
+// ServeImage handles GET /images/*key. Keys change whenever the content
+// does, so responses can be cached for a year without revalidating.
+func ServeImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		object, err := Images.Open(ctx, key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				c.Error(apperrors.NotFound("Image not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while reading the image"))
+			}
+			return
+		}
+		defer object.Body.Close()
+
+		c.Header("Content-Type", object.Content_type)
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		c.Header("ETag", `"`+path.Base(key)+`"`)
+		c.Header("X-Content-Type-Options", "nosniff")
+
+		// ServeContent answers If-None-Match and range requests itself
+		if seeker, ok := object.Body.(io.ReadSeeker); ok {
+			http.ServeContent(c.Writer, c.Request, key, object.Modified_at, seeker)
+			return
+		}
+		if matchesETag(c.GetHeader("If-None-Match"), c.Writer.Header().Get("ETag")) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.DataFromReader(http.StatusOK, object.Size, object.Content_type, object.Body, nil)
+	}
+}
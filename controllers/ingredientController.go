@@ -0,0 +1,337 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"restorent-management/apperrors"
+	"restorent-management/database"
+	"restorent-management/models"
+	"restorent-management/query"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ingredientCollection *mongo.Collection = database.OpenCollection(database.Client, "ingredient")
+var stockMovementCollection *mongo.Collection = database.OpenCollection(database.Client, "stockMovement")
+
+func CreateIngredient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var ingredient models.Ingredient
+		if err := c.ShouldBindJSON(&ingredient); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+
+		if err := validate.Struct(ingredient); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		if err := checkSupplier(ctx, ingredient.Supplier_id); err != nil {
+			c.Error(err)
+			return
+		}
+
+		now := time.Now()
+		ingredient.Created_at = now
+		ingredient.Updated_at = now
+		ingredient.ID = primitive.NewObjectID()
+		ingredient.Version = 1
+		ingredient.Ingredient_id = ingredient.ID.Hex()
+
+		// Opening stock is recorded like any other adjustment
+		openingStock := ingredient.Stock
+		ingredient.Stock = 0
+
+		result, err := ingredientCollection.InsertOne(ctx, ingredient)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "ingredient was not created"))
+			return
+		}
+
+		if openingStock != 0 {
+			note := "opening stock"
+			change := stockChange{Change: openingStock, Reason: models.StockAdjustment, Note: &note}
+			if _, err := adjustStock(ctx, c.GetString("uid"), ingredient.Ingredient_id, change); err != nil {
+				c.Error(err)
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+var ingredientListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "name", Field: "name", Kind: query.String},
+		{Param: "unit", Field: "unit", Kind: query.String},
+		{Param: "stock", Field: "stock", Kind: query.Number},
+	},
+	Sortable:    []string{"name", "stock", "unit_cost", "created_at"},
+	DefaultSort: "name",
+}
+
+func GetIngredients() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, ingredientListSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		notDeleted(list.Filter)
+
+		page, err := query.Find[models.Ingredient](ctx, ingredientCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing ingredients"))
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func GetIngredientByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var ingredient models.Ingredient
+		err := ingredientCollection.FindOne(ctx, notDeleted(bson.M{"ingredient_id": c.Param("ingredient_id")})).Decode(&ingredient)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Ingredient not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the ingredient"))
+			}
+			return
+		}
+		if notModified(c, ingredient.Version) {
+			return
+		}
+
+		c.JSON(http.StatusOK, ingredient)
+	}
+}
+
+func UpdateIngredient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var ingredient models.Ingredient
+		filter := notDeleted(bson.M{"ingredient_id": c.Param("ingredient_id")})
+
+		err := ingredientCollection.FindOne(ctx, filter).Decode(&ingredient)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Ingredient not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the ingredient"))
+			}
+			return
+		}
+		original := ingredient
+
+		if err := checkIfMatch(c, ingredient.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		if err := applyMergePatch(c, &ingredient); err != nil {
+			c.Error(err)
+			return
+		}
+
+		ingredient.ID = original.ID
+		ingredient.Ingredient_id = original.Ingredient_id
+		ingredient.Created_at = original.Created_at
+		ingredient.Version = original.Version + 1
+		ingredient.Deleted_at = original.Deleted_at
+		ingredient.Deleted_by = original.Deleted_by
+		// Stock only moves through POST /ingredients/:ingredient_id/adjust
+		ingredient.Stock = original.Stock
+
+		if err := validate.Struct(ingredient); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		if err := checkSupplier(ctx, ingredient.Supplier_id); err != nil {
+			c.Error(err)
+			return
+		}
+
+		ingredient.Updated_at = time.Now()
+
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = ingredientCollection.FindOneAndReplace(ctx, filter, ingredient, opts).Decode(&ingredient)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Ingredient was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "Ingredient update failed"))
+			}
+			return
+		}
+
+		c.Header("ETag", etag(ingredient.Version))
+		c.JSON(http.StatusOK, ingredient)
+	}
+}
+
+// AdjustStock handles POST /ingredients/:ingredient_id/adjust for stock
+// counts, wastage and deliveries made without a purchase order. The change
+// is added to the stock as is, so wastage is sent as a negative change.
+func AdjustStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var change stockChange
+		if err := c.ShouldBindJSON(&change); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		if err := validate.Struct(change); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+		change.Reason = models.StockAdjustment
+
+		ingredient, err := adjustStock(ctx, c.GetString("uid"), c.Param("ingredient_id"), change)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.Header("ETag", etag(ingredient.Version))
+		c.JSON(http.StatusOK, ingredient)
+	}
+}
+
+var stockMovementListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "reason", Field: "reason", Kind: query.String},
+		{Param: "order_item_id", Field: "order_item_id", Kind: query.String},
+		{Param: "purchase_order_id", Field: "purchase_order_id", Kind: query.String},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"created_at"},
+	DefaultSort: "-created_at",
+}
+
+// GetStockMovements lists the stock changes of one ingredient.
+func GetStockMovements() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, stockMovementListSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		list.Filter["ingredient_id"] = c.Param("ingredient_id")
+
+		page, err := query.Find[models.StockMovement](ctx, stockMovementCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing stock movements"))
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// GetLowStock lists the ingredients at or below their low stock level,
+// lowest stock relative to that level first.
+func GetLowStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		filter := notDeleted(bson.M{"$expr": bson.M{"$lte": bson.A{"$stock", "$low_stock_level"}}})
+		cursor, err := ingredientCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing ingredients"))
+			return
+		}
+
+		ingredients := []models.Ingredient{}
+		if err := cursor.All(ctx, &ingredients); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding ingredients"))
+			return
+		}
+
+		c.JSON(http.StatusOK, ingredients)
+	}
+}
+
+type StockValuation struct {
+	Total_value float64                  `json:"total_value"`
+	Ingredients []IngredientValuationRow `json:"ingredients"`
+}
+
+type IngredientValuationRow struct {
+	Ingredient_id string  `json:"ingredient_id"`
+	Name          string  `json:"name"`
+	Unit          string  `json:"unit"`
+	Stock         float64 `json:"stock"`
+	Unit_cost     float64 `json:"unit_cost"`
+	Value         float64 `json:"value"`
+}
+
+// GetStockValuation values the stock on hand at each ingredient's unit
+// cost. Negative stock, from selling more than was counted in, is valued
+// at zero.
+func GetStockValuation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		cursor, err := ingredientCollection.Find(ctx, notDeleted(bson.M{}), options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing ingredients"))
+			return
+		}
+
+		var ingredients []models.Ingredient
+		if err := cursor.All(ctx, &ingredients); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding ingredients"))
+			return
+		}
+
+		valuation := StockValuation{Ingredients: []IngredientValuationRow{}}
+		for _, ingredient := range ingredients {
+			row := IngredientValuationRow{
+				Ingredient_id: ingredient.Ingredient_id,
+				Name:          *ingredient.Name,
+				Unit:          *ingredient.Unit,
+				Stock:         ingredient.Stock,
+				Unit_cost:     ingredient.Unit_cost,
+			}
+			if ingredient.Stock > 0 {
+				row.Value = toFixed(ingredient.Stock*ingredient.Unit_cost, 2)
+			}
+			valuation.Total_value += row.Value
+			valuation.Ingredients = append(valuation.Ingredients, row)
+		}
+		valuation.Total_value = toFixed(valuation.Total_value, 2)
+
+		c.JSON(http.StatusOK, valuation)
+	}
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"restorent-management/apperrors"
+	"restorent-management/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// stockChange is a change to one ingredient's stock. Reason is set by the
+// server; clients only send the change and an optional note.
+type stockChange struct {
+	Change        float64 `json:"change" validate:"ne=0"`
+	Note          *string `json:"note" validate:"omitempty,max=500"`
+	Reason        string  `json:"-"`
+	Order_item_id *string `json:"-"`
+}
+
+// adjustStock adds change to an ingredient's stock and records the
+// movement at the ingredient's current unit cost.
+func adjustStock(ctx context.Context, uid string, ingredientID string, change stockChange) (models.Ingredient, error) {
+	var ingredient models.Ingredient
+	update := bson.M{
+		"$inc": bson.M{"stock": change.Change, "version": 1},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := ingredientCollection.FindOneAndUpdate(ctx, notDeleted(bson.M{"ingredient_id": ingredientID}), update, opts).Decode(&ingredient)
+	if err == mongo.ErrNoDocuments {
+		return ingredient, apperrors.NotFound("Ingredient not found")
+	} else if err != nil {
+		return ingredient, apperrors.Internal(err, "error occured while updating the stock")
+	}
+
+	movement := models.StockMovement{
+		ID:            primitive.NewObjectID(),
+		Ingredient_id: ingredientID,
+		Change:        change.Change,
+		Reason:        change.Reason,
+		Order_item_id: change.Order_item_id,
+		Note:          change.Note,
+		Unit_cost:     ingredient.Unit_cost,
+		Created_at:    time.Now(),
+		Created_by:    uid,
+	}
+	if _, err := stockMovementCollection.InsertOne(ctx, movement); err != nil {
+		return ingredient, apperrors.Internal(err, "error occured while recording the stock movement")
+	}
+	return ingredient, nil
+}
+
+// recipeFor returns the recipe for a food in the given size, falling back
+// to the food's recipe without a size. It reports false when the food has
+// no recipe, in which case no stock is tracked for it.
+func recipeFor(ctx context.Context, foodID string, size string) (models.Recipe, bool, error) {
+	var recipe models.Recipe
+	filter := notDeleted(bson.M{"food_id": foodID, "size": bson.M{"$in": bson.A{size, nil}}})
+	// Sorting puts the recipe for the size ahead of the one without a size
+	opts := options.FindOne().SetSort(bson.M{"size": -1})
+	err := recipeCollection.FindOne(ctx, filter, opts).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		return recipe, false, nil
+	}
+	return recipe, err == nil, err
+}
+
+// servedFood is a food in the size it is served in.
+type servedFood struct {
+	food_id string
+	size    string
+}
+
+// servedFoods lists what the kitchen makes for an order item: the food
+// itself or, for a bundle, each of its components.
+func servedFoods(orderItem models.OrderItem) []servedFood {
+	if len(orderItem.Components) == 0 {
+		return []servedFood{{food_id: *orderItem.Food_id, size: *orderItem.Quantity}}
+	}
+	served := make([]servedFood, 0, len(orderItem.Components))
+	for _, component := range orderItem.Components {
+		served = append(served, servedFood{food_id: component.Food_id, size: component.Size})
+	}
+	return served
+}
+
+// moveStockForOrderItems takes the recipe ingredients of each order item
+// out of stock when it is sold, or puts them back when it is voided.
+// Orders are never refused for missing stock, since the food has already
+// been sold, so failures are logged rather than returned.
+func moveStockForOrderItems(ctx context.Context, uid string, orderItems []models.OrderItem, reason string) {
+	for _, orderItem := range orderItems {
+		orderItemID := orderItem.Order_item_id
+		if reason == models.StockVoid {
+			returnStockForOrderItem(ctx, uid, orderItemID)
+			continue
+		}
+
+		for _, served := range servedFoods(orderItem) {
+			recipe, ok, err := recipeFor(ctx, served.food_id, served.size)
+			if err != nil {
+				log.Printf("inventory: recipe lookup for order item %s failed: %v", orderItemID, err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+
+			for _, line := range recipe.Ingredients {
+				change := stockChange{Change: -line.Quantity, Reason: reason, Order_item_id: &orderItemID}
+				if _, err := adjustStock(ctx, uid, line.Ingredient_id, change); err != nil {
+					log.Printf("inventory: %s of order item %s did not update ingredient %s: %v", reason, orderItemID, line.Ingredient_id, err)
+				}
+			}
+		}
+	}
+}
+
+// returnStockForOrderItem puts back what an order item still has out of
+// stock according to its stock movements, rather than what its recipe
+// takes today, so a recipe changed since the sale puts back the right
+// ingredients.
+func returnStockForOrderItem(ctx context.Context, uid string, orderItemID string) {
+	cursor, err := stockMovementCollection.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"order_item_id": orderItemID}},
+		bson.M{"$group": bson.M{"_id": "$ingredient_id", "change": bson.M{"$sum": "$change"}}},
+	})
+	if err != nil {
+		log.Printf("inventory: stock movements of order item %s could not be loaded for a void: %v", orderItemID, err)
+		return
+	}
+	var taken []struct {
+		Ingredient_id string  `bson:"_id"`
+		Change        float64 `bson:"change"`
+	}
+	if err := cursor.All(ctx, &taken); err != nil {
+		log.Printf("inventory: stock movements of order item %s could not be decoded for a void: %v", orderItemID, err)
+		return
+	}
+
+	for _, t := range taken {
+		// Sums of quantities in fractions of a unit can be a hair off zero
+		if t.Change > -1e-9 {
+			continue
+		}
+		change := stockChange{Change: -t.Change, Reason: models.StockVoid, Order_item_id: &orderItemID}
+		if _, err := adjustStock(ctx, uid, t.Ingredient_id, change); err != nil {
+			log.Printf("inventory: void of order item %s did not update ingredient %s: %v", orderItemID, t.Ingredient_id, err)
+		}
+	}
+}
+
+// receiveStock books a delivery of an ingredient. The ingredient's unit
+// cost becomes the weighted average of the stock on hand and the delivery,
+// computed in the update itself so concurrent sales are not lost.
+func receiveStock(ctx context.Context, uid string, purchaseOrderID string, line models.ReceiptLine) error {
+	onHand := bson.M{"$max": bson.A{"$stock", 0}}
+	newTotal := bson.M{"$add": bson.A{onHand, line.Quantity}}
+	averageCost := bson.M{"$divide": bson.A{
+		bson.M{"$add": bson.A{bson.M{"$multiply": bson.A{onHand, "$unit_cost"}}, line.Quantity * line.Unit_cost}},
+		newTotal,
+	}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"unit_cost":  averageCost,
+		"stock":      bson.M{"$add": bson.A{"$stock", line.Quantity}},
+		"version":    bson.M{"$add": bson.A{"$version", 1}},
+		"updated_at": time.Now(),
+	}}}}
+
+	result, err := ingredientCollection.UpdateOne(ctx, bson.M{"ingredient_id": line.Ingredient_id}, update)
+	if err != nil {
+		return apperrors.Internal(err, "error occured while updating the stock")
+	}
+	if result.MatchedCount == 0 {
+		return apperrors.BadRequest("ingredient " + line.Ingredient_id + " was not found")
+	}
+
+	movement := models.StockMovement{
+		ID:                primitive.NewObjectID(),
+		Ingredient_id:     line.Ingredient_id,
+		Change:            line.Quantity,
+		Reason:            models.StockReceipt,
+		Purchase_order_id: &purchaseOrderID,
+		Unit_cost:         line.Unit_cost,
+		Created_at:        time.Now(),
+		Created_by:        uid,
+	}
+	if _, err := stockMovementCollection.InsertOne(ctx, movement); err != nil {
+		return apperrors.Internal(err, "error occured while recording the stock movement")
+	}
+	return nil
+}
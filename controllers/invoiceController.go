@@ -2,11 +2,12 @@ package controllers
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"math"
 	"net/http"
+	"restorent-management/apperrors"
 	"restorent-management/database"
 	"restorent-management/models"
+	"restorent-management/query"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -24,10 +25,20 @@ type InvoiceViewFormat struct {
 	Payment_method   string
 	Order_id         string
 	Payment_status   *string
-	Payment_due      interface{}
+	Subtotal         float64
+	Discounts        []models.AppliedDiscount
+	Coupon           *models.InvoiceCoupon
+	Points_redeemed  *models.InvoicePoints
+	Total            float64
+	Gift_cards       []models.InvoiceGiftCard
+	Payment_due      float64
+	Customer_id      *string
+	Points_earned    int
+	Feedback_token   *models.FeedbackToken
 	Table_number     interface{}
 	Payment_due_date time.Time
 	Order_details    interface{}
+	Version          int64
 }
 
 func CreateInvoice() gin.HandlerFunc {
@@ -44,7 +55,7 @@ func CreateInvoice() gin.HandlerFunc {
 
 		// Bind the JSON payload to the invoice struct
 		if err = c.ShouldBindJSON(&invoice); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
 
@@ -56,23 +67,18 @@ func CreateInvoice() gin.HandlerFunc {
 
 		// Validate the invoice struct
 		if err = validate.Struct(invoice); err != nil {
-			// Extract and return validation errors
-			var validationErrors []string
-			for _, err := range err.(validator.ValidationErrors) {
-				validationErrors = append(validationErrors, fmt.Sprintf("Field %s: %s", err.Field(), err.ActualTag()))
-			}
-			c.JSON(http.StatusBadRequest, gin.H{"errors": validationErrors})
+			// Report every invalid field
+			c.Error(apperrors.Validation(err))
 			return
 		}
 
 		// Check if the associated order exists
-		err = orderCollection.FindOne(ctx, bson.M{"order_id": invoice.Order_id}).Decode(&order)
+		err = orderCollection.FindOne(ctx, notDeleted(bson.M{"order_id": invoice.Order_id})).Decode(&order)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+				c.Error(apperrors.NotFound("Order not found"))
 			} else {
-				log.Printf("Failed to find order: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+				c.Error(apperrors.Internal(err, "Failed to find order"))
 			}
 			return
 		}
@@ -83,13 +89,47 @@ func CreateInvoice() gin.HandlerFunc {
 		invoice.Created_at = now
 		invoice.Updated_at = now
 		invoice.ID = primitive.NewObjectID()
+		invoice.Version = 1
 		invoice.Invoice_id = invoice.ID.Hex()
+		invoice.Coupon = nil
+		invoice.Gift_cards = nil
+		invoice.Points_redeemed = nil
+		invoice.Points_earned = 0
+		invoice.Feedback_token = nil
+		invoice.Order_discounts = nil
+		invoice.Discounts_fixed_at = nil
+
+		// Invoices for a customer's order are theirs for loyalty points
+		if invoice.Customer_id == nil {
+			invoice.Customer_id = order.Customer_id
+		}
+		if err := checkCustomer(ctx, invoice.Customer_id); err != nil {
+			c.Error(err)
+			return
+		}
+
+		// An invoice created as paid earns points and keeps its discounts
+		// as if it was paid later
+		pending := invoice
+		status := "PENDING"
+		pending.Payment_status = &status
+		if err := fixOrderDiscounts(ctx, pending, &invoice); err != nil {
+			c.Error(err)
+			return
+		}
+		if err := updateInvoicePoints(ctx, pending, &invoice); err != nil {
+			c.Error(err)
+			return
+		}
 
 		// Insert the invoice into the database
 		result, insertErr := invoiceCollection.InsertOne(ctx, invoice)
 		if insertErr != nil {
-			log.Printf("Failed to insert invoice: %v", insertErr)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			c.Error(apperrors.Internal(insertErr, "Failed to insert invoice"))
+			return
+		}
+		if err := settleInvoiceLoyalty(ctx, invoice, c.GetString("uid")); err != nil {
+			c.Error(err)
 			return
 		}
 
@@ -97,25 +137,37 @@ func CreateInvoice() gin.HandlerFunc {
 	}
 }
 
+var invoiceListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "order_id", Field: "order_id", Kind: query.String},
+		{Param: "payment_status", Field: "payment_status", Kind: query.String},
+		{Param: "payment_method", Field: "payment_method", Kind: query.String},
+		{Param: "payment_due_date", Field: "payment_due_date", Kind: query.Date},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"created_at", "payment_due_date", "payment_status"},
+	DefaultSort: "-created_at",
+}
+
 func GetInvoices() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		cursor, err := invoiceCollection.Find(ctx, bson.D{})
+		list, err := query.Parse(c, invoiceListSpec)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
-		defer cursor.Close(ctx)
+		notDeleted(list.Filter)
 
-		var invoice []models.Invoice
-		if err := cursor.All(ctx, &invoice); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		page, err := query.Find[models.Invoice](ctx, invoiceCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, ""))
 			return
 		}
 
-		c.JSON(http.StatusOK, invoice)
+		c.JSON(http.StatusOK, page)
 	}
 }
 
@@ -125,27 +177,31 @@ func GetInvoiceByID() gin.HandlerFunc {
 		InvoiceId := c.Param("invoice_id")
 		var invoice models.Invoice
 
-		err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": InvoiceId}).Decode(&invoice)
+		err := invoiceCollection.FindOne(ctx, notDeleted(bson.M{"invoice_id": InvoiceId})).Decode(&invoice)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the menu"})
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Invoice not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the invoice"))
+			}
+			return
 		}
-		var invoiceView InvoiceViewFormat
-
-		allOrderItems, err := ItemsByOrder(invoice.Order_id)
-		invoiceView.Order_id = invoice.Order_id
-		invoiceView.Payment_due_date = invoice.Payment_due_date
-
-		invoiceView.Payment_method = "null"
-		if invoice.Payment_method != nil {
-			invoiceView.Payment_method = *invoice.Payment_method
+		invoiceView, err := viewInvoice(ctx, invoice)
+		if err != nil {
+			c.Error(err)
+			return
 		}
 
-		invoiceView.Invoice_id = invoice.Invoice_id
-		invoiceView.Payment_status = invoice.Payment_status
-		invoiceView.Payment_due = allOrderItems[0]["payment_due"]
-		invoiceView.Table_number = allOrderItems[0]["table_number"]
-		invoiceView.Order_details = allOrderItems[0]["order_items"]
+		// The view changes with the order's items as well as the invoice
+		tag, err := viewETag(invoice.Version, invoiceView)
+		if err != nil {
+			c.Error(apperrors.Internal(err, ""))
+			return
+		}
+		if notModifiedTag(c, tag) {
+			return
+		}
 
 		c.JSON(http.StatusOK, invoiceView)
 	}
@@ -160,50 +216,248 @@ func UpdateInvoice() gin.HandlerFunc {
 		var invoice models.Invoice
 		invoiceId := c.Param("invoice_id")
 
-		// Bind the JSON body to the invoice struct
-		if err := c.BindJSON(&invoice); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		// Build the filter to find the invoice by ID
+		filter := notDeleted(bson.M{"invoice_id": invoiceId})
+
+		// Load the stored invoice so the patch is applied on top of it
+		err := invoiceCollection.FindOne(ctx, filter).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Invoice not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "Failed to find invoice"))
+			}
+			return
+		}
+		original := invoice
+
+		if err := checkIfMatch(c, invoice.Version); err != nil {
+			c.Error(err)
 			return
 		}
 
-		// Set default payment status if it's nil
-		if invoice.Payment_status == nil {
-			status := "PENDING"
-			invoice.Payment_status = &status
+		// Apply the JSON Merge Patch body to the stored invoice
+		if err := applyMergePatch(c, &invoice); err != nil {
+			c.Error(err)
+			return
+		}
+
+		// Identity, ownership and creation time cannot be patched
+		invoice.ID = original.ID
+		invoice.Invoice_id = original.Invoice_id
+		invoice.Order_id = original.Order_id
+		invoice.Created_at = original.Created_at
+		invoice.Version = original.Version + 1
+		invoice.Deleted_at = original.Deleted_at
+		invoice.Deleted_by = original.Deleted_by
+		// Coupons, gift card payments, loyalty members and points are
+		// added through their own endpoints under /invoices/:invoice_id
+		invoice.Coupon = original.Coupon
+		invoice.Gift_cards = original.Gift_cards
+		invoice.Customer_id = original.Customer_id
+		invoice.Points_redeemed = original.Points_redeemed
+		invoice.Points_earned = original.Points_earned
+		// Feedback links are issued through POST /invoices/:invoice_id/feedbackToken
+		invoice.Feedback_token = original.Feedback_token
+		invoice.Order_discounts = original.Order_discounts
+		invoice.Discounts_fixed_at = original.Discounts_fixed_at
+
+		// Validate the merged invoice
+		if err := validate.Struct(invoice); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
 		}
 
-		// Prepare the update object with only the fields that are not nil
-		updateObj := bson.M{}
-		if invoice.Payment_method != nil {
-			updateObj["payment_method"] = invoice.Payment_method
+		// Paying the invoice fixes its discounts, which the points it
+		// earns are worked out from
+		if err := fixOrderDiscounts(ctx, original, &invoice); err != nil {
+			c.Error(err)
+			return
 		}
-		if invoice.Payment_status != nil {
-			updateObj["payment_status"] = invoice.Payment_status
+
+		// Paying, unpaying and refunding the invoice moves loyalty points
+		if err := updateInvoicePoints(ctx, original, &invoice); err != nil {
+			c.Error(err)
+			return
 		}
 
 		// Update the 'updated_at' field to the current time
 		invoice.Updated_at = time.Now().UTC()
-		updateObj["updated_at"] = invoice.Updated_at
 
-		// Build the filter to find the invoice by ID
-		filter := bson.M{"invoice_id": invoiceId}
-
-		// Set upsert option to true to create a new document if one doesn't exist
-		opt := options.Update().SetUpsert(true)
-
-		// Attempt to update the invoice in the collection
-		result, err := invoiceCollection.UpdateOne(
-			ctx,
-			filter,
-			bson.M{"$set": updateObj},
-			opt,
-		)
+		// Replace the stored invoice and return the new version
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = invoiceCollection.FindOneAndReplace(ctx, filter, invoice, opts).Decode(&invoice)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invoice update failed"})
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Invoice was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "Invoice update failed"))
+			}
 			return
 		}
 
-		// Return the result of the update operation
-		c.JSON(http.StatusOK, result)
+		if err := settleInvoiceLoyalty(ctx, invoice, c.GetString("uid")); err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.Header("ETag", etag(invoice.Version))
+		c.JSON(http.StatusOK, invoice)
+	}
+}
+
+// viewInvoice puts together what an invoice comes to: the order's items,
+// the promotions given on them, the coupon and loyalty points redeemed
+// against it. What was already paid from gift cards is taken off the
+// payment due.
+func viewInvoice(ctx context.Context, invoice models.Invoice) (InvoiceViewFormat, error) {
+	var invoiceView InvoiceViewFormat
+
+	allOrderItems, err := ItemsByOrder(invoice.Order_id)
+	if err != nil {
+		return invoiceView, apperrors.Internal(err, "error occured while fetching the order items")
+	}
+	invoiceView.Order_id = invoice.Order_id
+	invoiceView.Payment_due_date = invoice.Payment_due_date
+
+	invoiceView.Payment_method = "null"
+	if invoice.Payment_method != nil {
+		invoiceView.Payment_method = *invoice.Payment_method
+	}
+
+	invoiceView.Invoice_id = invoice.Invoice_id
+	invoiceView.Payment_status = invoice.Payment_status
+	invoiceView.Version = invoice.Version
+	// An order without items has nothing to group
+	itemsDue := 0.0
+	if len(allOrderItems) > 0 {
+		itemsDue = amount(allOrderItems[0]["payment_due"])
+		invoiceView.Table_number = allOrderItems[0]["table_number"]
+		invoiceView.Order_details = allOrderItems[0]["order_items"]
+	}
+
+	itemDiscounts, orderWide, err := invoiceDiscounts(ctx, invoice)
+	if err != nil {
+		return invoiceView, err
+	}
+	// Items are already charged net of their own discounts, which are
+	// added back so the subtotal is before every discount
+	invoiceView.Discounts = append(itemDiscounts, orderWide...)
+	invoiceView.Subtotal = toFixed(itemsDue+discountsTotal(itemDiscounts), 2)
+	due := itemsDue - discountsTotal(orderWide)
+
+	// The coupon's discount was fixed when it was redeemed, so it can be
+	// more than is left to pay if items were voided since
+	invoiceView.Coupon = invoice.Coupon
+	if invoice.Coupon != nil {
+		due = math.Max(0, due-invoice.Coupon.Discount)
+	}
+	invoiceView.Points_redeemed = invoice.Points_redeemed
+	if invoice.Points_redeemed != nil {
+		due = math.Max(0, due-invoice.Points_redeemed.Discount)
+	}
+	invoiceView.Total = toFixed(due, 2)
+	invoiceView.Customer_id = invoice.Customer_id
+	invoiceView.Points_earned = invoice.Points_earned
+	invoiceView.Feedback_token = invoice.Feedback_token
+
+	invoiceView.Gift_cards = invoice.Gift_cards
+	for _, payment := range invoice.Gift_cards {
+		due = math.Max(0, due-payment.Amount)
+	}
+	invoiceView.Payment_due = toFixed(due, 2)
+	return invoiceView, nil
+}
+
+// invoiceDiscounts lists the promotions given on an order: those applied to
+// its items when they were priced, added up per promotion, and the order
+// wide ones.
+func invoiceDiscounts(ctx context.Context, invoice models.Invoice) ([]models.AppliedDiscount, []models.AppliedDiscount, error) {
+	orderID := invoice.Order_id
+	var order models.Order
+	err := orderCollection.FindOne(ctx, bson.M{"order_id": orderID}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return []models.AppliedDiscount{}, nil, nil
+	} else if err != nil {
+		return nil, nil, apperrors.Internal(err, "error occured while fetching the order")
+	}
+
+	cursor, err := orderItemCollection.Find(ctx, notDeleted(bson.M{"order_id": orderID}))
+	if err != nil {
+		return nil, nil, apperrors.Internal(err, "error occured while fetching the order items")
+	}
+	var orderItems []models.OrderItem
+	if err := cursor.All(ctx, &orderItems); err != nil {
+		return nil, nil, apperrors.Internal(err, "error occured while decoding the order items")
+	}
+
+	itemDiscounts := []models.AppliedDiscount{}
+	index := map[string]int{}
+	for _, orderItem := range orderItems {
+		for _, discount := range orderItem.Discounts {
+			i, ok := index[discount.Promotion_id]
+			if !ok {
+				i = len(itemDiscounts)
+				index[discount.Promotion_id] = i
+				itemDiscounts = append(itemDiscounts, models.AppliedDiscount{Promotion_id: discount.Promotion_id, Name: discount.Name, Stackable: discount.Stackable})
+			}
+			itemDiscounts[i].Amount = toFixed(itemDiscounts[i].Amount+discount.Amount, 2)
+		}
+	}
+
+	// Paid invoices keep the order wide promotions they were paid with
+	if invoice.Discounts_fixed_at != nil {
+		return itemDiscounts, invoice.Order_discounts, nil
+	}
+	orderWide, err := orderDiscounts(ctx, order, orderItems)
+	return itemDiscounts, orderWide, err
+}
+
+// fixOrderDiscounts keeps the order wide promotions given on an invoice
+// when it is paid, so that later changes to the promotions do not change
+// what a paid invoice came to. Item promotions are already kept on the
+// order items. An invoice that goes back to pending is priced from the
+// promotions again.
+func fixOrderDiscounts(ctx context.Context, original models.Invoice, invoice *models.Invoice) error {
+	was, now := *original.Payment_status, *invoice.Payment_status
+	switch {
+	case now == "PAID" && was != "PAID":
+		invoice.Discounts_fixed_at = nil
+		_, orderWide, err := invoiceDiscounts(ctx, *invoice)
+		if err != nil {
+			return err
+		}
+		fixedAt := time.Now()
+		invoice.Order_discounts = orderWide
+		invoice.Discounts_fixed_at = &fixedAt
+	case now == "PENDING":
+		invoice.Order_discounts = nil
+		invoice.Discounts_fixed_at = nil
+	}
+	return nil
+}
+
+func discountsTotal(discounts []models.AppliedDiscount) float64 {
+	total := 0.0
+	for _, discount := range discounts {
+		total += discount.Amount
+	}
+	return total
+}
+
+// amount reads a number out of an aggregation result, where sums come back
+// as whichever BSON number type they were stored as.
+func amount(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	default:
+		return 0
 	}
 }
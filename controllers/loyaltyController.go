@@ -0,0 +1,762 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"restorent-management/apperrors"
+	"restorent-management/database"
+	"restorent-management/models"
+	"restorent-management/query"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var loyaltySettingsCollection *mongo.Collection = database.OpenCollection(database.Client, "loyaltySettings")
+var loyaltyEntryCollection *mongo.Collection = database.OpenCollection(database.Client, "loyaltyEntry")
+
+// errNotEnoughPoints is returned by moveLoyaltyPoints when a member does
+// not have the points they are redeeming.
+var errNotEnoughPoints = errors.New("not enough loyalty points")
+
+// errLoyaltyEntryExists is returned by moveLoyaltyPoints when the entry ID
+// it is given is already in the ledger.
+var errLoyaltyEntryExists = errors.New("loyalty entry already exists")
+
+// loadLoyaltySettings returns the saved loyalty settings, or the defaults
+// if they were never saved.
+func loadLoyaltySettings(ctx context.Context) (models.LoyaltySettings, error) {
+	settings := models.DefaultLoyaltySettings()
+	err := loyaltySettingsCollection.FindOne(ctx, bson.M{"_id": models.LoyaltySettingsID}).Decode(&settings)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return settings, apperrors.Internal(err, "error occured while fetching the loyalty settings")
+	}
+	return settings, nil
+}
+
+func GetLoyaltySettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		settings, err := loadLoyaltySettings(ctx)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if notModified(c, settings.Version) {
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// UpdateLoyaltySettings handles PATCH /loyalty/settings. The first update
+// saves the defaults with the patch applied, and needs the ETag of the
+// defaults like any other.
+func UpdateLoyaltySettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		settings, err := loadLoyaltySettings(ctx)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		original := settings
+
+		if err := checkIfMatch(c, settings.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		if err := applyMergePatch(c, &settings); err != nil {
+			c.Error(err)
+			return
+		}
+
+		settings.ID = models.LoyaltySettingsID
+		settings.Version = original.Version + 1
+		settings.Updated_at = time.Now()
+		settings.Updated_by = c.GetString("uid")
+
+		if err := validate.Struct(settings); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+		seen := map[string]bool{}
+		for i, tier := range settings.Tiers {
+			if seen[tier.Name] {
+				c.Error(apperrors.InvalidFields([]apperrors.FieldError{{Field: fmt.Sprintf("Tiers[%d].Name", i), Rule: "unique", Message: "is used more than once"}}))
+				return
+			}
+			seen[tier.Name] = true
+		}
+		settings.SortTiers()
+
+		// Before the first save there is no document to match the version
+		// on, and the upsert inserts it. A concurrent first save loses on
+		// the _id.
+		filter := bson.M{"_id": models.LoyaltySettingsID, "version": original.Version}
+		opts := options.Replace().SetUpsert(original.Version == 0)
+		result, err := loyaltySettingsCollection.ReplaceOne(ctx, filter, settings, opts)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			c.Error(apperrors.Internal(err, "Loyalty settings update failed"))
+			return
+		}
+		if err != nil || result.MatchedCount+result.UpsertedCount == 0 {
+			c.Error(apperrors.PreconditionFailed("Loyalty settings were modified by another request"))
+			return
+		}
+
+		c.Header("ETag", etag(settings.Version))
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// moveLoyaltyPoints changes a member's points and appends the ledger entry
+// for it. Redeemed points are only taken if the member still has them,
+// checked in the same update that takes them, so concurrent redemptions
+// cannot spend the same points twice. Earning and clawbacks also move the
+// lifetime points tiers are reached with, and earning and redeeming count
+// as activity for expiry.
+//
+// Like gift card moves, the entry is written as pending before the points
+// move and the member holds on to its id until it is marked applied. An
+// entryID that is already in the ledger gives errLoyaltyEntryExists;
+// without one the entry gets a new ID.
+func moveLoyaltyPoints(ctx context.Context, customerID, entryType string, points int, invoiceID *string, tier string, by string, entryID string) (models.LoyaltyEntry, error) {
+	var customer models.Customer
+	now := time.Now()
+
+	entry := models.LoyaltyEntry{
+		ID:          primitive.NewObjectID(),
+		Entry_id:    entryID,
+		Customer_id: customerID,
+		Type:        entryType,
+		Points:      points,
+		Invoice_id:  invoiceID,
+		Tier:        tier,
+		Status:      models.EntryPending,
+		Created_at:  now,
+		Created_by:  by,
+	}
+	if entry.Entry_id == "" {
+		entry.Entry_id = entry.ID.Hex()
+	}
+	if _, err := loyaltyEntryCollection.InsertOne(ctx, entry); mongo.IsDuplicateKeyError(err) {
+		return entry, errLoyaltyEntryExists
+	} else if err != nil {
+		return entry, err
+	}
+
+	filter := bson.M{"customer_id": customerID}
+	inc := bson.M{"loyalty.points": points, "version": 1}
+	set := bson.M{"updated_at": now}
+	switch entryType {
+	case models.LoyaltyEarn:
+		inc["loyalty.lifetime_points"] = points
+		set["loyalty.last_activity_at"] = now
+	case models.LoyaltyClawback:
+		inc["loyalty.lifetime_points"] = points
+	case models.LoyaltyRedeem:
+		filter["loyalty.points"] = bson.M{"$gte": -points}
+		set["loyalty.last_activity_at"] = now
+	}
+	update := bson.M{"$inc": inc, "$set": set, "$push": bson.M{"loyalty.pending_entries": entry.Entry_id}}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := customerCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&customer)
+	if err == mongo.ErrNoDocuments {
+		// Nothing moved, so the entry never happened
+		if _, err := loyaltyEntryCollection.UpdateOne(ctx, bson.M{"entry_id": entry.Entry_id}, bson.M{"$set": bson.M{"status": models.EntryFailed}}); err != nil {
+			return entry, err
+		}
+		if entryType == models.LoyaltyRedeem {
+			return entry, errNotEnoughPoints
+		}
+		return entry, mongo.ErrNoDocuments
+	} else if err != nil {
+		return entry, err
+	}
+
+	entry.Status = models.EntryApplied
+	entry.Balance_after = customer.Loyalty.Points
+	set = bson.M{"status": entry.Status, "balance_after": entry.Balance_after}
+	if _, err := loyaltyEntryCollection.UpdateOne(ctx, bson.M{"entry_id": entry.Entry_id}, bson.M{"$set": set}); err != nil {
+		return entry, err
+	}
+	// Letting go of the id bumps the version too, so an update that read
+	// the member before cannot put the id back
+	update = bson.M{"$pull": bson.M{"loyalty.pending_entries": entry.Entry_id}, "$inc": bson.M{"version": 1}}
+	_, err = customerCollection.UpdateOne(ctx, bson.M{"customer_id": customerID}, update)
+	return entry, err
+}
+
+// invoicePoints works out the points a member earns for an invoice: the
+// invoice total after every discount, gift card payments included, at the
+// earn rate and the multiplier of the tier the member is in.
+func invoicePoints(ctx context.Context, invoice models.Invoice) (int, error) {
+	if invoice.Customer_id == nil {
+		return 0, nil
+	}
+	settings, tier, err := memberTier(ctx, *invoice.Customer_id)
+	if err != nil {
+		return 0, err
+	}
+	view, err := viewInvoice(ctx, invoice)
+	if err != nil {
+		return 0, err
+	}
+	return settings.PointsFor(view.Total, tier), nil
+}
+
+// memberTier returns the loyalty settings and the tier a member is in.
+func memberTier(ctx context.Context, customerID string) (models.LoyaltySettings, models.LoyaltyTier, error) {
+	settings, err := loadLoyaltySettings(ctx)
+	if err != nil {
+		return settings, models.LoyaltyTier{}, err
+	}
+	var customer models.Customer
+	if err := customerCollection.FindOne(ctx, bson.M{"customer_id": customerID}).Decode(&customer); err != nil {
+		return settings, models.LoyaltyTier{}, apperrors.Internal(err, "error occured while fetching the loyalty member")
+	}
+	return settings, settings.TierFor(customer.Loyalty.Lifetime_points), nil
+}
+
+// updateInvoicePoints checks an update to an invoice's payment status
+// against the loyalty rules and records the points the invoice earns:
+// paying earns points, and unpaying or refunding takes them away again.
+// Refunds are final and only paid invoices can be refunded.
+func updateInvoicePoints(ctx context.Context, original models.Invoice, invoice *models.Invoice) error {
+	was, now := *original.Payment_status, *invoice.Payment_status
+	if was == now {
+		return nil
+	}
+	if was == "REFUNDED" {
+		return apperrors.Conflict("refunded invoices cannot be changed")
+	}
+	if now == "REFUNDED" && was != "PAID" {
+		return apperrors.Conflict("only paid invoices can be refunded")
+	}
+
+	invoice.Points_earned = 0
+	if now == "PAID" {
+		points, err := invoicePoints(ctx, *invoice)
+		if err != nil {
+			return err
+		}
+		invoice.Points_earned = points
+	}
+	return nil
+}
+
+// settleInvoiceLoyalty brings what a saved invoice has moved in its
+// member's ledger in line with the invoice: the points it earned while it
+// is paid, and the points redeemed on it given back once it is refunded.
+// Only the difference is moved, so settling again after a move was cut
+// off finishes the job, and every invoice update settles. Each move has
+// an entry ID made from the invoice and the moves before it, so two
+// requests settling at once cannot both make the same move.
+func settleInvoiceLoyalty(ctx context.Context, invoice models.Invoice, by string) error {
+	if invoice.Customer_id == nil {
+		return nil
+	}
+
+	cursor, err := loyaltyEntryCollection.Find(ctx, bson.M{"invoice_id": invoice.Invoice_id})
+	if err != nil {
+		return apperrors.Internal(err, "error occured while fetching the invoice's loyalty entries")
+	}
+	var entries []models.LoyaltyEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return apperrors.Internal(err, "error occured while decoding the invoice's loyalty entries")
+	}
+	earned, redeemed := 0, 0
+	made := map[string]int{}
+	for _, entry := range entries {
+		made[entry.Type]++
+		if entry.Status == models.EntryFailed {
+			continue
+		}
+		switch entry.Type {
+		case models.LoyaltyEarn, models.LoyaltyClawback:
+			earned += entry.Points
+		case models.LoyaltyRedeem, models.LoyaltyRedeemReversal:
+			redeemed += entry.Points
+		}
+	}
+
+	type move struct {
+		entryType string
+		points    int
+	}
+	var moves []move
+	if diff := invoice.Points_earned - earned; diff < 0 {
+		moves = append(moves, move{models.LoyaltyClawback, diff})
+	} else if diff > 0 {
+		moves = append(moves, move{models.LoyaltyEarn, diff})
+	}
+	if *invoice.Payment_status == "REFUNDED" && redeemed < 0 {
+		moves = append(moves, move{models.LoyaltyRedeemReversal, -redeemed})
+	}
+
+	for _, m := range moves {
+		tier := ""
+		if m.entryType == models.LoyaltyEarn {
+			_, t, err := memberTier(ctx, *invoice.Customer_id)
+			if err != nil {
+				return err
+			}
+			tier = t.Name
+		}
+		entryID := fmt.Sprintf("%s-%s-%d", invoice.Invoice_id, m.entryType, made[m.entryType]+1)
+		_, err := moveLoyaltyPoints(ctx, *invoice.Customer_id, m.entryType, m.points, &invoice.Invoice_id, tier, by, entryID)
+		if err == errLoyaltyEntryExists {
+			// Another request is making the same move
+			continue
+		} else if err != nil {
+			return apperrors.Internal(err, "loyalty points were not updated")
+		}
+	}
+	return nil
+}
+
+// InvoiceMemberRequest is the body of POST /invoices/:invoice_id/member,
+// naming the member by ID, phone or email.
+type InvoiceMemberRequest struct {
+	Customer_id *string `json:"customer_id" validate:"required_without_all=Phone Email"`
+	Phone       *string `json:"phone"`
+	Email       *string `json:"email"`
+}
+
+// LinkInvoiceMember handles POST /invoices/:invoice_id/member, making a
+// pending invoice earn loyalty points for a member.
+func LinkInvoiceMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var request InvoiceMemberRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		var member bson.M
+		switch {
+		case request.Customer_id != nil:
+			member = bson.M{"customer_id": *request.Customer_id}
+		case request.Phone != nil:
+			member = bson.M{"phone": models.NormalizePhone(*request.Phone)}
+		default:
+			member = bson.M{"email": models.NormalizeEmail(*request.Email)}
+		}
+		var customer models.Customer
+		err := customerCollection.FindOne(ctx, notDeleted(member)).Decode(&customer)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Customer not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the customer"))
+			}
+			return
+		}
+
+		var invoice models.Invoice
+		err = invoiceCollection.FindOne(ctx, notDeleted(bson.M{"invoice_id": c.Param("invoice_id")})).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Invoice not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the invoice"))
+			}
+			return
+		}
+		if err := checkIfMatch(c, invoice.Version); err != nil {
+			c.Error(err)
+			return
+		}
+		if *invoice.Payment_status != "PENDING" {
+			c.Error(apperrors.Conflict("members can only be added to pending invoices"))
+			return
+		}
+		if invoice.Points_redeemed != nil {
+			c.Error(apperrors.Conflict("points were already redeemed on this invoice"))
+			return
+		}
+
+		filter := notDeleted(bson.M{"invoice_id": invoice.Invoice_id, "payment_status": "PENDING", "points_redeemed": nil})
+		filter["version"] = invoice.Version
+		update := bson.M{
+			"$set": bson.M{"customer_id": customer.Customer_id, "updated_at": time.Now()},
+			"$inc": bson.M{"version": 1},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = invoiceCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.Conflict("Invoice was paid or changed by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "member was not added to the invoice"))
+			}
+			return
+		}
+
+		view, err := viewInvoice(ctx, invoice)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.Header("ETag", etag(invoice.Version))
+		c.JSON(http.StatusOK, view)
+	}
+}
+
+// RedeemPointsRequest is the body of POST /invoices/:invoice_id/points.
+type RedeemPointsRequest struct {
+	Points int `json:"points" validate:"required,min=1"`
+}
+
+// RedeemPoints handles POST /invoices/:invoice_id/points, taking a member's
+// points off a pending invoice linked to them. Points worth more than is
+// left to pay are not taken.
+func RedeemPoints() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var request RedeemPointsRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		var invoice models.Invoice
+		err := invoiceCollection.FindOne(ctx, notDeleted(bson.M{"invoice_id": c.Param("invoice_id")})).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Invoice not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the invoice"))
+			}
+			return
+		}
+		if err := checkIfMatch(c, invoice.Version); err != nil {
+			c.Error(err)
+			return
+		}
+		if *invoice.Payment_status != "PENDING" {
+			c.Error(apperrors.Conflict("points can only be redeemed on pending invoices"))
+			return
+		}
+		if invoice.Customer_id == nil {
+			c.Error(apperrors.Conflict("the invoice has no loyalty member"))
+			return
+		}
+		if invoice.Points_redeemed != nil {
+			c.Error(apperrors.Conflict("points were already redeemed on this invoice"))
+			return
+		}
+
+		settings, err := loadLoyaltySettings(ctx)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if request.Points < settings.Min_redeem_points {
+			c.Error(apperrors.Unprocessable(fmt.Sprintf("at least %d points must be redeemed", settings.Min_redeem_points)))
+			return
+		}
+		view, err := viewInvoice(ctx, invoice)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		points := min(request.Points, int(math.Floor(view.Payment_due/settings.Point_value+1e-9)))
+		if points <= 0 {
+			c.Error(apperrors.Unprocessable("nothing is left to pay on this invoice"))
+			return
+		}
+		discount := toFixed(float64(points)*settings.Point_value, 2)
+
+		uid := c.GetString("uid")
+		entry, err := moveLoyaltyPoints(ctx, *invoice.Customer_id, models.LoyaltyRedeem, -points, &invoice.Invoice_id, "", uid, "")
+		if err == errNotEnoughPoints {
+			c.Error(apperrors.Conflict("the member does not have enough points"))
+			return
+		} else if err != nil {
+			c.Error(apperrors.Internal(err, "points were not redeemed"))
+			return
+		}
+
+		redeemed := models.InvoicePoints{Points: points, Discount: discount, Entry_id: entry.Entry_id}
+		// The points were checked against what was due on this version
+		filter := notDeleted(bson.M{"invoice_id": invoice.Invoice_id, "payment_status": "PENDING", "version": invoice.Version})
+		update := bson.M{
+			"$set": bson.M{"points_redeemed": redeemed, "updated_at": time.Now()},
+			"$inc": bson.M{"version": 1},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = invoiceCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&invoice)
+		if err != nil {
+			if _, reversalErr := moveLoyaltyPoints(ctx, *invoice.Customer_id, models.LoyaltyRedeemReversal, points, &invoice.Invoice_id, "", uid, ""); reversalErr != nil {
+				log.Printf("loyalty: %d points could not be given back to member %s after redeeming them on invoice %s failed: %v", points, *invoice.Customer_id, invoice.Invoice_id, reversalErr)
+				c.Error(apperrors.Internal(reversalErr, "points were not redeemed and could not be given back"))
+				return
+			}
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.Conflict("Invoice was paid or changed by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "points were not redeemed"))
+			}
+			return
+		}
+
+		view, err = viewInvoice(ctx, invoice)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.Header("ETag", etag(invoice.Version))
+		c.JSON(http.StatusOK, view)
+	}
+}
+
+// ReturnPoints handles DELETE /invoices/:invoice_id/points, taking the
+// points discount off a pending invoice and giving the points back.
+func ReturnPoints() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var invoice models.Invoice
+		err := invoiceCollection.FindOne(ctx, notDeleted(bson.M{"invoice_id": c.Param("invoice_id")})).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Invoice not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the invoice"))
+			}
+			return
+		}
+		if err := checkIfMatch(c, invoice.Version); err != nil {
+			c.Error(err)
+			return
+		}
+		if invoice.Points_redeemed == nil {
+			c.Error(apperrors.NotFound("No points are redeemed on this invoice"))
+			return
+		}
+		if *invoice.Payment_status != "PENDING" {
+			c.Error(apperrors.Conflict("points can only be returned from pending invoices"))
+			return
+		}
+		redeemed := *invoice.Points_redeemed
+
+		// Unsetting the points first means only one request can return them
+		filter := notDeleted(bson.M{"invoice_id": invoice.Invoice_id, "payment_status": "PENDING", "points_redeemed.entry_id": redeemed.Entry_id})
+		filter["version"] = invoice.Version
+		update := bson.M{
+			"$set": bson.M{"points_redeemed": nil, "updated_at": time.Now()},
+			"$inc": bson.M{"version": 1},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = invoiceCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&invoice)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.Conflict("Invoice was paid or changed by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "points were not returned"))
+			}
+			return
+		}
+
+		_, err = moveLoyaltyPoints(ctx, *invoice.Customer_id, models.LoyaltyRedeemReversal, redeemed.Points, &invoice.Invoice_id, "", c.GetString("uid"), "")
+		if err != nil {
+			log.Printf("loyalty: %d points taken off invoice %s could not be given back to member %s: %v", redeemed.Points, invoice.Invoice_id, *invoice.Customer_id, err)
+			c.Error(apperrors.Internal(err, "points were not given back"))
+			return
+		}
+
+		view, err := viewInvoice(ctx, invoice)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.Header("ETag", etag(invoice.Version))
+		c.JSON(http.StatusOK, view)
+	}
+}
+
+var loyaltyLedgerSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "type", Field: "type", Kind: query.String},
+		{Param: "invoice_id", Field: "invoice_id", Kind: query.String},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"created_at"},
+	DefaultSort: "-created_at",
+}
+
+// GetLoyaltyLedger handles GET /customers/:customer_id/loyalty/ledger.
+func GetLoyaltyLedger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, loyaltyLedgerSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		list.Filter["customer_id"] = c.Param("customer_id")
+		list.Filter["status"] = bson.M{"$ne": models.EntryFailed}
+
+		page, err := query.Find[models.LoyaltyEntry](ctx, loyaltyEntryCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing the loyalty ledger"))
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// InvoicePointsMismatch is an invoice whose points do not add up to what
+// the ledger moved for it.
+type InvoicePointsMismatch struct {
+	Invoice_id string `json:"invoice_id"`
+	Expected   int    `json:"expected"`
+	Ledger     int    `json:"ledger"`
+}
+
+// LoyaltySummary is what GET /customers/:customer_id/loyalty returns: the
+// member's standing and whether their ledger reconciles with their balance
+// and their invoices.
+type LoyaltySummary struct {
+	models.LoyaltyAccount
+	Tier           models.LoyaltyTier      `json:"tier"`
+	Expires_at     *time.Time              `json:"expires_at"`
+	Ledger_balance int                     `json:"ledger_balance"`
+	Reconciled     bool                    `json:"reconciled"`
+	Mismatches     []InvoicePointsMismatch `json:"mismatches"`
+}
+
+// GetLoyaltySummary handles GET /customers/:customer_id/loyalty. An
+// invoice is expected to have moved the points it earned, less the points
+// redeemed on it unless it was refunded.
+func GetLoyaltySummary() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		var customer models.Customer
+		err := customerCollection.FindOne(ctx, notDeleted(bson.M{"customer_id": c.Param("customer_id")})).Decode(&customer)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Customer not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the customer"))
+			}
+			return
+		}
+		settings, err := loadLoyaltySettings(ctx)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		summary := LoyaltySummary{
+			LoyaltyAccount: customer.Loyalty,
+			Tier:           settings.TierFor(customer.Loyalty.Lifetime_points),
+			Mismatches:     []InvoicePointsMismatch{},
+		}
+		if settings.Expiry_days > 0 && customer.Loyalty.Last_activity_at != nil && customer.Loyalty.Points > 0 {
+			expires := customer.Loyalty.Last_activity_at.AddDate(0, 0, settings.Expiry_days)
+			summary.Expires_at = &expires
+		}
+
+		cursor, err := loyaltyEntryCollection.Aggregate(ctx, bson.A{
+			bson.M{"$match": bson.M{"customer_id": customer.Customer_id, "status": bson.M{"$ne": models.EntryFailed}}},
+			bson.M{"$group": bson.M{"_id": "$invoice_id", "points": bson.M{"$sum": "$points"}}},
+		})
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while adding up the loyalty ledger"))
+			return
+		}
+		var sums []struct {
+			Invoice_id *string `bson:"_id"`
+			Points     int     `bson:"points"`
+		}
+		if err := cursor.All(ctx, &sums); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding the loyalty ledger"))
+			return
+		}
+		ledger := map[string]int{}
+		for _, sum := range sums {
+			summary.Ledger_balance += sum.Points
+			if sum.Invoice_id != nil {
+				ledger[*sum.Invoice_id] = sum.Points
+			}
+		}
+
+		cursor, err = invoiceCollection.Find(ctx, bson.M{"customer_id": customer.Customer_id})
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while fetching the member's invoices"))
+			return
+		}
+		var invoices []models.Invoice
+		if err := cursor.All(ctx, &invoices); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding the member's invoices"))
+			return
+		}
+		for _, invoice := range invoices {
+			ledgerPoints := ledger[invoice.Invoice_id]
+			delete(ledger, invoice.Invoice_id)
+			expected := invoice.Points_earned
+			if invoice.Points_redeemed != nil && *invoice.Payment_status != "REFUNDED" {
+				expected -= invoice.Points_redeemed.Points
+			}
+			if expected != ledgerPoints {
+				summary.Mismatches = append(summary.Mismatches, InvoicePointsMismatch{
+					Invoice_id: invoice.Invoice_id,
+					Expected:   expected,
+					Ledger:     ledgerPoints,
+				})
+			}
+		}
+		// Points moved for invoices that are no longer the member's
+		for invoiceID, points := range ledger {
+			if points != 0 {
+				summary.Mismatches = append(summary.Mismatches, InvoicePointsMismatch{Invoice_id: invoiceID, Ledger: points})
+			}
+		}
+		slices.SortFunc(summary.Mismatches, func(a, b InvoicePointsMismatch) int { return strings.Compare(a.Invoice_id, b.Invoice_id) })
+		summary.Reconciled = summary.Ledger_balance == customer.Loyalty.Points && len(summary.Mismatches) == 0
+
+		c.JSON(http.StatusOK, summary)
+	}
+}
@@ -2,9 +2,14 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"restorent-management/apperrors"
 	"restorent-management/database"
+	"restorent-management/helper"
 	"restorent-management/models"
+	"restorent-management/query"
+	"slices"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,10 +17,82 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var menuCollection *mongo.Collection = database.OpenCollection(database.Client, "menu")
 
+// ActiveMenuView is a menu that is being served together with its foods.
+type ActiveMenuView struct {
+	models.Menu
+	Foods []models.Food `json:"foods"`
+}
+
+// validateMenuWindows checks what the struct tags cannot: that the date
+// range and every schedule end after they start.
+func validateMenuWindows(menu models.Menu) error {
+	return validateWindows(menu.Start_Date, menu.End_Date, menu.Schedules)
+}
+
+// validateWindows checks a date range and weekly schedules, as used by
+// menus and promotions.
+func validateWindows(start *time.Time, end *time.Time, schedules []models.MenuSchedule) error {
+	var fields []apperrors.FieldError
+
+	if start != nil && end != nil && !end.After(*start) {
+		fields = append(fields, apperrors.FieldError{Field: "End_Date", Rule: "gtfield", Message: "must be after start_date"})
+	}
+
+	for i, schedule := range schedules {
+		if schedule.End_time <= schedule.Start_time {
+			fields = append(fields, apperrors.FieldError{
+				Field:   fmt.Sprintf("Schedules[%d].End_time", i),
+				Rule:    "gtfield",
+				Message: "must be after start_time",
+			})
+		}
+	}
+
+	if len(fields) > 0 {
+		return apperrors.InvalidFields(fields)
+	}
+	return nil
+}
+
+// prepareMenuCategories gives new categories and sections an ID and checks
+// that section IDs sent by the client are not repeated. IDs already set are
+// kept so that foods stay in their sections.
+func prepareMenuCategories(categories []models.MenuCategory) error {
+	var fields []apperrors.FieldError
+	seen := map[string]bool{}
+	for i := range categories {
+		category := &categories[i]
+		if category.Category_id == "" {
+			category.Category_id = primitive.NewObjectID().Hex()
+		}
+		if seen[category.Category_id] {
+			fields = append(fields, apperrors.FieldError{Field: fmt.Sprintf("Categories[%d].Category_id", i), Rule: "unique", Message: "is used more than once"})
+		}
+		seen[category.Category_id] = true
+
+		for j := range category.Sections {
+			section := &category.Sections[j]
+			if section.Section_id == "" {
+				section.Section_id = primitive.NewObjectID().Hex()
+			}
+			if seen[section.Section_id] {
+				fields = append(fields, apperrors.FieldError{Field: fmt.Sprintf("Categories[%d].Sections[%d].Section_id", i, j), Rule: "unique", Message: "is used more than once"})
+			}
+			seen[section.Section_id] = true
+		}
+	}
+
+	if len(fields) > 0 {
+		return apperrors.InvalidFields(fields)
+	}
+	return nil
+}
+
 func CreateMenu() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -23,7 +100,7 @@ func CreateMenu() gin.HandlerFunc {
 		defer cancel()
 
 		if err := c.ShouldBindJSON(&menu); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
 
@@ -34,9 +111,16 @@ func CreateMenu() gin.HandlerFunc {
 		err := validate.Struct(menu)
 
 		if err != nil {
-			// Validation failed, handle the error
-			errors := err.(validator.ValidationErrors)
-			c.JSON(http.StatusBadRequest, gin.H{"error": errors})
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		if err := validateMenuWindows(menu); err != nil {
+			c.Error(err)
+			return
+		}
+		if err := prepareMenuCategories(menu.Categories); err != nil {
+			c.Error(err)
 			return
 		}
 
@@ -45,11 +129,12 @@ func CreateMenu() gin.HandlerFunc {
 		menu.Created_at = now
 		menu.Updated_at = now
 		menu.ID = primitive.NewObjectID()
+		menu.Version = 1
 		menu.Menu_id = menu.ID.Hex()
 
 		result, err := menuCollection.InsertOne(ctx, menu)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Error(apperrors.Internal(err, ""))
 			return
 		}
 		defer cancel()
@@ -57,25 +142,37 @@ func CreateMenu() gin.HandlerFunc {
 	}
 }
 
+var menuListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "category", Field: "category", Kind: query.String},
+		{Param: "name", Field: "name", Kind: query.String},
+		{Param: "start_date", Field: "start_date", Kind: query.Date},
+		{Param: "end_date", Field: "end_date", Kind: query.Date},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"name", "category", "start_date", "end_date", "created_at"},
+	DefaultSort: "name",
+}
+
 func GetMenus() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		cursor, err := menuCollection.Find(ctx, bson.D{})
+		list, err := query.Parse(c, menuListSpec)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
-		defer cursor.Close(ctx)
+		notDeleted(list.Filter)
 
-		var menus []models.Menu
-		if err := cursor.All(ctx, &menus); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		page, err := query.Find[models.Menu](ctx, menuCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, ""))
 			return
 		}
 
-		c.JSON(http.StatusOK, menus)
+		c.JSON(http.StatusOK, page)
 	}
 }
 
@@ -85,11 +182,20 @@ func GetMenuByID() gin.HandlerFunc {
 		menuId := c.Param("menu_id")
 		var menu models.Menu
 
-		err := menuCollection.FindOne(ctx, bson.M{"menu_id": menuId}).Decode(&menu)
+		err := menuCollection.FindOne(ctx, notDeleted(bson.M{"menu_id": menuId})).Decode(&menu)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the menu"})
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Menu not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the menu"))
+			}
+			return
+		}
+		if notModified(c, menu.Version) {
+			return
 		}
+
 		c.JSON(http.StatusOK, menu)
 	}
 }
@@ -100,36 +206,388 @@ func UpdateMenu() gin.HandlerFunc {
 		defer cancel()
 
 		menuId := c.Param("menu_id")
+		filter := notDeleted(bson.M{"menu_id": menuId})
 		var menu models.Menu
-		err := menuCollection.FindOne(ctx, bson.M{"menu_id": menuId}).Decode(&menu)
+		err := menuCollection.FindOne(ctx, filter).Decode(&menu)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching user"})
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Menu not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the menu"))
+			}
 			return
 		}
+		original := menu
 
-		if err := c.ShouldBindJSON(&menu); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if err := checkIfMatch(c, menu.Version); err != nil {
+			c.Error(err)
 			return
 		}
 
-		// Create a new validator instance
-		validate := validator.New()
+		if err := applyMergePatch(c, &menu); err != nil {
+			c.Error(err)
+			return
+		}
 
-		// Validate the menu struct
+		menu.ID = original.ID
+		menu.Menu_id = original.Menu_id
+		menu.Created_at = original.Created_at
+		menu.Version = original.Version + 1
+		menu.Deleted_at = original.Deleted_at
+		menu.Deleted_by = original.Deleted_by
+
+		// Validate the merged menu
 		err = validate.Struct(menu)
 		if err != nil {
-			// Validation failed, handle the error
-			errors := err.(validator.ValidationErrors)
-			c.JSON(http.StatusBadRequest, gin.H{"error": errors})
+			c.Error(apperrors.Validation(err))
+			return
+		}
+		if err := validateMenuWindows(menu); err != nil {
+			c.Error(err)
+			return
+		}
+		if err := prepareMenuCategories(menu.Categories); err != nil {
+			c.Error(err)
 			return
 		}
 		menu.Updated_at = time.Now()
-		result, err := menuCollection.UpdateOne(ctx, bson.M{"menu_id": menuId}, bson.M{"$set": menu})
+
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = menuCollection.FindOneAndReplace(ctx, filter, menu, opts).Decode(&menu)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Menu was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, ""))
+			}
+			return
+		}
+
+		// Foods in sections that were removed are left without a section
+		if err := unassignRemovedSections(ctx, original, menu); err != nil {
+			c.Error(err)
 			return
 		}
+
+		c.Header("ETag", etag(menu.Version))
+		c.JSON(http.StatusOK, menu)
+	}
+}
+
+func unassignRemovedSections(ctx context.Context, original models.Menu, menu models.Menu) error {
+	removed := bson.A{}
+	for _, category := range original.Categories {
+		for _, section := range category.Sections {
+			if !menu.HasSection(section.Section_id) {
+				removed = append(removed, section.Section_id)
+			}
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	filter := bson.M{"menu_id": menu.Menu_id, "section_id": bson.M{"$in": removed}}
+	update := bson.M{"$set": bson.M{"section_id": nil, "updated_at": time.Now()}, "$inc": bson.M{"version": 1}}
+	if _, err := foodCollection.UpdateMany(ctx, filter, update); err != nil {
+		return apperrors.Internal(err, "error occured while removing foods from the deleted sections")
+	}
+	return nil
+}
+
+// GetActiveMenus returns the menus served at the time given by the "at"
+// query parameter (RFC 3339, defaulting to now) with their foods.
+func GetActiveMenus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		c.JSON(http.StatusOK, result)
+
+		at := time.Now()
+		if raw := c.Query("at"); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				c.Error(apperrors.BadRequest("at must be an RFC 3339 timestamp"))
+				return
+			}
+			at = parsed
+		}
+
+		foodFilter := notDeleted(bson.M{})
+		if err := applyDietaryFilters(c, foodFilter); err != nil {
+			c.Error(err)
+			return
+		}
+
+		menus, err := activeMenus(ctx, at)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while fetching the menus"))
+			return
+		}
+
+		menuIds := make([]string, 0, len(menus))
+		for _, menu := range menus {
+			menuIds = append(menuIds, menu.Menu_id)
+		}
+
+		foodFilter["menu_id"] = bson.M{"$in": menuIds}
+		cursor, err := foodCollection.Find(ctx, foodFilter, options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while fetching the foods"))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var foods []models.Food
+		if err := cursor.All(ctx, &foods); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding the foods"))
+			return
+		}
+
+		views := make([]ActiveMenuView, 0, len(menus))
+		for _, menu := range menus {
+			view := ActiveMenuView{Menu: menu, Foods: []models.Food{}}
+			for _, food := range foods {
+				if *food.Menu_id != menu.Menu_id {
+					continue
+				}
+				if orderable, ok := food.OrderableVariants(); ok {
+					view.Foods = append(view.Foods, orderable)
+				}
+			}
+			views = append(views, view)
+		}
+
+		c.JSON(http.StatusOK, views)
+	}
+}
+
+// activeMenus returns the menus whose date range and schedules include at.
+// The date range is filtered in Mongo, the weekly schedules in Go.
+func activeMenus(ctx context.Context, at time.Time) ([]models.Menu, error) {
+	filter := notDeleted(bson.M{
+		"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"start_date": nil}, bson.M{"start_date": bson.M{"$lte": at}}}},
+			bson.M{"$or": bson.A{bson.M{"end_date": nil}, bson.M{"end_date": bson.M{"$gt": at}}}},
+		},
+	})
+
+	cursor, err := menuCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var menus []models.Menu
+	if err := cursor.All(ctx, &menus); err != nil {
+		return nil, err
+	}
+
+	local := at.In(helper.Location)
+	active := make([]models.Menu, 0, len(menus))
+	for _, menu := range menus {
+		if menu.IsActiveAt(local) {
+			active = append(active, menu)
+		}
+	}
+	return active, nil
+}
+
+// MenuTree is a menu laid out for display: its categories and sections in
+// order, each section with its foods in order.
+type MenuTree struct {
+	models.Menu
+	Is_active         bool               `json:"is_active"`
+	Categories        []MenuCategoryTree `json:"categories"`
+	Unsectioned_foods []models.Food      `json:"unsectioned_foods"`
+}
+
+type MenuCategoryTree struct {
+	Category_id string            `json:"category_id"`
+	Name        string            `json:"name"`
+	Position    int               `json:"position"`
+	Sections    []MenuSectionTree `json:"sections"`
+}
+
+type MenuSectionTree struct {
+	models.MenuSection
+	Foods []models.Food `json:"foods"`
+}
+
+// GetFullMenu handles GET /menus/:menu_id/full. Foods whose variants are
+// all unavailable are left out; foods that are sold out are kept with their
+// availability so that screens can show them greyed out. The
+// exclude_allergens and dietary filters of GET /foods apply.
+func GetFullMenu() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		foodFilter := notDeleted(bson.M{"menu_id": c.Param("menu_id")})
+		if err := applyDietaryFilters(c, foodFilter); err != nil {
+			c.Error(err)
+			return
+		}
+
+		var menu models.Menu
+		err := menuCollection.FindOne(ctx, notDeleted(bson.M{"menu_id": c.Param("menu_id")})).Decode(&menu)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Menu not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the menu"))
+			}
+			return
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "name", Value: 1}})
+		cursor, err := foodCollection.Find(ctx, foodFilter, opts)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while fetching the foods"))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var foods []models.Food
+		if err := cursor.All(ctx, &foods); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding the foods"))
+			return
+		}
+
+		c.JSON(http.StatusOK, menuTree(menu, foods, time.Now()))
+	}
+}
+
+// menuTree places foods, already in display order, under their sections.
+// Foods whose section no longer exists are shown as unsectioned.
+func menuTree(menu models.Menu, foods []models.Food, at time.Time) MenuTree {
+	tree := MenuTree{
+		Menu:              menu,
+		Is_active:         menu.IsActiveAt(at.In(helper.Location)),
+		Categories:        make([]MenuCategoryTree, 0, len(menu.Categories)),
+		Unsectioned_foods: []models.Food{},
+	}
+
+	bySection := map[string][]models.Food{}
+	for _, food := range foods {
+		orderable, ok := food.OrderableVariants()
+		if !ok {
+			continue
+		}
+		if food.Section_id != nil && menu.HasSection(*food.Section_id) {
+			bySection[*food.Section_id] = append(bySection[*food.Section_id], orderable)
+		} else {
+			tree.Unsectioned_foods = append(tree.Unsectioned_foods, orderable)
+		}
+	}
+
+	categories := slices.Clone(menu.Categories)
+	slices.SortStableFunc(categories, func(a, b models.MenuCategory) int { return a.Position - b.Position })
+	for _, category := range categories {
+		node := MenuCategoryTree{
+			Category_id: category.Category_id,
+			Name:        category.Name,
+			Position:    category.Position,
+			Sections:    make([]MenuSectionTree, 0, len(category.Sections)),
+		}
+
+		sections := slices.Clone(category.Sections)
+		slices.SortStableFunc(sections, func(a, b models.MenuSection) int { return a.Position - b.Position })
+		for _, section := range sections {
+			sectionFoods := bySection[section.Section_id]
+			if sectionFoods == nil {
+				sectionFoods = []models.Food{}
+			}
+			node.Sections = append(node.Sections, MenuSectionTree{MenuSection: section, Foods: sectionFoods})
+		}
+		tree.Categories = append(tree.Categories, node)
+	}
+	return tree
+}
+
+// SectionOrderRequest lists the foods of a section in display order.
+type SectionOrderRequest struct {
+	Food_ids []string `json:"food_ids" validate:"required,unique,dive,required"`
+}
+
+// SetSectionOrder handles PUT /menus/:menu_id/sections/:section_id/foods.
+// The listed foods are moved into the section and numbered in the order
+// given, so a POS can save a drag and drop in one request.
+func SetSectionOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var request SectionOrderRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		menuID, sectionID := c.Param("menu_id"), c.Param("section_id")
+		var menu models.Menu
+		err := menuCollection.FindOne(ctx, notDeleted(bson.M{"menu_id": menuID})).Decode(&menu)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Menu not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the menu"))
+			}
+			return
+		}
+		if !menu.HasSection(sectionID) {
+			c.Error(apperrors.NotFound("Section not found"))
+			return
+		}
+
+		count, err := foodCollection.CountDocuments(ctx, notDeleted(bson.M{"menu_id": menuID, "food_id": bson.M{"$in": request.Food_ids}}))
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while fetching the foods"))
+			return
+		}
+		if count != int64(len(request.Food_ids)) {
+			c.Error(apperrors.BadRequest("every food must exist and belong to the menu"))
+			return
+		}
+
+		// Foods already in the section but not listed follow the listed ones
+		// in their current order
+		unlisted := notDeleted(bson.M{"menu_id": menuID, "section_id": sectionID, "food_id": bson.M{"$nin": request.Food_ids}})
+		opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "name", Value: 1}}).SetProjection(bson.M{"food_id": 1})
+		cursor, err := foodCollection.Find(ctx, unlisted, opts)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while fetching the foods"))
+			return
+		}
+		var rest []struct {
+			Food_id string `bson:"food_id"`
+		}
+		if err := cursor.All(ctx, &rest); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding the foods"))
+			return
+		}
+		order := slices.Clone(request.Food_ids)
+		for _, food := range rest {
+			order = append(order, food.Food_id)
+		}
+
+		now := time.Now()
+		writes := make([]mongo.WriteModel, 0, len(order))
+		for position, foodID := range order {
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"food_id": foodID}).
+				SetUpdate(bson.M{"$set": bson.M{"section_id": sectionID, "position": position, "updated_at": now}, "$inc": bson.M{"version": 1}}))
+		}
+		if _, err := foodCollection.BulkWrite(ctx, writes); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while ordering the foods"))
+			return
+		}
+
+		c.Status(http.StatusNoContent)
 	}
 }
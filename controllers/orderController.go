@@ -3,8 +3,10 @@ package controllers
 import (
 	"context"
 	"net/http"
+	"restorent-management/apperrors"
 	"restorent-management/database"
 	"restorent-management/models"
+	"restorent-management/query"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,24 +18,90 @@ import (
 
 var orderCollection *mongo.Collection = database.OpenCollection(database.Client, "order")
 
+var orderListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "order_type", Field: "order_type", Kind: query.String},
+		{Param: "table_id", Field: "table_id", Kind: query.String},
+		{Param: "pickup_time", Field: "pickup_time", Kind: query.Date},
+		{Param: "customer_id", Field: "customer_id", Kind: query.String},
+		{Param: "order_date", Field: "order_date", Kind: query.Date},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"order_date", "pickup_time", "created_at", "updated_at"},
+	DefaultSort: "-order_date",
+}
+
+// checkTable checks that the table of a dine-in order exists. Takeaway and
+// delivery orders have no table.
+func checkTable(ctx context.Context, tableID *string) error {
+	if tableID == nil {
+		return nil
+	}
+	err := tableCollection.FindOne(ctx, notDeleted(bson.M{"table_id": *tableID})).Err()
+	if err == mongo.ErrNoDocuments {
+		return apperrors.BadRequest("Table was not found")
+	} else if err != nil {
+		return apperrors.Internal(err, "error occured while fetching the table")
+	}
+	return nil
+}
+
+// validateOrder checks what the struct tags cannot: the fields each order
+// type needs. Dine-in orders are at a table, takeaway orders have a pickup
+// time and a contact, and delivery orders a contact and an address. A table,
+// pickup time or address on an order of another type is rejected rather than
+// kept unused.
+func validateOrder(order models.Order) error {
+	var fields []apperrors.FieldError
+
+	checks := []struct {
+		field  string
+		set    bool
+		needed bool
+	}{
+		{"Table_id", order.Table_id != nil, order.Order_type == models.OrderDineIn},
+		{"Pickup_time", order.Pickup_time != nil, order.Order_type == models.OrderTakeaway},
+		{"Contact", order.Contact != nil, order.Order_type != models.OrderDineIn},
+		{"Delivery_address", order.Delivery_address != nil, order.Order_type == models.OrderDelivery},
+	}
+	for _, check := range checks {
+		switch {
+		case check.needed && !check.set:
+			fields = append(fields, apperrors.FieldError{Field: check.field, Rule: "required_if", Message: "is required for " + order.Order_type + " orders"})
+		// Dine-in orders may still leave a contact
+		case !check.needed && check.set && check.field != "Contact":
+			fields = append(fields, apperrors.FieldError{Field: check.field, Rule: "excluded_if", Message: "must be empty for " + order.Order_type + " orders"})
+		}
+	}
+	if order.Pickup_time != nil && order.Pickup_time.Before(order.Order_Date) {
+		fields = append(fields, apperrors.FieldError{Field: "Pickup_time", Rule: "gtefield", Message: "must not be before order_date"})
+	}
+
+	if len(fields) > 0 {
+		return apperrors.InvalidFields(fields)
+	}
+	return nil
+}
+
 func GetOrders() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		result, err := orderCollection.Find(ctx, bson.M{})
+		list, err := query.Parse(c, orderListSpec)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing order items"})
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
+		notDeleted(list.Filter)
 
-		var allOrders []bson.M
-		if err = result.All(ctx, &allOrders); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding order items"})
+		page, err := query.Find[bson.M](ctx, orderCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "Error occurred while listing order items"))
 			return
 		}
 
-		c.JSON(http.StatusOK, allOrders)
+		c.JSON(http.StatusOK, page)
 	}
 }
 
@@ -45,16 +113,20 @@ func GetOrderByID() gin.HandlerFunc {
 		orderId := c.Param("order_id")
 		var order models.Order
 
-		err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order)
+		err := orderCollection.FindOne(ctx, notDeleted(bson.M{"order_id": orderId})).Decode(&order)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+				c.Error(apperrors.NotFound("Order not found"))
 			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching the order"})
+				c.Error(apperrors.Internal(err, "Error occurred while fetching the order"))
 			}
 			return
 		}
 
+		if notModified(c, order.Version) {
+			return
+		}
+
 		c.JSON(http.StatusOK, order)
 	}
 }
@@ -64,31 +136,36 @@ func CreateOrder() gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var table models.Table
 		var order models.Order
 
 		if err := c.BindJSON(&order); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
 
+		order.Normalize()
 		validationErr := validate.Struct(order)
 		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			c.Error(apperrors.Validation(validationErr))
+			return
+		}
+		if err := validateOrder(order); err != nil {
+			c.Error(err)
 			return
 		}
 
-		if order.Table_id != nil {
-			err := tableCollection.FindOne(ctx, bson.M{"table_id": order.Table_id}).Decode(&table)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Table was not found"})
-				return
-			}
+		if err := checkTable(ctx, order.Table_id); err != nil {
+			c.Error(err)
+			return
+		}
+		if err := checkCustomer(ctx, order.Customer_id); err != nil {
+			c.Error(err)
+			return
 		}
 
 		orderId, err := OrderItemOrderCreator(ctx, order)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Order item was not created"})
+			c.Error(apperrors.Internal(err, "Order item was not created"))
 			return
 		}
 
@@ -101,47 +178,79 @@ func UpdateOrder() gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var table models.Table
 		var order models.Order
-		var updateObj primitive.D
 
 		orderId := c.Param("order_id")
-		if err := c.BindJSON(&order); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		filter := notDeleted(bson.M{"order_id": orderId})
+
+		err := orderCollection.FindOne(ctx, filter).Decode(&order)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Order not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "Error occurred while fetching the order"))
+			}
+			return
+		}
+		original := order
+
+		if err := checkIfMatch(c, order.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		if err := applyMergePatch(c, &order); err != nil {
+			c.Error(err)
+			return
+		}
+
+		order.ID = original.ID
+		order.Order_id = original.Order_id
+		order.Created_at = original.Created_at
+		order.Version = original.Version + 1
+		order.Deleted_at = original.Deleted_at
+		order.Deleted_by = original.Deleted_by
+		order.Normalize()
+
+		if err := validate.Struct(order); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+		if err := validateOrder(order); err != nil {
+			c.Error(err)
 			return
 		}
 
-		if order.Table_id != nil {
-			err := tableCollection.FindOne(ctx, bson.M{"table_id": order.Table_id}).Decode(&table)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Table was not found"})
+		if order.Table_id != nil && (original.Table_id == nil || *order.Table_id != *original.Table_id) {
+			if err := checkTable(ctx, order.Table_id); err != nil {
+				c.Error(err)
+				return
+			}
+		}
+		if order.Customer_id != nil && (original.Customer_id == nil || *order.Customer_id != *original.Customer_id) {
+			if err := checkCustomer(ctx, order.Customer_id); err != nil {
+				c.Error(err)
 				return
 			}
-			updateObj = append(updateObj, bson.E{"table_id", order.Table_id})
 		}
 
 		order.Updated_at = time.Now()
-		updateObj = append(updateObj, bson.E{"updated_at", order.Updated_at})
-
-		upsert := true
-		filter := bson.M{"order_id": orderId}
-		opt := options.Update().SetUpsert(upsert)
-
-		result, err := orderCollection.UpdateOne(
-			ctx,
-			filter,
-			bson.D{
-				{"$set", updateObj},
-			},
-			opt,
-		)
 
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = orderCollection.FindOneAndReplace(ctx, filter, order, opts).Decode(&order)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Order item update failed"})
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Order was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "Order item update failed"))
+			}
 			return
 		}
 
-		c.JSON(http.StatusOK, result)
+		c.Header("ETag", etag(order.Version))
+		c.JSON(http.StatusOK, order)
 	}
 }
 
@@ -149,6 +258,7 @@ func OrderItemOrderCreator(ctx context.Context, order models.Order) (string, err
 	order.Created_at = time.Now()
 	order.Updated_at = time.Now()
 	order.ID = primitive.NewObjectID()
+	order.Version = 1
 	order.Order_id = order.ID.Hex()
 
 	_, err := orderCollection.InsertOne(ctx, order)
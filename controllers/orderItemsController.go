@@ -2,9 +2,16 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"reflect"
+	"restorent-management/apperrors"
 	"restorent-management/database"
+	"restorent-management/helper"
+	"restorent-management/middleware"
 	"restorent-management/models"
+	"restorent-management/query"
+	"slices"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,31 +23,48 @@ import (
 )
 
 type OrderItemPack struct {
-	Table_id    *string
-	Order_items []models.OrderItem
+	Order_type       string
+	Table_id         *string
+	Pickup_time      *time.Time
+	Contact          *models.OrderContact
+	Delivery_address *models.DeliveryAddress
+	Order_items      []models.OrderItem
 }
 
 var orderItemCollection *mongo.Collection = database.OpenCollection(database.Client, "orderItem")
 var validate = validator.New()
 
+var orderItemListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "order_id", Field: "order_id", Kind: query.String},
+		{Param: "food_id", Field: "food_id", Kind: query.String},
+		{Param: "quantity", Field: "quantity", Kind: query.String},
+		{Param: "unit_price", Field: "unit_price", Kind: query.Number},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"created_at", "unit_price", "order_id"},
+	DefaultSort: "-created_at",
+}
+
 func GetOrderItems() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		result, err := orderItemCollection.Find(ctx, bson.M{})
+		list, err := query.Parse(c, orderItemListSpec)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing ordered items"})
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
+		notDeleted(list.Filter)
 
-		var allOrderItems []bson.M
-		if err = result.All(ctx, &allOrderItems); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding ordered items"})
+		page, err := query.Find[bson.M](ctx, orderItemCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "Error occurred while listing ordered items"))
 			return
 		}
 
-		c.JSON(http.StatusOK, allOrderItems)
+		c.JSON(http.StatusOK, page)
 	}
 }
 
@@ -50,7 +74,7 @@ func GetOrderItemsByOrder() gin.HandlerFunc {
 
 		allOrderItems, err := ItemsByOrder(orderId)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing order items by order ID"})
+			c.Error(apperrors.Internal(err, "Error occurred while listing order items by order ID"))
 			return
 		}
 
@@ -58,42 +82,45 @@ func GetOrderItemsByOrder() gin.HandlerFunc {
 	}
 }
 
+// lookupActive joins the documents of another collection whose foreignField
+// equals localValue, leaving out the ones that have been soft deleted.
+func lookupActive(from string, localValue interface{}, foreignField string, as string) bson.D {
+	return bson.D{{"$lookup", bson.D{
+		{"from", from},
+		{"let", bson.D{{"local", localValue}}},
+		{"pipeline", bson.A{
+			bson.D{{"$match", bson.D{
+				{"deleted_at", nil},
+				{"$expr", bson.D{{"$eq", bson.A{"$" + foreignField, "$$local"}}}},
+			}}},
+		}},
+		{"as", as},
+	}}}
+}
+
 func ItemsByOrder(id string) ([]primitive.M, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	pipeline := mongo.Pipeline{
-		// Match documents with the given order_id
-		{{"$match", bson.D{{"order_id", id}}}},
+		// Match the order's items that have not been deleted
+		{{"$match", bson.D{{"order_id", id}, {"deleted_at", nil}}}},
 
 		// Lookup the food details
-		{{"$lookup", bson.D{
-			{"from", "food"},
-			{"localField", "food_id"},
-			{"foreignField", "food_id"},
-			{"as", "food"},
-		}}},
+		lookupActive("food", "$food_id", "food_id", "food"),
 
 		// Lookup the order details
-		{{"$lookup", bson.D{
-			{"from", "order"},
-			{"localField", "order_id"},
-			{"foreignField", "order_id"},
-			{"as", "order"},
-		}}},
+		lookupActive("order", "$order_id", "order_id", "order"),
 
 		// Lookup the table details
-		{{"$lookup", bson.D{
-			{"from", "table"},
-			{"localField", "order.table_id"},
-			{"foreignField", "table_id"},
-			{"as", "table"},
-		}}},
+		lookupActive("table", bson.D{{"$arrayElemAt", bson.A{"$order.table_id", 0}}}, "table_id", "table"),
 
 		// Project the necessary fields
 		{{"$project", bson.D{
 			{"_id", 0},
-			{"amount", bson.D{{"$arrayElemAt", bson.A{"$food.price", 0}}}},
+			// Items are charged at the unit price recorded when they were
+			// ordered, which already includes their modifiers
+			{"amount", bson.D{{"$ifNull", bson.A{"$unit_price", bson.D{{"$arrayElemAt", bson.A{"$food.price", 0}}}}}}},
 			{"food_name", bson.D{{"$arrayElemAt", bson.A{"$food.name", 0}}}},
 			{"food_image", bson.D{{"$arrayElemAt", bson.A{"$food.food_image", 0}}}},
 			{"table_number", bson.D{{"$arrayElemAt", bson.A{"$table.table_number", 0}}}},
@@ -101,6 +128,11 @@ func ItemsByOrder(id string) ([]primitive.M, error) {
 			{"order_id", 1},
 			{"price", bson.D{{"$arrayElemAt", bson.A{"$food.price", 0}}}},
 			{"quantity", 1},
+			{"unit_price", 1},
+			{"price_override", 1},
+			{"modifiers", 1},
+			{"components", 1},
+			{"discounts", 1},
 		}}},
 
 		// Group the results
@@ -146,16 +178,20 @@ func GetOrderItemsByID() gin.HandlerFunc {
 		orderItemId := c.Param("order_item_id")
 		var orderItem models.OrderItem
 
-		err := orderItemCollection.FindOne(ctx, bson.M{"order_item_id": orderItemId}).Decode(&orderItem)
+		err := orderItemCollection.FindOne(ctx, notDeleted(bson.M{"order_item_id": orderItemId})).Decode(&orderItem)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Order item not found"})
+				c.Error(apperrors.NotFound("Order item not found"))
 			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching the ordered item"})
+				c.Error(apperrors.Internal(err, "Error occurred while fetching the ordered item"))
 			}
 			return
 		}
 
+		if notModified(c, orderItem.Version) {
+			return
+		}
+
 		c.JSON(http.StatusOK, orderItem)
 	}
 }
@@ -167,48 +203,313 @@ func UpdateOrderItems() gin.HandlerFunc {
 
 		var orderItem models.OrderItem
 		orderItemId := c.Param("order_item_id")
+		filter := notDeleted(bson.M{"order_item_id": orderItemId})
 
-		if err := c.BindJSON(&orderItem); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		err := orderItemCollection.FindOne(ctx, filter).Decode(&orderItem)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Order item not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "Error occurred while fetching the ordered item"))
+			}
 			return
 		}
+		original := orderItem
 
-		filter := bson.M{"order_item_id": orderItemId}
-		updateObj := bson.D{}
+		if err := checkIfMatch(c, orderItem.Version); err != nil {
+			c.Error(err)
+			return
+		}
 
-		if orderItem.Unit_price != nil {
-			updateObj = append(updateObj, bson.E{"unit_price", orderItem.Unit_price})
+		if err := applyMergePatch(c, &orderItem); err != nil {
+			c.Error(err)
+			return
 		}
 
-		if orderItem.Quantity != nil {
-			updateObj = append(updateObj, bson.E{"quantity", orderItem.Quantity})
+		orderItem.ID = original.ID
+		orderItem.Order_item_id = original.Order_item_id
+		orderItem.Order_id = original.Order_id
+		orderItem.Created_at = original.Created_at
+		orderItem.Version = original.Version + 1
+		orderItem.Deleted_at = original.Deleted_at
+		orderItem.Deleted_by = original.Deleted_by
+
+		if err := validate.Struct(orderItem); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
 		}
 
-		if orderItem.Food_id != nil {
-			updateObj = append(updateObj, bson.E{"food_id", orderItem.Food_id})
+		// The unit price is only recomputed when the food, size or modifiers
+		// change. A unit_price in the patch is treated as an override, and
+		// setting it to null goes back to the catalog price.
+		orderItem.Price_override = original.Price_override
+		orderItem.Discounts = original.Discounts
+		requested := orderItem.Unit_price
+		priceChanged := !samePrice(requested, original.Unit_price)
+		orderItem.Unit_price = original.Unit_price
+
+		portions := map[string]int{}
+		var released map[string]int
+		repriced := *orderItem.Food_id != *original.Food_id || *orderItem.Quantity != *original.Quantity ||
+			!reflect.DeepEqual(orderItem.Modifiers, original.Modifiers) ||
+			!reflect.DeepEqual(orderItem.Components, original.Components)
+		if repriced || priceChanged {
+			var food models.Food
+			err := foodCollection.FindOne(ctx, notDeleted(bson.M{"food_id": *orderItem.Food_id})).Decode(&food)
+			if err != nil {
+				c.Error(apperrors.BadRequest("Food was not found"))
+				return
+			}
+
+			orderItem.Modifiers, err = food.ResolveModifiers(orderItem.Modifiers)
+			if err != nil {
+				c.Error(apperrors.Unprocessable(err.Error()))
+				return
+			}
+			componentFoods, err := resolveBundle(ctx, food, &orderItem, time.Now())
+			if err != nil {
+				c.Error(err)
+				return
+			}
+
+			catalog, err := catalogPrice(food, orderItem)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+			// Promotions are those that ran when the item was ordered
+			prices, err := newPricer(ctx, original.Created_at)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+			catalog, err = prices.discount(ctx, food, &orderItem, catalog)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+			if !priceChanged {
+				requested = nil
+			}
+			if err := settlePrice(c, &orderItem, catalog, requested); err != nil {
+				c.Error(err)
+				return
+			}
+
+			if *orderItem.Food_id != *original.Food_id {
+				if err := food.CheckAvailable(time.Now(), 1); err != nil {
+					c.Error(apperrors.Unprocessable(err.Error()))
+					return
+				}
+			}
+
+			// Switching to other foods takes a portion of each of them and
+			// gives the portions of the old ones back once the item is saved
+			var added []models.Food
+			added, released = changedFoods(original, append([]models.Food{food}, componentFoods...))
+			countPortions(portions, added...)
+			if err := reservePortions(ctx, portions); err != nil {
+				c.Error(err)
+				return
+			}
 		}
 
 		orderItem.Updated_at = time.Now()
-		updateObj = append(updateObj, bson.E{"updated_at", orderItem.Updated_at})
-
-		upsert := true
-		opt := options.Update().SetUpsert(upsert)
-
-		result, err := orderItemCollection.UpdateOne(
-			ctx,
-			filter,
-			bson.D{
-				{"$set", updateObj},
-			},
-			opt,
-		)
+
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = orderItemCollection.FindOneAndReplace(ctx, filter, orderItem, opts).Decode(&orderItem)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Order item update failed"})
+			releasePortions(ctx, portions)
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Order item was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "Order item update failed"))
+			}
 			return
 		}
 
-		c.JSON(http.StatusOK, result)
+		releasePortions(ctx, released)
+		if !slices.Equal(itemFoodIDs(orderItem), itemFoodIDs(original)) || *orderItem.Quantity != *original.Quantity {
+			moveStockForOrderItems(ctx, c.GetString("uid"), []models.OrderItem{original}, models.StockVoid)
+			moveStockForOrderItems(ctx, c.GetString("uid"), []models.OrderItem{orderItem}, models.StockSale)
+		}
+
+		c.Header("ETag", etag(orderItem.Version))
+		c.JSON(http.StatusOK, orderItem)
+	}
+}
+
+// catalogPrice is what one unit of an order item costs at the current
+// prices: the price of the ordered size plus the resolved modifiers and,
+// for a bundle, the extra charged for the components chosen.
+func catalogPrice(food models.Food, orderItem models.OrderItem) (float64, error) {
+	price, err := food.PriceFor(*orderItem.Quantity)
+	if err != nil {
+		return 0, apperrors.Unprocessable(err.Error())
+	}
+	return toFixed(price+orderItem.ModifiersTotal()+orderItem.ComponentsTotal(), 2), nil
+}
+
+// resolveBundle fills in the components of an ordered bundle from the foods
+// chosen for its slots and checks that each of them is served at the time
+// of the order. It returns the component foods in slot order.
+func resolveBundle(ctx context.Context, food models.Food, orderItem *models.OrderItem, at time.Time) ([]models.Food, error) {
+	components, err := food.ResolveBundle(orderItem.Components, *orderItem.Quantity)
+	if err != nil {
+		return nil, apperrors.Unprocessable(err.Error())
+	}
+
+	componentFoods := make([]models.Food, 0, len(components))
+	for i := range components {
+		component := &components[i]
+
+		var componentFood models.Food
+		err := foodCollection.FindOne(ctx, notDeleted(bson.M{"food_id": component.Food_id})).Decode(&componentFood)
+		if err == mongo.ErrNoDocuments {
+			return nil, apperrors.Unprocessable(fmt.Sprintf("the %s of %s is no longer offered", component.Slot_name, *food.Name))
+		} else if err != nil {
+			return nil, apperrors.Internal(err, "error occured while fetching the bundle's foods")
+		}
+		if err := checkServed(ctx, componentFood, at); err != nil {
+			return nil, err
+		}
+
+		price, err := componentFood.PriceFor(component.Size)
+		if err != nil {
+			return nil, apperrors.Unprocessable(err.Error())
+		}
+		component.Food_name = *componentFood.Name
+		component.Standalone_price = toFixed(price, 2)
+		componentFoods = append(componentFoods, componentFood)
 	}
+
+	orderItem.Components = components
+	return componentFoods, nil
+}
+
+// changedFoods compares the foods an order item used before a change with
+// the ones it uses now. It returns the foods that are new to the item and
+// the portions of the old foods it no longer uses.
+func changedFoods(original models.OrderItem, foods []models.Food) ([]models.Food, map[string]int) {
+	previous := map[string]int{}
+	for _, id := range itemFoodIDs(original) {
+		previous[id]++
+	}
+
+	var added []models.Food
+	for _, food := range foods {
+		if previous[food.Food_id] > 0 {
+			previous[food.Food_id]--
+		} else {
+			added = append(added, food)
+		}
+	}
+
+	removed := map[string]int{}
+	for id, count := range previous {
+		if count > 0 {
+			removed[id] = count
+		}
+	}
+	return added, removed
+}
+
+// itemFoodIDs lists the foods an order item takes a portion of: its own
+// food and, for a bundle, each component.
+func itemFoodIDs(orderItem models.OrderItem) []string {
+	ids := []string{*orderItem.Food_id}
+	for _, component := range orderItem.Components {
+		ids = append(ids, component.Food_id)
+	}
+	return ids
+}
+
+// countPortions adds a portion of each of the foods that are limited.
+func countPortions(portions map[string]int, foods ...models.Food) {
+	for _, food := range foods {
+		if food.Availability != nil && food.Availability.Status == models.AvailabilityLimited {
+			portions[food.Food_id]++
+		}
+	}
+}
+
+// settlePrice sets the unit price of an order item to the catalog price,
+// which is after the item's promotions. When the client asked for a
+// different price it is only accepted from users with the price_override
+// permission, and the override is recorded in place of the promotions.
+// The price of a bundle is then shared across its components.
+func settlePrice(c *gin.Context, orderItem *models.OrderItem, catalog float64, requested *float64) error {
+	if requested == nil || toFixed(*requested, 2) == catalog {
+		orderItem.Unit_price = &catalog
+		orderItem.Price_override = nil
+		orderItem.AllocateBundlePrice()
+		return nil
+	}
+
+	if !middleware.HasPermission(c, models.PermissionPriceOverride) {
+		return apperrors.Forbidden(fmt.Sprintf("unit_price %.2f differs from the catalog price %.2f and requires the %s permission",
+			*requested, catalog, models.PermissionPriceOverride))
+	}
+	if *requested < 0 {
+		return apperrors.BadRequest("unit_price cannot be negative")
+	}
+
+	price := toFixed(*requested, 2)
+	orderItem.Unit_price = &price
+	orderItem.Discounts = nil
+	orderItem.Price_override = &models.PriceOverride{
+		Catalog_price: catalog,
+		Overridden_by: c.GetString("uid"),
+		Overridden_at: time.Now(),
+	}
+	orderItem.AllocateBundlePrice()
+	return nil
+}
+
+func samePrice(a *float64, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return toFixed(*a, 2) == toFixed(*b, 2)
+}
+
+// orderableFood loads the food of a new order item and checks that its menu
+// is being served at the time of the order and that it has not been 86ed.
+func orderableFood(ctx context.Context, foodID string, at time.Time) (models.Food, error) {
+	var food models.Food
+	err := foodCollection.FindOne(ctx, notDeleted(bson.M{"food_id": foodID})).Decode(&food)
+	if err == mongo.ErrNoDocuments {
+		return food, apperrors.BadRequest(fmt.Sprintf("food %s was not found", foodID))
+	} else if err != nil {
+		return food, apperrors.Internal(err, "error occured while fetching the food")
+	}
+
+	return food, checkServed(ctx, food, at)
+}
+
+// checkServed checks that a food's menu is being served at the time of an
+// order and that the food has not been 86ed. Bundle components are checked
+// like the foods ordered on their own.
+func checkServed(ctx context.Context, food models.Food, at time.Time) error {
+	var menu models.Menu
+	err := menuCollection.FindOne(ctx, notDeleted(bson.M{"menu_id": food.Menu_id})).Decode(&menu)
+	if err == mongo.ErrNoDocuments {
+		return apperrors.Unprocessable(fmt.Sprintf("%s is not on any menu", *food.Name))
+	} else if err != nil {
+		return apperrors.Internal(err, "error occured while fetching the menu")
+	}
+
+	if !menu.IsActiveAt(at.In(helper.Location)) {
+		return apperrors.Unprocessable(fmt.Sprintf("%s is not being served right now, the %s menu is not active", *food.Name, menu.Name))
+	}
+
+	if err := food.CheckAvailable(at, 1); err != nil {
+		return apperrors.Unprocessable(err.Error())
+	}
+
+	return nil
 }
 
 func CreateOrderItems() gin.HandlerFunc {
@@ -220,52 +521,123 @@ func CreateOrderItems() gin.HandlerFunc {
 		var order models.Order
 
 		if err := c.BindJSON(&orderItemPack); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
 
 		validationErr := validate.Struct(orderItemPack)
 		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			c.Error(apperrors.Validation(validationErr))
 			return
 		}
 
 		order.Order_Date = time.Now()
+		order.Order_type = orderItemPack.Order_type
 		order.Table_id = orderItemPack.Table_id
+		order.Pickup_time = orderItemPack.Pickup_time
+		order.Contact = orderItemPack.Contact
+		order.Delivery_address = orderItemPack.Delivery_address
+		order.Normalize()
+		if err := validate.Struct(order); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+		if err := validateOrder(order); err != nil {
+			c.Error(err)
+			return
+		}
+		if err := checkTable(ctx, order.Table_id); err != nil {
+			c.Error(err)
+			return
+		}
 
-		orderId, err := OrderItemOrderCreator(ctx, order)
+		prices, err := newPricer(ctx, order.Order_Date)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Order creation failed"})
+			c.Error(err)
 			return
 		}
 
-		orderItemsToBeInserted := []interface{}{}
+		// Check every item before the order is created so that a rejected
+		// item does not leave an empty order behind.
+		orderItems := make([]models.OrderItem, 0, len(orderItemPack.Order_items))
+		portions := map[string]int{}
 		for _, orderItem := range orderItemPack.Order_items {
-			orderItem.Order_id = orderId
-
-			validationErr := validate.Struct(orderItem)
+			validationErr := validate.StructExcept(orderItem, "Order_id")
 			if validationErr != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+				c.Error(apperrors.Validation(validationErr))
+				return
+			}
+
+			food, err := orderableFood(ctx, *orderItem.Food_id, order.Order_Date)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+
+			orderItem.Modifiers, err = food.ResolveModifiers(orderItem.Modifiers)
+			if err != nil {
+				c.Error(apperrors.Unprocessable(err.Error()))
+				return
+			}
+			componentFoods, err := resolveBundle(ctx, food, &orderItem, order.Order_Date)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+
+			catalog, err := catalogPrice(food, orderItem)
+			if err != nil {
+				c.Error(err)
 				return
 			}
+			catalog, err = prices.discount(ctx, food, &orderItem, catalog)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+			if err := settlePrice(c, &orderItem, catalog, orderItem.Unit_price); err != nil {
+				c.Error(err)
+				return
+			}
+
+			countPortions(portions, append([]models.Food{food}, componentFoods...)...)
+			orderItems = append(orderItems, orderItem)
+		}
+
+		if err := reservePortions(ctx, portions); err != nil {
+			c.Error(err)
+			return
+		}
 
+		orderId, err := OrderItemOrderCreator(ctx, order)
+		if err != nil {
+			releasePortions(ctx, portions)
+			c.Error(apperrors.Internal(err, "Order creation failed"))
+			return
+		}
+
+		orderItemsToBeInserted := []interface{}{}
+		for i := range orderItems {
+			orderItem := &orderItems[i]
+			orderItem.Order_id = orderId
 			orderItem.ID = primitive.NewObjectID()
+			orderItem.Version = 1
 			orderItem.Created_at = time.Now()
 			orderItem.Updated_at = time.Now()
 			orderItem.Order_item_id = orderItem.ID.Hex()
 
-			num := toFixed(*orderItem.Unit_price, 2)
-			orderItem.Unit_price = &num
-
-			orderItemsToBeInserted = append(orderItemsToBeInserted, orderItem)
+			orderItemsToBeInserted = append(orderItemsToBeInserted, *orderItem)
 		}
 
 		insertedOrderItems, err := orderItemCollection.InsertMany(ctx, orderItemsToBeInserted)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert order items"})
+			releasePortions(ctx, portions)
+			c.Error(apperrors.Internal(err, "Failed to insert order items"))
 			return
 		}
 
+		moveStockForOrderItems(ctx, c.GetString("uid"), orderItems, models.StockSale)
+
 		c.JSON(http.StatusOK, insertedOrderItems)
 	}
 }
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"restorent-management/apperrors"
+	"restorent-management/helper"
+
+	"github.com/gin-gonic/gin"
+)
+
+const mergePatchContentType = "application/merge-patch+json"
+
+// applyMergePatch reads the request body as a JSON Merge Patch (RFC 7396)
+// and applies it to doc, which must point at the stored document. Members
+// the patch sets to null are cleared on doc so that validation of the
+// merged result sees them as missing.
+func applyMergePatch(c *gin.Context, doc interface{}) error {
+	contentType := c.ContentType()
+	if contentType != mergePatchContentType && contentType != gin.MIMEJSON && contentType != "" {
+		return apperrors.UnsupportedMediaType("patch body must be application/merge-patch+json or application/json")
+	}
+
+	patch, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return apperrors.BadRequest(err.Error())
+	}
+
+	original, err := json.Marshal(doc)
+	if err != nil {
+		return apperrors.Internal(err, "")
+	}
+
+	merged, err := helper.MergePatch(original, patch)
+	if err != nil {
+		return apperrors.BadRequest("patch body is not valid JSON: " + err.Error())
+	}
+
+	value := reflect.ValueOf(doc).Elem()
+	value.Set(reflect.Zero(value.Type()))
+	if err := json.Unmarshal(merged, doc); err != nil {
+		return apperrors.BadRequest(err.Error())
+	}
+	return nil
+}
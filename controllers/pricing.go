@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"context"
+	"restorent-management/apperrors"
+	"restorent-management/helper"
+	"restorent-management/models"
+	"restorent-management/promotions"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// pricer applies the promotions running at one moment, normally when the
+// order was placed, to the order's items.
+type pricer struct {
+	at         time.Time
+	promotions []models.Promotion
+	menus      map[string]models.Menu
+}
+
+// newPricer loads the promotions whose date range includes at. Their
+// schedules are checked by the promotions package.
+func newPricer(ctx context.Context, at time.Time) (*pricer, error) {
+	filter := notDeleted(bson.M{
+		"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"start_date": nil}, bson.M{"start_date": bson.M{"$lte": at}}}},
+			bson.M{"$or": bson.A{bson.M{"end_date": nil}, bson.M{"end_date": bson.M{"$gt": at}}}},
+		},
+	})
+	cursor, err := promotionCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, apperrors.Internal(err, "error occured while fetching the promotions")
+	}
+	p := &pricer{at: at.In(helper.Location), menus: map[string]models.Menu{}}
+	if err := cursor.All(ctx, &p.promotions); err != nil {
+		return nil, apperrors.Internal(err, "error occured while decoding the promotions")
+	}
+	return p, nil
+}
+
+// line describes an item of the food at price to the promotion rules.
+func (p *pricer) line(ctx context.Context, food models.Food, price float64) (promotions.Line, error) {
+	menu, ok := p.menus[*food.Menu_id]
+	if !ok && len(p.promotions) > 0 {
+		err := menuCollection.FindOne(ctx, bson.M{"menu_id": *food.Menu_id}).Decode(&menu)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return promotions.Line{}, apperrors.Internal(err, "error occured while fetching the menu")
+		}
+		p.menus[*food.Menu_id] = menu
+	}
+
+	return promotions.Line{
+		Food_id:    food.Food_id,
+		Menu_id:    *food.Menu_id,
+		Categories: menu.CategoriesOf(food),
+		Price:      price,
+	}, nil
+}
+
+// discount applies the item promotions to an order item costing catalog
+// and returns the price left to charge.
+func (p *pricer) discount(ctx context.Context, food models.Food, orderItem *models.OrderItem, catalog float64) (float64, error) {
+	orderItem.Discounts = nil
+	if len(p.promotions) == 0 {
+		return catalog, nil
+	}
+
+	line, err := p.line(ctx, food, catalog)
+	if err != nil {
+		return 0, err
+	}
+	orderItem.Discounts = promotions.ItemDiscounts(p.promotions, line, p.at)
+	return toFixed(catalog-orderItem.DiscountsTotal(), 2), nil
+}
+
+// orderDiscounts works out the order wide promotions of an order from its
+// items as they were charged.
+func orderDiscounts(ctx context.Context, order models.Order, orderItems []models.OrderItem) ([]models.AppliedDiscount, error) {
+	p, err := newPricer(ctx, order.Order_Date)
+	if err != nil || len(p.promotions) == 0 {
+		return nil, err
+	}
+
+	foods, err := foodsFor(ctx, orderItems)
+	if err != nil {
+		return nil, apperrors.Internal(err, "error occured while fetching the foods")
+	}
+
+	lines := make([]promotions.Line, 0, len(orderItems))
+	for _, orderItem := range orderItems {
+		// Items charged at a price set by hand are left as they are
+		food, ok := foods[*orderItem.Food_id]
+		if !ok || orderItem.Price_override != nil {
+			continue
+		}
+		price := *food.Price
+		if orderItem.Unit_price != nil {
+			price = *orderItem.Unit_price
+		}
+
+		line, err := p.line(ctx, food, price)
+		if err != nil {
+			return nil, err
+		}
+		line.Discounts = orderItem.Discounts
+		lines = append(lines, line)
+	}
+	return promotions.OrderDiscounts(p.promotions, lines, p.at), nil
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"restorent-management/apperrors"
+	"restorent-management/database"
+	"restorent-management/models"
+	"restorent-management/query"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var promotionCollection *mongo.Collection = database.OpenCollection(database.Client, "promotion")
+
+// validatePromotion checks what the struct tags cannot: the promotion's
+// date range and schedules.
+func validatePromotion(promotion models.Promotion) error {
+	return validateWindows(promotion.Start_Date, promotion.End_Date, promotion.Schedules)
+}
+
+func CreatePromotion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var promotion models.Promotion
+		if err := c.ShouldBindJSON(&promotion); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+
+		if err := validate.Struct(promotion); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+		if err := validatePromotion(promotion); err != nil {
+			c.Error(err)
+			return
+		}
+
+		now := time.Now()
+		promotion.Created_at = now
+		promotion.Updated_at = now
+		promotion.ID = primitive.NewObjectID()
+		promotion.Version = 1
+		promotion.Promotion_id = promotion.ID.Hex()
+
+		result, err := promotionCollection.InsertOne(ctx, promotion)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "promotion was not created"))
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+var promotionListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "name", Field: "name", Kind: query.String},
+		{Param: "type", Field: "type", Kind: query.String},
+		{Param: "food_id", Field: "food_ids", Kind: query.String},
+		{Param: "menu_id", Field: "menu_ids", Kind: query.String},
+		{Param: "stackable", Field: "stackable", Kind: query.Bool},
+		{Param: "start_date", Field: "start_date", Kind: query.Date},
+		{Param: "end_date", Field: "end_date", Kind: query.Date},
+	},
+	Sortable:    []string{"name", "priority", "start_date", "end_date", "created_at"},
+	DefaultSort: "-priority",
+}
+
+func GetPromotions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, promotionListSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		notDeleted(list.Filter)
+
+		page, err := query.Find[models.Promotion](ctx, promotionCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing promotions"))
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func GetPromotionByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var promotion models.Promotion
+		err := promotionCollection.FindOne(ctx, notDeleted(bson.M{"promotion_id": c.Param("promotion_id")})).Decode(&promotion)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Promotion not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the promotion"))
+			}
+			return
+		}
+		if notModified(c, promotion.Version) {
+			return
+		}
+
+		c.JSON(http.StatusOK, promotion)
+	}
+}
+
+func UpdatePromotion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var promotion models.Promotion
+		filter := notDeleted(bson.M{"promotion_id": c.Param("promotion_id")})
+
+		err := promotionCollection.FindOne(ctx, filter).Decode(&promotion)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Promotion not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the promotion"))
+			}
+			return
+		}
+		original := promotion
+
+		if err := checkIfMatch(c, promotion.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		if err := applyMergePatch(c, &promotion); err != nil {
+			c.Error(err)
+			return
+		}
+
+		promotion.ID = original.ID
+		promotion.Promotion_id = original.Promotion_id
+		promotion.Created_at = original.Created_at
+		promotion.Version = original.Version + 1
+		promotion.Deleted_at = original.Deleted_at
+		promotion.Deleted_by = original.Deleted_by
+
+		if err := validate.Struct(promotion); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+		if err := validatePromotion(promotion); err != nil {
+			c.Error(err)
+			return
+		}
+
+		promotion.Updated_at = time.Now()
+
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = promotionCollection.FindOneAndReplace(ctx, filter, promotion, opts).Decode(&promotion)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Promotion was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "Promotion update failed"))
+			}
+			return
+		}
+
+		c.Header("ETag", etag(promotion.Version))
+		c.JSON(http.StatusOK, promotion)
+	}
+}
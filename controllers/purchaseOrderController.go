@@ -0,0 +1,377 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"restorent-management/apperrors"
+	"restorent-management/database"
+	"restorent-management/models"
+	"restorent-management/query"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var purchaseOrderCollection *mongo.Collection = database.OpenCollection(database.Client, "purchaseOrder")
+
+// checkPurchaseOrder makes sure the supplier and every ordered ingredient
+// exist, and works out the order's total cost.
+func checkPurchaseOrder(ctx context.Context, purchaseOrder *models.PurchaseOrder) error {
+	if err := checkSupplier(ctx, purchaseOrder.Supplier_id); err != nil {
+		return err
+	}
+
+	ids := bson.A{}
+	total := 0.0
+	for _, line := range purchaseOrder.Lines {
+		ids = append(ids, line.Ingredient_id)
+		total += line.Quantity * line.Unit_cost
+	}
+	count, err := ingredientCollection.CountDocuments(ctx, notDeleted(bson.M{"ingredient_id": bson.M{"$in": ids}}))
+	if err != nil {
+		return apperrors.Internal(err, "error occured while fetching the ingredients")
+	}
+	if count != int64(len(ids)) {
+		return apperrors.BadRequest("one or more ingredients were not found")
+	}
+
+	purchaseOrder.Total_cost = toFixed(total, 2)
+	return nil
+}
+
+func CreatePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var purchaseOrder models.PurchaseOrder
+		if err := c.ShouldBindJSON(&purchaseOrder); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+
+		if err := validate.Struct(purchaseOrder); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		purchaseOrder.Status = models.PurchaseOrderOpen
+		purchaseOrder.Receipts = []models.Receipt{}
+		for i := range purchaseOrder.Lines {
+			purchaseOrder.Lines[i].Received_quantity = 0
+		}
+
+		if err := checkPurchaseOrder(ctx, &purchaseOrder); err != nil {
+			c.Error(err)
+			return
+		}
+
+		now := time.Now()
+		purchaseOrder.Created_at = now
+		purchaseOrder.Updated_at = now
+		purchaseOrder.ID = primitive.NewObjectID()
+		purchaseOrder.Version = 1
+		purchaseOrder.Purchase_order_id = purchaseOrder.ID.Hex()
+
+		result, err := purchaseOrderCollection.InsertOne(ctx, purchaseOrder)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "purchase order was not created"))
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+var purchaseOrderListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "supplier_id", Field: "supplier_id", Kind: query.String},
+		{Param: "status", Field: "status", Kind: query.String},
+		{Param: "ingredient_id", Field: "lines.ingredient_id", Kind: query.String},
+		{Param: "expected_delivery_date", Field: "expected_delivery_date", Kind: query.Date},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"expected_delivery_date", "created_at", "total_cost"},
+	DefaultSort: "expected_delivery_date",
+}
+
+func GetPurchaseOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, purchaseOrderListSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		notDeleted(list.Filter)
+
+		page, err := query.Find[models.PurchaseOrder](ctx, purchaseOrderCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing purchase orders"))
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func findPurchaseOrder(ctx context.Context, filter bson.M) (models.PurchaseOrder, error) {
+	var purchaseOrder models.PurchaseOrder
+	err := purchaseOrderCollection.FindOne(ctx, filter).Decode(&purchaseOrder)
+	if err == mongo.ErrNoDocuments {
+		return purchaseOrder, apperrors.NotFound("Purchase order not found")
+	} else if err != nil {
+		return purchaseOrder, apperrors.Internal(err, "error occured while fetching the purchase order")
+	}
+	return purchaseOrder, nil
+}
+
+func GetPurchaseOrderByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		purchaseOrder, err := findPurchaseOrder(ctx, notDeleted(bson.M{"purchase_order_id": c.Param("purchase_order_id")}))
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if notModified(c, purchaseOrder.Version) {
+			return
+		}
+
+		c.JSON(http.StatusOK, purchaseOrder)
+	}
+}
+
+// UpdatePurchaseOrder changes an order that has not been delivered against
+// yet. Once stock has been received the order can only be cancelled.
+func UpdatePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		filter := notDeleted(bson.M{"purchase_order_id": c.Param("purchase_order_id")})
+		purchaseOrder, err := findPurchaseOrder(ctx, filter)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		original := purchaseOrder
+
+		if err := checkIfMatch(c, purchaseOrder.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		if original.Status != models.PurchaseOrderOpen {
+			c.Error(apperrors.Conflict("only open purchase orders can be changed, this one is " + original.Status))
+			return
+		}
+
+		if err := applyMergePatch(c, &purchaseOrder); err != nil {
+			c.Error(err)
+			return
+		}
+
+		purchaseOrder.ID = original.ID
+		purchaseOrder.Purchase_order_id = original.Purchase_order_id
+		purchaseOrder.Status = original.Status
+		purchaseOrder.Receipts = original.Receipts
+		purchaseOrder.Created_at = original.Created_at
+		purchaseOrder.Version = original.Version + 1
+		purchaseOrder.Deleted_at = original.Deleted_at
+		purchaseOrder.Deleted_by = original.Deleted_by
+		for i := range purchaseOrder.Lines {
+			purchaseOrder.Lines[i].Received_quantity = 0
+		}
+
+		if err := validate.Struct(purchaseOrder); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		if err := checkPurchaseOrder(ctx, &purchaseOrder); err != nil {
+			c.Error(err)
+			return
+		}
+
+		purchaseOrder.Updated_at = time.Now()
+
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = purchaseOrderCollection.FindOneAndReplace(ctx, filter, purchaseOrder, opts).Decode(&purchaseOrder)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Purchase order was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "Purchase order update failed"))
+			}
+			return
+		}
+
+		c.Header("ETag", etag(purchaseOrder.Version))
+		c.JSON(http.StatusOK, purchaseOrder)
+	}
+}
+
+type ReceiveRequest struct {
+	Lines []ReceiveLine `json:"lines" validate:"required,min=1,unique=Ingredient_id,dive"`
+}
+
+// ReceiveLine is a delivered quantity of one ingredient. Unit_cost
+// defaults to the cost on the purchase order.
+type ReceiveLine struct {
+	Ingredient_id string   `json:"ingredient_id" validate:"required"`
+	Quantity      float64  `json:"quantity" validate:"gt=0"`
+	Unit_cost     *float64 `json:"unit_cost" validate:"omitempty,min=0"`
+}
+
+// ReceivePurchaseOrder handles POST /purchaseOrders/:purchase_order_id/receive
+// for a full or partial delivery. The receipt is booked on the order first,
+// against the version that was read, so the same delivery cannot be
+// received twice; the stock of each ingredient is then increased at the
+// delivered cost.
+func ReceivePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var request ReceiveRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		filter := notDeleted(bson.M{"purchase_order_id": c.Param("purchase_order_id")})
+		purchaseOrder, err := findPurchaseOrder(ctx, filter)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		if err := checkIfMatch(c, purchaseOrder.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		if purchaseOrder.Status != models.PurchaseOrderOpen && purchaseOrder.Status != models.PurchaseOrderPartiallyReceived {
+			c.Error(apperrors.Conflict("stock cannot be received on a purchase order that is " + purchaseOrder.Status))
+			return
+		}
+
+		receipt := models.Receipt{Received_at: time.Now(), Received_by: c.GetString("uid")}
+		for _, received := range request.Lines {
+			index := -1
+			for i, line := range purchaseOrder.Lines {
+				if line.Ingredient_id == received.Ingredient_id {
+					index = i
+				}
+			}
+			if index < 0 {
+				c.Error(apperrors.Unprocessable("ingredient " + received.Ingredient_id + " is not on this purchase order"))
+				return
+			}
+
+			line := &purchaseOrder.Lines[index]
+			if received.Quantity > line.Outstanding() {
+				c.Error(apperrors.Unprocessable(fmt.Sprintf("only %g of ingredient %s is still to be delivered", line.Outstanding(), line.Ingredient_id)))
+				return
+			}
+
+			unitCost := line.Unit_cost
+			if received.Unit_cost != nil {
+				unitCost = toFixed(*received.Unit_cost, 4)
+			}
+			line.Received_quantity += received.Quantity
+			receipt.Lines = append(receipt.Lines, models.ReceiptLine{
+				Ingredient_id: received.Ingredient_id,
+				Quantity:      received.Quantity,
+				Unit_cost:     unitCost,
+			})
+		}
+
+		status := models.PurchaseOrderReceived
+		for _, line := range purchaseOrder.Lines {
+			if line.Outstanding() > 0 {
+				status = models.PurchaseOrderPartiallyReceived
+			}
+		}
+
+		filter["version"] = purchaseOrder.Version
+		update := bson.M{
+			"$set":  bson.M{"lines": purchaseOrder.Lines, "status": status, "updated_at": receipt.Received_at},
+			"$push": bson.M{"receipts": receipt},
+			"$inc":  bson.M{"version": 1},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = purchaseOrderCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&purchaseOrder)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Purchase order was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while booking the receipt"))
+			}
+			return
+		}
+
+		for _, line := range receipt.Lines {
+			if err := receiveStock(ctx, receipt.Received_by, purchaseOrder.Purchase_order_id, line); err != nil {
+				c.Error(err)
+				return
+			}
+		}
+
+		c.Header("ETag", etag(purchaseOrder.Version))
+		c.JSON(http.StatusOK, purchaseOrder)
+	}
+}
+
+// CancelPurchaseOrder closes an order that will not be delivered in full.
+// Stock already received is kept.
+func CancelPurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		filter := notDeleted(bson.M{
+			"purchase_order_id": c.Param("purchase_order_id"),
+			"status":            bson.M{"$in": bson.A{models.PurchaseOrderOpen, models.PurchaseOrderPartiallyReceived}},
+		})
+		update := bson.M{
+			"$set": bson.M{"status": models.PurchaseOrderCancelled, "updated_at": time.Now()},
+			"$inc": bson.M{"version": 1},
+		}
+
+		var purchaseOrder models.PurchaseOrder
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err := purchaseOrderCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&purchaseOrder)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				if _, err := findPurchaseOrder(ctx, notDeleted(bson.M{"purchase_order_id": c.Param("purchase_order_id")})); err != nil {
+					c.Error(err)
+				} else {
+					c.Error(apperrors.Conflict("only open or partially received purchase orders can be cancelled"))
+				}
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while cancelling the purchase order"))
+			}
+			return
+		}
+
+		c.Header("ETag", etag(purchaseOrder.Version))
+		c.JSON(http.StatusOK, purchaseOrder)
+	}
+}
@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"restorent-management/apperrors"
+	"restorent-management/database"
+	"restorent-management/models"
+	"restorent-management/query"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var recipeCollection *mongo.Collection = database.OpenCollection(database.Client, "recipe")
+
+// checkRecipe makes sure the recipe's food and ingredients exist and that
+// the food has no other recipe for the same size.
+func checkRecipe(ctx context.Context, recipe models.Recipe) error {
+	count, err := foodCollection.CountDocuments(ctx, notDeleted(bson.M{"food_id": *recipe.Food_id}))
+	if err != nil {
+		return apperrors.Internal(err, "error occured while fetching the food")
+	}
+	if count == 0 {
+		return apperrors.BadRequest("Food was not found")
+	}
+
+	ids := bson.A{}
+	for _, line := range recipe.Ingredients {
+		ids = append(ids, line.Ingredient_id)
+	}
+	count, err = ingredientCollection.CountDocuments(ctx, notDeleted(bson.M{"ingredient_id": bson.M{"$in": ids}}))
+	if err != nil {
+		return apperrors.Internal(err, "error occured while fetching the ingredients")
+	}
+	if count != int64(len(ids)) {
+		return apperrors.BadRequest("one or more ingredients were not found")
+	}
+
+	duplicate := notDeleted(bson.M{"food_id": *recipe.Food_id, "size": recipe.Size, "recipe_id": bson.M{"$ne": recipe.Recipe_id}})
+	count, err = recipeCollection.CountDocuments(ctx, duplicate)
+	if err != nil {
+		return apperrors.Internal(err, "error occured while fetching the recipes")
+	}
+	if count > 0 {
+		return apperrors.Conflict("the food already has a recipe for this size")
+	}
+	return nil
+}
+
+func CreateRecipe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var recipe models.Recipe
+		if err := c.ShouldBindJSON(&recipe); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+
+		if err := validate.Struct(recipe); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		if err := checkRecipe(ctx, recipe); err != nil {
+			c.Error(err)
+			return
+		}
+
+		now := time.Now()
+		recipe.Created_at = now
+		recipe.Updated_at = now
+		recipe.ID = primitive.NewObjectID()
+		recipe.Version = 1
+		recipe.Recipe_id = recipe.ID.Hex()
+
+		result, err := recipeCollection.InsertOne(ctx, recipe)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "recipe was not created"))
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+var recipeListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "food_id", Field: "food_id", Kind: query.String},
+		{Param: "size", Field: "size", Kind: query.String},
+		{Param: "ingredient_id", Field: "ingredients.ingredient_id", Kind: query.String},
+	},
+	Sortable:    []string{"created_at", "food_id"},
+	DefaultSort: "-created_at",
+}
+
+func GetRecipes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, recipeListSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		notDeleted(list.Filter)
+
+		page, err := query.Find[models.Recipe](ctx, recipeCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing recipes"))
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func GetRecipeByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var recipe models.Recipe
+		err := recipeCollection.FindOne(ctx, notDeleted(bson.M{"recipe_id": c.Param("recipe_id")})).Decode(&recipe)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Recipe not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the recipe"))
+			}
+			return
+		}
+		if notModified(c, recipe.Version) {
+			return
+		}
+
+		c.JSON(http.StatusOK, recipe)
+	}
+}
+
+func UpdateRecipe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var recipe models.Recipe
+		filter := notDeleted(bson.M{"recipe_id": c.Param("recipe_id")})
+
+		err := recipeCollection.FindOne(ctx, filter).Decode(&recipe)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Recipe not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the recipe"))
+			}
+			return
+		}
+		original := recipe
+
+		if err := checkIfMatch(c, recipe.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		if err := applyMergePatch(c, &recipe); err != nil {
+			c.Error(err)
+			return
+		}
+
+		recipe.ID = original.ID
+		recipe.Recipe_id = original.Recipe_id
+		recipe.Created_at = original.Created_at
+		recipe.Version = original.Version + 1
+		recipe.Deleted_at = original.Deleted_at
+		recipe.Deleted_by = original.Deleted_by
+
+		if err := validate.Struct(recipe); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		if err := checkRecipe(ctx, recipe); err != nil {
+			c.Error(err)
+			return
+		}
+
+		recipe.Updated_at = time.Now()
+
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = recipeCollection.FindOneAndReplace(ctx, filter, recipe, opts).Decode(&recipe)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Recipe was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "Recipe update failed"))
+			}
+			return
+		}
+
+		c.Header("ETag", etag(recipe.Version))
+		c.JSON(http.StatusOK, recipe)
+	}
+}
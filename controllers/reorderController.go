@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"restorent-management/apperrors"
+	"restorent-management/models"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	defaultUsageDays = 14
+	maxUsageDays     = 90
+)
+
+// ReorderSuggestion is how much of an ingredient to order so that stock is
+// back at its par level by the time the supplier delivers.
+type ReorderSuggestion struct {
+	Ingredient_id      string  `json:"ingredient_id"`
+	Name               string  `json:"name"`
+	Unit               string  `json:"unit"`
+	Supplier_id        *string `json:"supplier_id"`
+	Stock              float64 `json:"stock"`
+	Par_level          float64 `json:"par_level"`
+	On_order           float64 `json:"on_order"`
+	Daily_usage        float64 `json:"daily_usage"`
+	Lead_time_days     int     `json:"lead_time_days"`
+	Suggested_quantity float64 `json:"suggested_quantity"`
+}
+
+// GetReorderSuggestions handles GET /ingredients/reorder-suggestions. Daily
+// usage is worked out from the order items sold over the last `days` days
+// (14 by default) and their recipes. The suggestion covers the par level
+// plus the usage expected during the supplier's lead time, less the stock
+// on hand and what is already on order. Only ingredients with a par level
+// that need ordering are listed, grouped by supplier.
+func GetReorderSuggestions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		days := defaultUsageDays
+		if raw := c.Query("days"); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil || parsed < 1 || parsed > maxUsageDays {
+				c.Error(apperrors.BadRequest("days must be between 1 and " + strconv.Itoa(maxUsageDays)))
+				return
+			}
+			days = parsed
+		}
+
+		cursor, err := ingredientCollection.Find(ctx, notDeleted(bson.M{"par_level": bson.M{"$gt": 0}}))
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing ingredients"))
+			return
+		}
+		var ingredients []models.Ingredient
+		if err := cursor.All(ctx, &ingredients); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding ingredients"))
+			return
+		}
+
+		usage, err := ingredientUsage(ctx, time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while working out ingredient usage"))
+			return
+		}
+
+		onOrder, err := ingredientsOnOrder(ctx)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing purchase orders"))
+			return
+		}
+
+		leadTimes, err := supplierLeadTimes(ctx)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing suppliers"))
+			return
+		}
+
+		suggestions := []ReorderSuggestion{}
+		for _, ingredient := range ingredients {
+			suggestion := ReorderSuggestion{
+				Ingredient_id: ingredient.Ingredient_id,
+				Name:          *ingredient.Name,
+				Unit:          *ingredient.Unit,
+				Supplier_id:   ingredient.Supplier_id,
+				Stock:         ingredient.Stock,
+				Par_level:     ingredient.Par_level,
+				On_order:      onOrder[ingredient.Ingredient_id],
+				Daily_usage:   toFixed(usage[ingredient.Ingredient_id]/float64(days), 3),
+			}
+			if ingredient.Supplier_id != nil {
+				suggestion.Lead_time_days = leadTimes[*ingredient.Supplier_id]
+			}
+
+			needed := suggestion.Par_level + suggestion.Daily_usage*float64(suggestion.Lead_time_days) -
+				suggestion.Stock - suggestion.On_order
+			if needed <= 0 {
+				continue
+			}
+			if suggestion.Unit == "pcs" {
+				needed = math.Ceil(needed)
+			}
+			suggestion.Suggested_quantity = toFixed(needed, 2)
+			suggestions = append(suggestions, suggestion)
+		}
+
+		sort.SliceStable(suggestions, func(i, j int) bool {
+			a, b := supplierKey(suggestions[i]), supplierKey(suggestions[j])
+			if a != b {
+				return a < b
+			}
+			return suggestions[i].Name < suggestions[j].Name
+		})
+
+		c.JSON(http.StatusOK, suggestions)
+	}
+}
+
+func supplierKey(suggestion ReorderSuggestion) string {
+	if suggestion.Supplier_id == nil {
+		return ""
+	}
+	return *suggestion.Supplier_id
+}
+
+// ingredientUsage adds up the recipe ingredients of every order item sold
+// since the given time that has not been voided. Bundles use the recipes
+// of their components.
+func ingredientUsage(ctx context.Context, since time.Time) (map[string]float64, error) {
+	pipeline := bson.A{bson.M{"$match": bson.M{"created_at": bson.M{"$gte": since}, "deleted_at": nil}}}
+	pipeline = append(pipeline, soldFoodStages...)
+	pipeline = append(pipeline, bson.M{"$group": bson.M{
+		"_id":   bson.M{"food_id": "$food_id", "size": "$size"},
+		"count": bson.M{"$sum": 1},
+	}})
+	cursor, err := orderItemCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var sold []struct {
+		ID struct {
+			Food_id string `bson:"food_id"`
+			Size    string `bson:"size"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	if err := cursor.All(ctx, &sold); err != nil {
+		return nil, err
+	}
+
+	usage := map[string]float64{}
+	for _, group := range sold {
+		recipe, ok, err := recipeFor(ctx, group.ID.Food_id, group.ID.Size)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		for _, line := range recipe.Ingredients {
+			usage[line.Ingredient_id] += line.Quantity * float64(group.Count)
+		}
+	}
+	return usage, nil
+}
+
+// ingredientsOnOrder is the quantity of each ingredient still to be
+// delivered on open and partially received purchase orders.
+func ingredientsOnOrder(ctx context.Context) (map[string]float64, error) {
+	filter := notDeleted(bson.M{"status": bson.M{"$in": bson.A{models.PurchaseOrderOpen, models.PurchaseOrderPartiallyReceived}}})
+	cursor, err := purchaseOrderCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var purchaseOrders []models.PurchaseOrder
+	if err := cursor.All(ctx, &purchaseOrders); err != nil {
+		return nil, err
+	}
+
+	onOrder := map[string]float64{}
+	for _, purchaseOrder := range purchaseOrders {
+		for _, line := range purchaseOrder.Lines {
+			onOrder[line.Ingredient_id] += line.Outstanding()
+		}
+	}
+	return onOrder, nil
+}
+
+func supplierLeadTimes(ctx context.Context) (map[string]int, error) {
+	cursor, err := supplierCollection.Find(ctx, notDeleted(bson.M{}))
+	if err != nil {
+		return nil, err
+	}
+	var suppliers []models.Supplier
+	if err := cursor.All(ctx, &suppliers); err != nil {
+		return nil, err
+	}
+
+	leadTimes := make(map[string]int, len(suppliers))
+	for _, supplier := range suppliers {
+		leadTimes[supplier.Supplier_id] = supplier.Lead_time_days
+	}
+	return leadTimes, nil
+}
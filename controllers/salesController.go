@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"restorent-management/apperrors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// soldFoodStages turns order items into one document per food served,
+// with its food_id, size, revenue and whether it was part of a bundle. A
+// bundle counts as its components, each at its allocated share of the
+// bundle's price.
+var soldFoodStages = bson.A{
+	bson.M{"$project": bson.M{"sold": bson.M{"$cond": bson.A{
+		bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$components", bson.A{}}}}, 0}},
+		bson.M{"$map": bson.M{"input": "$components", "as": "component", "in": bson.M{
+			"food_id":   "$$component.food_id",
+			"size":      "$$component.size",
+			"revenue":   "$$component.allocated_price",
+			"in_bundle": true,
+		}}},
+		bson.A{bson.M{
+			"food_id":   "$food_id",
+			"size":      "$quantity",
+			"revenue":   bson.M{"$ifNull": bson.A{"$unit_price", 0}},
+			"in_bundle": false,
+		}},
+	}}}},
+	bson.M{"$unwind": "$sold"},
+	bson.M{"$replaceRoot": bson.M{"newRoot": "$sold"}},
+}
+
+// reportPeriod reads the from and to query parameters of a report, RFC 3339
+// times that default to the last 30 days.
+func reportPeriod(c *gin.Context) (time.Time, time.Time, error) {
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	for param, value := range map[string]*time.Time{"from": &from, "to": &to} {
+		if raw := c.Query(param); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return from, to, apperrors.BadRequest(param + " must be an RFC 3339 timestamp")
+			}
+			*value = parsed
+		}
+	}
+	if !to.After(from) {
+		return from, to, apperrors.BadRequest("to must be after from")
+	}
+	return from, to, nil
+}
+
+// FoodSales is how much of one food was sold over a period.
+type FoodSales struct {
+	Food_id    string  `json:"food_id" bson:"_id"`
+	Food_name  string  `json:"food_name" bson:"food_name"`
+	Quantity   int     `json:"quantity" bson:"quantity"`
+	In_bundles int     `json:"in_bundles" bson:"in_bundles"`
+	Revenue    float64 `json:"revenue" bson:"revenue"`
+}
+
+// GetFoodSales handles GET /orderItems/sales?from=&to=, the foods sold
+// between two RFC 3339 times (the last 30 days by default), highest revenue
+// first. Foods sold in a bundle are counted with the revenue allocated to
+// them, so bundles themselves do not appear.
+func GetFoodSales() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		from, to, err := reportPeriod(c)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		pipeline := bson.A{bson.M{"$match": bson.M{"created_at": bson.M{"$gte": from, "$lt": to}, "deleted_at": nil}}}
+		pipeline = append(pipeline, soldFoodStages...)
+		pipeline = append(pipeline,
+			bson.M{"$group": bson.M{
+				"_id":        "$food_id",
+				"quantity":   bson.M{"$sum": 1},
+				"in_bundles": bson.M{"$sum": bson.M{"$cond": bson.A{"$in_bundle", 1, 0}}},
+				"revenue":    bson.M{"$sum": "$revenue"},
+			}},
+			bson.M{"$lookup": bson.M{"from": "food", "localField": "_id", "foreignField": "food_id", "as": "food"}},
+			bson.M{"$set": bson.M{"food_name": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$food.name", 0}}, ""}}}},
+			bson.M{"$sort": bson.D{{Key: "revenue", Value: -1}, {Key: "_id", Value: 1}}},
+		)
+
+		cursor, err := orderItemCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while adding up the sales"))
+			return
+		}
+		sales := []FoodSales{}
+		if err := cursor.All(ctx, &sales); err != nil {
+			c.Error(apperrors.Internal(err, "error occured while decoding the sales"))
+			return
+		}
+		for i := range sales {
+			sales[i].Revenue = toFixed(sales[i].Revenue, 2)
+		}
+
+		c.JSON(http.StatusOK, sales)
+	}
+}
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"restorent-management/apperrors"
+	"restorent-management/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// notDeleted restricts a filter to documents that have not been soft
+// deleted. A missing deleted_at and an explicit null both match.
+func notDeleted(filter bson.M) bson.M {
+	filter["deleted_at"] = nil
+	return filter
+}
+
+// softDelete marks the document whose idField equals the idField route
+// parameter as deleted, against the version named by If-Match.
+func softDelete(collection *mongo.Collection, idField string, label string) gin.HandlerFunc {
+	return softDeleteUnless(collection, idField, label, nil, "")
+}
+
+// softDeleteUnless is softDelete for documents that must be kept while
+// they match blocked, refusing those with a conflict giving reason. The
+// delete is made against the version that was checked, so a change that
+// blocks it in between makes it fail.
+func softDeleteUnless(collection *mongo.Collection, idField string, label string, blocked bson.M, reason string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		filter := notDeleted(bson.M{idField: c.Param(idField)})
+
+		var current struct {
+			Version int64 `bson:"version"`
+		}
+		err := collection.FindOne(ctx, filter).Decode(&current)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound(label + " not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "Error occurred while fetching the "+strings.ToLower(label)))
+			}
+			return
+		}
+
+		if err := checkIfMatch(c, current.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		now := time.Now()
+		filter["version"] = current.Version
+		if blocked != nil {
+			count, err := collection.CountDocuments(ctx, bson.M{"$and": bson.A{filter, blocked}})
+			if err != nil {
+				c.Error(apperrors.Internal(err, "Error occurred while fetching the "+strings.ToLower(label)))
+				return
+			}
+			if count > 0 {
+				c.Error(apperrors.Conflict(reason))
+				return
+			}
+		}
+		update := bson.M{
+			"$set": bson.M{"deleted_at": now, "deleted_by": c.GetString("uid"), "updated_at": now},
+			"$inc": bson.M{"version": 1},
+		}
+
+		result, err := collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.Error(apperrors.Internal(err, label+" delete failed"))
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.Error(apperrors.PreconditionFailed(label + " was modified by another request"))
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// restoreDeleted clears the soft delete markers on a document and returns it.
+func restoreDeleted(collection *mongo.Collection, idField string, label string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		filter := bson.M{idField: c.Param(idField), "deleted_at": bson.M{"$ne": nil}}
+		update := bson.M{
+			"$set": bson.M{"deleted_at": nil, "deleted_by": nil, "updated_at": time.Now()},
+			"$inc": bson.M{"version": 1},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var restored bson.M
+		err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&restored)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Deleted " + label + " not found"))
+			} else if mongo.IsDuplicateKeyError(err) {
+				c.Error(apperrors.Conflict(label + " has a value another one was given since it was deleted"))
+			} else {
+				c.Error(apperrors.Internal(err, label+" restore failed"))
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, restored)
+	}
+}
+
+func DeleteFood() gin.HandlerFunc {
+	return softDelete(foodCollection, "food_id", "Food")
+}
+
+func RestoreFood() gin.HandlerFunc {
+	return restoreDeleted(foodCollection, "food_id", "Food")
+}
+
+func DeleteMenu() gin.HandlerFunc {
+	return softDelete(menuCollection, "menu_id", "Menu")
+}
+
+func RestoreMenu() gin.HandlerFunc {
+	return restoreDeleted(menuCollection, "menu_id", "Menu")
+}
+
+func DeleteTable() gin.HandlerFunc {
+	return softDelete(tableCollection, "table_id", "Table")
+}
+
+func RestoreTable() gin.HandlerFunc {
+	return restoreDeleted(tableCollection, "table_id", "Table")
+}
+
+func DeleteOrder() gin.HandlerFunc {
+	return softDelete(orderCollection, "order_id", "Order")
+}
+
+func RestoreOrder() gin.HandlerFunc {
+	return restoreDeleted(orderCollection, "order_id", "Order")
+}
+
+// DeleteOrderItem voids an order item, putting its recipe ingredients and
+// any limited portion back.
+func DeleteOrderItem() gin.HandlerFunc {
+	remove := softDelete(orderItemCollection, "order_item_id", "Order item")
+	return func(c *gin.Context) {
+		remove(c)
+		if len(c.Errors) == 0 {
+			afterOrderItemChange(c, models.StockVoid)
+		}
+	}
+}
+
+// RestoreOrderItem undoes a void, taking the ingredients out of stock again.
+func RestoreOrderItem() gin.HandlerFunc {
+	restore := restoreDeleted(orderItemCollection, "order_item_id", "Order item")
+	return func(c *gin.Context) {
+		restore(c)
+		if len(c.Errors) == 0 {
+			afterOrderItemChange(c, models.StockSale)
+		}
+	}
+}
+
+func afterOrderItemChange(c *gin.Context, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var orderItem models.OrderItem
+	if err := orderItemCollection.FindOne(ctx, bson.M{"order_item_id": c.Param("order_item_id")}).Decode(&orderItem); err != nil {
+		log.Printf("inventory: order item %s could not be loaded after a %s: %v", c.Param("order_item_id"), reason, err)
+		return
+	}
+
+	moveStockForOrderItems(ctx, c.GetString("uid"), []models.OrderItem{orderItem}, reason)
+	if reason == models.StockVoid {
+		portions := map[string]int{}
+		for _, foodID := range itemFoodIDs(orderItem) {
+			portions[foodID]++
+		}
+		releasePortions(ctx, portions)
+	}
+}
+
+// DeleteInvoice only deletes invoices that have moved nothing: paid and
+// refunded invoices are kept, as are pending ones with a coupon, gift card
+// payments or points on them, which have to be taken off first.
+func DeleteInvoice() gin.HandlerFunc {
+	blocked := bson.M{"$or": bson.A{
+		bson.M{"payment_status": bson.M{"$in": bson.A{"PAID", "REFUNDED"}}},
+		bson.M{"coupon": bson.M{"$ne": nil}},
+		bson.M{"gift_cards.0": bson.M{"$exists": true}},
+		bson.M{"points_redeemed": bson.M{"$ne": nil}},
+	}}
+	return softDeleteUnless(invoiceCollection, "invoice_id", "Invoice", blocked,
+		"paid and refunded invoices, and invoices with a coupon, gift card payments or points, cannot be deleted")
+}
+
+func RestoreInvoice() gin.HandlerFunc {
+	return restoreDeleted(invoiceCollection, "invoice_id", "Invoice")
+}
+
+func DeleteUser() gin.HandlerFunc {
+	return softDelete(userCollection, "user_id", "User")
+}
+
+func RestoreUser() gin.HandlerFunc {
+	return restoreDeleted(userCollection, "user_id", "User")
+}
+
+func DeleteIngredient() gin.HandlerFunc {
+	return softDelete(ingredientCollection, "ingredient_id", "Ingredient")
+}
+
+func RestoreIngredient() gin.HandlerFunc {
+	return restoreDeleted(ingredientCollection, "ingredient_id", "Ingredient")
+}
+
+func DeleteRecipe() gin.HandlerFunc {
+	return softDelete(recipeCollection, "recipe_id", "Recipe")
+}
+
+func RestoreRecipe() gin.HandlerFunc {
+	return restoreDeleted(recipeCollection, "recipe_id", "Recipe")
+}
+
+func DeleteSupplier() gin.HandlerFunc {
+	return softDelete(supplierCollection, "supplier_id", "Supplier")
+}
+
+func RestoreSupplier() gin.HandlerFunc {
+	return restoreDeleted(supplierCollection, "supplier_id", "Supplier")
+}
+
+func DeletePurchaseOrder() gin.HandlerFunc {
+	return softDelete(purchaseOrderCollection, "purchase_order_id", "Purchase order")
+}
+
+func RestorePurchaseOrder() gin.HandlerFunc {
+	return restoreDeleted(purchaseOrderCollection, "purchase_order_id", "Purchase order")
+}
+
+func DeletePromotion() gin.HandlerFunc {
+	return softDelete(promotionCollection, "promotion_id", "Promotion")
+}
+
+func RestorePromotion() gin.HandlerFunc {
+	return restoreDeleted(promotionCollection, "promotion_id", "Promotion")
+}
+
+func DeleteCoupon() gin.HandlerFunc {
+	return softDelete(couponCollection, "coupon_id", "Coupon")
+}
+
+func RestoreCoupon() gin.HandlerFunc {
+	return restoreDeleted(couponCollection, "coupon_id", "Coupon")
+}
+
+func DeleteCustomer() gin.HandlerFunc {
+	return softDelete(customerCollection, "customer_id", "Customer")
+}
+
+func RestoreCustomer() gin.HandlerFunc {
+	return restoreDeleted(customerCollection, "customer_id", "Customer")
+}
@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"restorent-management/apperrors"
+	"restorent-management/database"
+	"restorent-management/models"
+	"restorent-management/query"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var supplierCollection *mongo.Collection = database.OpenCollection(database.Client, "supplier")
+
+// checkSupplier fails with 400 when a supplier ID is set but unknown.
+func checkSupplier(ctx context.Context, supplierID *string) error {
+	if supplierID == nil {
+		return nil
+	}
+	count, err := supplierCollection.CountDocuments(ctx, notDeleted(bson.M{"supplier_id": *supplierID}))
+	if err != nil {
+		return apperrors.Internal(err, "error occured while fetching the supplier")
+	}
+	if count == 0 {
+		return apperrors.BadRequest("Supplier was not found")
+	}
+	return nil
+}
+
+func CreateSupplier() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var supplier models.Supplier
+		if err := c.ShouldBindJSON(&supplier); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+
+		if err := validate.Struct(supplier); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		now := time.Now()
+		supplier.Created_at = now
+		supplier.Updated_at = now
+		supplier.ID = primitive.NewObjectID()
+		supplier.Version = 1
+		supplier.Supplier_id = supplier.ID.Hex()
+
+		result, err := supplierCollection.InsertOne(ctx, supplier)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "supplier was not created"))
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+var supplierListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "name", Field: "name", Kind: query.String},
+		{Param: "email", Field: "email", Kind: query.String},
+	},
+	Sortable:    []string{"name", "created_at"},
+	DefaultSort: "name",
+}
+
+func GetSuppliers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		list, err := query.Parse(c, supplierListSpec)
+		if err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		notDeleted(list.Filter)
+
+		page, err := query.Find[models.Supplier](ctx, supplierCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while listing suppliers"))
+			return
+		}
+
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func GetSupplierByID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var supplier models.Supplier
+		err := supplierCollection.FindOne(ctx, notDeleted(bson.M{"supplier_id": c.Param("supplier_id")})).Decode(&supplier)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Supplier not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the supplier"))
+			}
+			return
+		}
+		if notModified(c, supplier.Version) {
+			return
+		}
+
+		c.JSON(http.StatusOK, supplier)
+	}
+}
+
+func UpdateSupplier() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var supplier models.Supplier
+		filter := notDeleted(bson.M{"supplier_id": c.Param("supplier_id")})
+
+		err := supplierCollection.FindOne(ctx, filter).Decode(&supplier)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Supplier not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the supplier"))
+			}
+			return
+		}
+		original := supplier
+
+		if err := checkIfMatch(c, supplier.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		if err := applyMergePatch(c, &supplier); err != nil {
+			c.Error(err)
+			return
+		}
+
+		supplier.ID = original.ID
+		supplier.Supplier_id = original.Supplier_id
+		supplier.Created_at = original.Created_at
+		supplier.Version = original.Version + 1
+		supplier.Deleted_at = original.Deleted_at
+		supplier.Deleted_by = original.Deleted_by
+
+		if err := validate.Struct(supplier); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		supplier.Updated_at = time.Now()
+
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = supplierCollection.FindOneAndReplace(ctx, filter, supplier, opts).Decode(&supplier)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Supplier was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "Supplier update failed"))
+			}
+			return
+		}
+
+		c.Header("ETag", etag(supplier.Version))
+		c.JSON(http.StatusOK, supplier)
+	}
+}
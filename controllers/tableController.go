@@ -3,8 +3,10 @@ package controllers
 import (
 	"context"
 	"net/http"
+	"restorent-management/apperrors"
 	"restorent-management/database"
 	"restorent-management/models"
+	"restorent-management/query"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -27,7 +29,7 @@ func CreateTable() gin.HandlerFunc {
 		defer cancel()
 
 		if err := c.ShouldBindJSON(&table); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
 
@@ -38,9 +40,7 @@ func CreateTable() gin.HandlerFunc {
 		err := validate.Struct(table)
 
 		if err != nil {
-			// Validation failed, handle the error
-			errors := err.(validator.ValidationErrors)
-			c.JSON(http.StatusBadRequest, gin.H{"error": errors})
+			c.Error(apperrors.Validation(err))
 			return
 		}
 
@@ -49,12 +49,12 @@ func CreateTable() gin.HandlerFunc {
 		table.Created_at = now
 		table.Updated_at = now
 		table.ID = primitive.NewObjectID()
+		table.Version = 1
 		table.Table_id = table.ID.Hex()
 
 		result, insertErr := tableCollection.InsertOne(ctx, table)
 		if insertErr != nil {
-			msg := insertErr.Error()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			c.Error(apperrors.Internal(insertErr, "table was not created"))
 			return
 		}
 
@@ -62,25 +62,35 @@ func CreateTable() gin.HandlerFunc {
 	}
 }
 
+var tableListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "table_number", Field: "table_number", Kind: query.Number},
+		{Param: "number_of_guests", Field: "number_of_guests", Kind: query.Number},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"table_number", "number_of_guests", "created_at"},
+	DefaultSort: "table_number",
+}
+
 func GetTables() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		cursor, err := tableCollection.Find(ctx, bson.D{})
+		list, err := query.Parse(c, tableListSpec)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
-		defer cursor.Close(ctx)
+		notDeleted(list.Filter)
 
-		var tables []models.Table
-		if err := cursor.All(ctx, &tables); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		page, err := query.Find[models.Table](ctx, tableCollection, list)
+		if err != nil {
+			c.Error(apperrors.Internal(err, ""))
 			return
 		}
 
-		c.JSON(http.StatusOK, tables)
+		c.JSON(http.StatusOK, page)
 	}
 }
 
@@ -90,11 +100,20 @@ func GetTableByID() gin.HandlerFunc {
 		tableId := c.Param("table_id")
 		var table models.Table
 
-		err := tableCollection.FindOne(ctx, bson.M{"table_id": tableId}).Decode(&table)
+		err := tableCollection.FindOne(ctx, notDeleted(bson.M{"table_id": tableId})).Decode(&table)
 		defer cancel()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the table"})
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Table not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the table"))
+			}
+			return
 		}
+		if notModified(c, table.Version) {
+			return
+		}
+
 		c.JSON(http.StatusOK, table)
 	}
 }
@@ -107,34 +126,57 @@ func UpdateTable() gin.HandlerFunc {
 		var table models.Table
 
 		tableId := c.Param("table_id")
+		filter := notDeleted(bson.M{"table_id": tableId})
 
-		if err := c.BindJSON(&table); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		err := tableCollection.FindOne(ctx, filter).Decode(&table)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Table not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the table"))
+			}
 			return
 		}
+		original := table
 
-		updateObj := bson.M{}
-
-		if table.Number_of_guests != nil {
-			updateObj["Number_of_guests"] = *table.Number_of_guests
+		if err := checkIfMatch(c, table.Version); err != nil {
+			c.Error(err)
+			return
 		}
 
-		if table.Table_number != nil {
-			updateObj["Table_number"] = *table.Table_number
+		if err := applyMergePatch(c, &table); err != nil {
+			c.Error(err)
+			return
 		}
 
-		updateObj["updated_at"] = time.Now()
+		table.ID = original.ID
+		table.Table_id = original.Table_id
+		table.Created_at = original.Created_at
+		table.Version = original.Version + 1
+		table.Deleted_at = original.Deleted_at
+		table.Deleted_by = original.Deleted_by
+
+		if err := validate.Struct(table); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
 
-		update := bson.M{"$set": updateObj}
-		filter := bson.M{"table_id": tableId}
-		opts := options.Update().SetUpsert(true)
+		table.Updated_at = time.Now()
 
-		result, err := tableCollection.UpdateOne(ctx, filter, update, opts)
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = tableCollection.FindOneAndReplace(ctx, filter, table, opts).Decode(&table)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Table item update failed"})
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("Table was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, "Table item update failed"))
+			}
 			return
 		}
 
-		c.JSON(http.StatusOK, result)
+		c.Header("ETag", etag(table.Version))
+		c.JSON(http.StatusOK, table)
 	}
 }
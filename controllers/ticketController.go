@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"restorent-management/apperrors"
+	"restorent-management/models"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// KitchenTicket is what the kitchen needs to prepare an order: which table
+// it is for and each item with the modifiers the guest picked. Prices are
+// left out on purpose.
+type KitchenTicket struct {
+	Order_id     string       `json:"order_id"`
+	Order_date   time.Time    `json:"order_date"`
+	Order_type   string       `json:"order_type"`
+	Table_number *int         `json:"table_number"`
+	Pickup_time  *time.Time   `json:"pickup_time"`
+	Items        []TicketItem `json:"items"`
+}
+
+// TicketItem is one dish to prepare. A bundle is split into one item per
+// component, each naming the bundle it belongs to.
+type TicketItem struct {
+	Order_item_id   string           `json:"order_item_id"`
+	Food_name       string           `json:"food_name"`
+	Bundle          string           `json:"bundle,omitempty"`
+	Quantity        string           `json:"quantity"`
+	Modifiers       []TicketModifier `json:"modifiers"`
+	Note            string           `json:"note,omitempty"`
+	Allergens       []string         `json:"allergens"`
+	Allergy_warning string           `json:"allergy_warning,omitempty"`
+}
+
+type TicketModifier struct {
+	Group  string `json:"group"`
+	Option string `json:"option"`
+}
+
+func GetKitchenTicket() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var order models.Order
+		err := orderCollection.FindOne(ctx, notDeleted(bson.M{"order_id": c.Param("order_id")})).Decode(&order)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("Order not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "error occured while fetching the order"))
+			}
+			return
+		}
+
+		ticket, err := kitchenTicket(ctx, order)
+		if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while building the kitchen ticket"))
+			return
+		}
+
+		c.JSON(http.StatusOK, ticket)
+	}
+}
+
+func kitchenTicket(ctx context.Context, order models.Order) (KitchenTicket, error) {
+	ticket := KitchenTicket{Order_id: order.Order_id, Order_date: order.Order_Date, Pickup_time: order.Pickup_time, Items: []TicketItem{}}
+	ticket.Order_type = order.Order_type
+	if ticket.Order_type == "" {
+		ticket.Order_type = models.OrderDineIn
+	}
+
+	if order.Table_id != nil {
+		var table models.Table
+		err := tableCollection.FindOne(ctx, bson.M{"table_id": *order.Table_id}).Decode(&table)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return ticket, err
+		}
+		ticket.Table_number = table.Table_number
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := orderItemCollection.Find(ctx, notDeleted(bson.M{"order_id": order.Order_id}), opts)
+	if err != nil {
+		return ticket, err
+	}
+	var orderItems []models.OrderItem
+	if err := cursor.All(ctx, &orderItems); err != nil {
+		return ticket, err
+	}
+
+	foods, err := foodsFor(ctx, orderItems)
+	if err != nil {
+		return ticket, err
+	}
+
+	for _, orderItem := range orderItems {
+		food := foods[*orderItem.Food_id]
+		var bundle string
+		if len(orderItem.Components) > 0 && food.Name != nil {
+			bundle = *food.Name
+		}
+
+		for i, served := range servedFoods(orderItem) {
+			item := ticketItem(orderItem, foods[served.food_id], served.size)
+			item.Bundle = bundle
+			// The bundle's own modifiers are printed once, with its first
+			// component
+			if i > 0 {
+				item.Modifiers = []TicketModifier{}
+			}
+			ticket.Items = append(ticket.Items, item)
+		}
+	}
+
+	return ticket, nil
+}
+
+func ticketItem(orderItem models.OrderItem, food models.Food, size string) TicketItem {
+	item := TicketItem{
+		Order_item_id: orderItem.Order_item_id,
+		Quantity:      size,
+		Modifiers:     []TicketModifier{},
+		Allergens:     []string{},
+	}
+	if food.Name != nil {
+		item.Food_name = *food.Name
+	}
+	if food.Allergens != nil {
+		item.Allergens = food.Allergens
+	}
+	if orderItem.Note != nil {
+		item.Note = *orderItem.Note
+		item.Allergy_warning = allergyWarning(item.Note, item.Allergens)
+	}
+	for _, modifier := range orderItem.Modifiers {
+		item.Modifiers = append(item.Modifiers, TicketModifier{Group: modifier.Group_name, Option: modifier.Name})
+	}
+	return item
+}
+
+// allergyWarning returns a warning for the kitchen when a guest's note
+// mentions an allergy, calling out the allergens the note names that the
+// dish is declared to contain.
+func allergyWarning(note string, allergens []string) string {
+	mentioned, named := models.AllergyNote(note)
+	if !mentioned {
+		return ""
+	}
+
+	var conflicts []string
+	for _, allergen := range named {
+		if slices.Contains(allergens, allergen) {
+			conflicts = append(conflicts, allergen)
+		}
+	}
+
+	switch {
+	case len(conflicts) > 0:
+		return "ALLERGY: guest note mentions " + strings.Join(conflicts, ", ") + " and this dish contains it"
+	case len(allergens) > 0:
+		return "ALLERGY: check the guest note, this dish contains " + strings.Join(allergens, ", ")
+	default:
+		return "ALLERGY: check the guest note, this dish has no declared allergens"
+	}
+}
+
+// foodsFor loads the foods of the order items, including the components of
+// bundles, keyed by food ID. Deleted
+// foods are included so that an order placed before a deletion still
+// prints in full.
+func foodsFor(ctx context.Context, orderItems []models.OrderItem) (map[string]models.Food, error) {
+	ids := bson.A{}
+	for _, orderItem := range orderItems {
+		for _, foodID := range itemFoodIDs(orderItem) {
+			ids = append(ids, foodID)
+		}
+	}
+
+	foods := make(map[string]models.Food, len(ids))
+	if len(ids) == 0 {
+		return foods, nil
+	}
+
+	cursor, err := foodCollection.Find(ctx, bson.M{"food_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Food
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	for _, food := range found {
+		foods[food.Food_id] = food
+	}
+	return foods, nil
+}
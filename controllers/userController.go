@@ -2,14 +2,15 @@ package controllers
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"net/http"
-	"reflect"
+	"restorent-management/apperrors"
 	"restorent-management/database"
 	"restorent-management/helper"
+	"restorent-management/middleware"
 	"restorent-management/models"
-	"strconv"
+	"restorent-management/query"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -22,9 +23,10 @@ import (
 )
 
 var (
-	userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
-	ErrEmailInUse                    = errors.New("this email is already in use")
-	ErrPhoneInUse                    = errors.New("this phone number is already in use")
+	userCollection  *mongo.Collection = database.OpenCollection(database.Client, "user")
+	ErrEmailInUse                     = apperrors.Conflict("this email is already in use")
+	ErrPhoneInUse                     = apperrors.Conflict("this phone number is already in use")
+	ErrInvalidLogin                   = apperrors.Unauthorized("login or password is incorrect")
 )
 
 func HashPassword(password string) (string, error) {
@@ -45,16 +47,65 @@ func VerifyPassword(userPassword string, providedPassword string) (bool, string)
 	return check, msg
 }
 
+// duplicateUserError maps a duplicate key error from the user indexes to
+// the matching sentinel error.
+func duplicateUserError(err error) error {
+	if strings.Contains(err.Error(), "user_phone_unique") {
+		return ErrPhoneInUse
+	}
+	return ErrEmailInUse
+}
+
+// SignUpRequest is the body of POST /users/signup. The password is read
+// here because models.User never takes it from JSON.
+type SignUpRequest struct {
+	First_name string  `json:"first_name"`
+	Last_name  string  `json:"last_name"`
+	Password   string  `json:"password"`
+	Email      string  `json:"email"`
+	Avatar     *string `json:"avatar"`
+	Phone      string  `json:"phone"`
+}
+
+// LoginRequest is the body of POST /users/login.
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// LoginResponse is the signed in user with their new tokens, the only
+// response that carries them.
+type LoginResponse struct {
+	models.User
+	Token         string `json:"token"`
+	Refresh_token string `json:"refresh_token"`
+}
+
+// userPatch is what PATCH /users/:user_id merges into: the user together
+// with the password, which models.User leaves out of JSON.
+type userPatch struct {
+	models.User
+	Password string `json:"password"`
+}
+
 func SignUp() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		var user models.User
-		if err := c.ShouldBindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		var request SignUpRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
+		user := models.User{
+			First_name: request.First_name,
+			Last_name:  request.Last_name,
+			Password:   request.Password,
+			Email:      request.Email,
+			Avatar:     request.Avatar,
+			Phone:      request.Phone,
+		}
 
 		// Create a new validator instance
 		validate := validator.New()
@@ -62,34 +113,32 @@ func SignUp() gin.HandlerFunc {
 		// Validate the User struct
 		err := validate.Struct(user)
 		if err != nil {
-			// Validation failed, handle the error
-			errors := err.(validator.ValidationErrors)
-			c.JSON(http.StatusBadRequest, gin.H{"error": errors})
+			c.Error(apperrors.Validation(err))
 			return
 		}
 
 		// Check if email is already in use
 		if count, err := userCollection.CountDocuments(ctx, bson.M{"email": user.Email}); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error checking email"})
+			c.Error(apperrors.Internal(err, "error checking email"))
 			return
 		} else if count > 0 {
-			c.JSON(http.StatusConflict, gin.H{"error": ErrEmailInUse.Error()})
+			c.Error(ErrEmailInUse)
 			return
 		}
 
 		// Check if phone is already in use
 		if count, err := userCollection.CountDocuments(ctx, bson.M{"phone": user.Phone}); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error checking phone number"})
+			c.Error(apperrors.Internal(err, "error checking phone number"))
 			return
 		} else if count > 0 {
-			c.JSON(http.StatusConflict, gin.H{"error": ErrPhoneInUse.Error()})
+			c.Error(ErrPhoneInUse)
 			return
 		}
 
 		// Hash password
 		hashedPassword, err := HashPassword(user.Password)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error hashing password"})
+			c.Error(apperrors.Internal(err, "error hashing password"))
 			return
 		}
 		user.Password = hashedPassword
@@ -99,21 +148,29 @@ func SignUp() gin.HandlerFunc {
 		user.Created_at = now
 		user.Updated_at = now
 		user.ID = primitive.NewObjectID()
+		user.Version = 1
 		user.User_id = user.ID.Hex()
+		// Permissions are granted by a user manager, never at signup
+		user.Permissions = nil
 
 		// Generate tokens
-		token, refreshToken, err := helper.GenerateAllTokens(user.Email, user.First_name, user.Last_name, user.User_id)
+		token, refreshToken, err := helper.GenerateAllTokens(user.Email, user.First_name, user.Last_name, user.User_id, user.Permissions)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error generating tokens"})
+			c.Error(apperrors.Internal(err, "error generating tokens"))
 			return
 		}
 		user.Token = token
 		user.Refresh_Token = refreshToken
 
-		// Insert user into database
+		// Insert user into database. The unique indexes on email and phone
+		// catch signups that race past the checks above.
 		result, err := userCollection.InsertOne(ctx, user)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating user"})
+			if mongo.IsDuplicateKeyError(err) {
+				c.Error(duplicateUserError(err))
+				return
+			}
+			c.Error(apperrors.Internal(err, "error creating user"))
 			return
 		}
 
@@ -125,41 +182,55 @@ func Login() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		var user models.User
+		var user LoginRequest
 		var foundUser models.User
 
 		//convert the login data from postman which is in JSON to golang readable format
 		if err := c.ShouldBindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
 
 		//find a user with that email and see if that user even exists
-		err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
+		err := userCollection.FindOne(ctx, notDeleted(bson.M{"email": user.Email})).Decode(&foundUser)
 		defer cancel()
-		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		if err == mongo.ErrNoDocuments {
+			// same answer as a wrong password so emails cannot be probed
+			c.Error(ErrInvalidLogin)
+			return
+		} else if err != nil {
+			c.Error(apperrors.Internal(err, "Error occurred while fetching user"))
 			return
 		}
 
 		//then you will verify the password
-		passwordIsValid, msg := VerifyPassword(user.Password, foundUser.Password)
+		passwordIsValid, _ := VerifyPassword(user.Password, foundUser.Password)
 
 		if !passwordIsValid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			c.Error(ErrInvalidLogin)
 			return
 		}
 
 		//if all goes well, then you'll generate tokens
-		token, refreshToken, _ := helper.GenerateAllTokens(foundUser.Email, foundUser.First_name, foundUser.Last_name, foundUser.User_id)
+		token, refreshToken, _ := helper.GenerateAllTokens(foundUser.Email, foundUser.First_name, foundUser.Last_name, foundUser.User_id, foundUser.Permissions)
 		helper.UpdateAllTokens(token, refreshToken, foundUser.User_id)
 
 		//return statusOK
-		c.JSON(http.StatusOK, foundUser)
+		c.JSON(http.StatusOK, LoginResponse{User: foundUser, Token: token, Refresh_token: refreshToken})
 
 	}
 }
 
+var userListSpec = query.Spec{
+	Filters: []query.Field{
+		{Param: "email", Field: "email", Kind: query.String},
+		{Param: "phone", Field: "phone", Kind: query.String},
+		{Param: "created_at", Field: "created_at", Kind: query.Date},
+	},
+	Sortable:    []string{"email", "first_name", "last_name", "created_at"},
+	DefaultSort: "-created_at",
+}
+
 /*
 *
 GetOneUser fetches a specific user from the database based on the provided user ID.
@@ -167,7 +238,7 @@ GetOneUser fetches a specific user from the database based on the provided user
 @param c *gin.Context
 @return gin.HandlerFunc
 
-The function takes a gin.Context as a parameter and returns a gin.HandlerFunc. It retrieves the user ID from the URL parameter "user_id". It then uses the provided userCollection to find a user with the matching user ID. If the user is found, it returns the user in the JSON response with status code 200 (OK). If the user is not found, it returns an error message with status code 500 (Internal Server Error).
+The function takes a gin.Context as a parameter and returns a gin.HandlerFunc. It retrieves the user ID from the URL parameter "user_id". It then uses the provided userCollection to find a user with the matching user ID. If the user is found, it returns the user in the JSON response with status code 200 (OK). If the user is not found, it returns a 404 (Not Found) problem response.
 
 @see https://godoc.org/github.com/gin-gonic/gin
 @see https://godoc.org/go.mongodb.org/mongo-driver/bson
@@ -182,27 +253,35 @@ func GetUser() gin.HandlerFunc {
 
 		userId := c.Param("user_id")
 		var user models.User
-		err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user)
+		err := userCollection.FindOne(ctx, notDeleted(bson.M{"user_id": userId})).Decode(&user)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching user"})
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("User not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "Error occurred while fetching user"))
+			}
 			return
 		}
+		if notModified(c, user.Version) {
+			return
+		}
+
 		c.JSON(http.StatusOK, user)
 	}
 }
 
 /*
 *
-GetUsers fetches users from the database and returns them along with the total count, page number, and records per page.
+GetUsers fetches a page of users from the database together with the total count and the cursor of the next page.
 
 @param c *gin.Context
 @return gin.HandlerFunc
 
-The function takes a gin.Context as a parameter and returns a gin.HandlerFunc. It sets the default values for the record per page and page number if they are not provided in the query parameters. It then calculates the start index based on the page and record per page values.
+The function takes a gin.Context as a parameter and returns a gin.HandlerFunc. It parses the shared list parameters (limit, cursor, sort and the email, phone and created_at filters) from the query string.
 
-The function uses the provided userCollection to find users with the specified projection and pagination options. It decodes the cursor into a slice of bson.M documents and counts the total number of users.
+The function uses the provided userCollection to find users matching the filters, leaving out passwords and tokens, and counts the total number of matching users.
 
-Finally, it returns a JSON response containing the users, total count, page number, and records per page.
+Finally, it returns the standard list envelope containing the users, total count, limit and next cursor.
 
 @see https://godoc.org/github.com/gin-gonic/gin
 @see https://godoc.org/go.mongodb.org/mongo-driver/bson
@@ -214,55 +293,26 @@ func GetUsers() gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		recordPerPage, err := strconv.Atoi(c.DefaultQuery("recordPerPage", "10"))
-		if err != nil || recordPerPage < 1 {
-			recordPerPage = 10
-		}
-
-		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
-		if err != nil || page < 1 {
-			page = 1
-		}
-
-		startIndex := (page - 1) * recordPerPage
-
-		matchStage := bson.D{{}}
-		opts := options.Find().
-			SetSkip(int64(startIndex)).
-			SetLimit(int64(recordPerPage)).
-			SetProjection(bson.D{
-				{"password", 0},
-				{"refresh_token", 0},
-			})
-
-		cursor, err := userCollection.Find(ctx, matchStage, opts)
+		list, err := query.Parse(c, userListSpec)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching users"})
+			c.Error(apperrors.BadRequest(err.Error()))
 			return
 		}
-		defer cursor.Close(ctx)
+		notDeleted(list.Filter)
+		list.Projection = bson.M{"password": 0, "token": 0, "refresh_token": 0}
 
-		var users []bson.M
-		if err = cursor.All(ctx, &users); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding user data"})
-			return
-		}
-
-		totalCount, err := userCollection.CountDocuments(ctx, matchStage)
+		page, err := query.Find[bson.M](ctx, userCollection, list)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while counting total users"})
+			c.Error(apperrors.Internal(err, "Error occurred while fetching users"))
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"users":       users,
-			"total_count": totalCount,
-			"page":        page,
-			"per_page":    recordPerPage,
-		})
+		c.JSON(http.StatusOK, page)
 	}
 }
 
+// UpdateUser lets users change their own profile and password. Changing
+// another user's needs the manage_users permission.
 func UpdateUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -270,51 +320,144 @@ func UpdateUser() gin.HandlerFunc {
 
 		var user models.User
 
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		userId := c.Param("user_id")
+		if userId != c.GetString("uid") && !middleware.HasPermission(c, models.PermissionManageUsers) {
+			c.Error(apperrors.Forbidden("the " + models.PermissionManageUsers + " permission is required to change another user"))
 			return
 		}
+		filter := notDeleted(bson.M{"user_id": userId})
 
-		userId := c.Param("user_id")
+		err := userCollection.FindOne(ctx, filter).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("User not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "Error occurred while fetching user"))
+			}
+			return
+		}
+		original := user
+
+		if err := checkIfMatch(c, user.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		patch := userPatch{User: user, Password: user.Password}
+		if err := applyMergePatch(c, &patch); err != nil {
+			c.Error(err)
+			return
+		}
+		user = patch.User
+		user.Password = patch.Password
+
+		// Identity and tokens are managed by the server
+		user.ID = original.ID
+		user.User_id = original.User_id
+		user.Token = original.Token
+		user.Refresh_Token = original.Refresh_Token
+		user.Permissions = original.Permissions
+		user.Created_at = original.Created_at
+		user.Version = original.Version + 1
+		user.Deleted_at = original.Deleted_at
+		user.Deleted_by = original.Deleted_by
+
+		if err := validate.Struct(user); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
+		}
+
+		if user.Password == "" {
+			c.Error(apperrors.BadRequest("password cannot be removed"))
+			return
+		}
 
-		if user.Password != "" {
+		if user.Password != original.Password {
 			hashedPassword, err := HashPassword(user.Password)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "error hashing password"})
+				c.Error(apperrors.Internal(err, "error hashing password"))
 				return
 			}
 			user.Password = hashedPassword
 		}
 
-		filter := bson.M{"user_id": userId}
-
-		// Create a map to hold the fields to update
-		updateFields := make(map[string]interface{})
+		user.Updated_at = time.Now()
 
-		// Iterate through the struct fields and add non-zero values to updateFields
-		val := reflect.ValueOf(user)
-		typ := val.Type()
-		for i := 0; i < val.NumField(); i++ {
-			field := val.Field(i)
-			if !field.IsZero() && typ.Field(i).Name != "ID" { // Exclude the ID field
-				updateFields[typ.Field(i).Name] = field.Interface()
+		// Only replace the version the precondition was checked against
+		filter["version"] = original.Version
+		opts := options.FindOneAndReplace().SetReturnDocument(options.After)
+		err = userCollection.FindOneAndReplace(ctx, filter, user, opts).Decode(&user)
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				c.Error(duplicateUserError(err))
+			} else if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("User was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, ""))
 			}
+			return
 		}
-		fmt.Println(updateFields)
-		update := bson.M{"$set": updateFields}
 
-		upsert := true
-		opt := options.UpdateOptions{
-			Upsert: &upsert,
+		c.Header("ETag", etag(user.Version))
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+type PermissionsRequest struct {
+	Permissions []string `json:"permissions" validate:"dive,oneof=price_override manage_users"`
+}
+
+// UpdateUserPermissions replaces the permissions granted to a user. They
+// apply to the user's next request, whatever token it is made with.
+func UpdateUserPermissions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var request PermissionsRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.Error(apperrors.BadRequest(err.Error()))
+			return
+		}
+		if err := validate.Struct(request); err != nil {
+			c.Error(apperrors.Validation(err))
+			return
 		}
 
-		_, err := userCollection.UpdateOne(ctx, filter, update, &opt)
+		var user models.User
+		filter := notDeleted(bson.M{"user_id": c.Param("user_id")})
+		err := userCollection.FindOne(ctx, filter).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.NotFound("User not found"))
+			} else {
+				c.Error(apperrors.Internal(err, "Error occurred while fetching user"))
+			}
+			return
+		}
 
+		if err := checkIfMatch(c, user.Version); err != nil {
+			c.Error(err)
+			return
+		}
+
+		filter["version"] = user.Version
+		update := bson.M{
+			"$set": bson.M{"permissions": request.Permissions, "updated_at": time.Now()},
+			"$inc": bson.M{"version": 1},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = userCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			if err == mongo.ErrNoDocuments {
+				c.Error(apperrors.PreconditionFailed("User was modified by another request"))
+			} else {
+				c.Error(apperrors.Internal(err, ""))
+			}
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "User updated successfully"})
+		c.Header("ETag", etag(user.Version))
+		c.JSON(http.StatusOK, user)
 	}
 }
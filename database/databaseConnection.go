@@ -10,6 +10,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const DatabaseName = "restaurant"
+
 func DBinstance() *mongo.Client {
 	MongoDB := "mongodb://localhost:27017"
 	fmt.Println(MongoDB)
@@ -31,8 +33,12 @@ func DBinstance() *mongo.Client {
 
 var Client *mongo.Client = DBinstance()
 
+func OpenDatabase(client *mongo.Client) *mongo.Database {
+	return client.Database(DatabaseName)
+}
+
 func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collection {
-	var collection *mongo.Collection = client.Database("restaurant").Collection(collectionName)
+	var collection *mongo.Collection = OpenDatabase(client).Collection(collectionName)
 
 	return collection
 }
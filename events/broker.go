@@ -0,0 +1,51 @@
+package events
+
+import "sync"
+
+// subscriberBuffer is how many events a slow subscriber can fall behind by
+// before further events to it are dropped.
+const subscriberBuffer = 16
+
+// Broker fans events out to every subscriber in this process. Publish
+// never blocks; a subscriber whose buffer is full misses the event and is
+// expected to refetch the state it cares about.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan interface{}]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[chan interface{}]struct{})}
+}
+
+// Subscribe returns a channel of published events and a function that
+// unsubscribes and closes the channel.
+func (b *Broker) Subscribe() (<-chan interface{}, func()) {
+	ch := make(chan interface{}, subscriberBuffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (b *Broker) Publish(event interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
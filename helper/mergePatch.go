@@ -0,0 +1,46 @@
+package helper
+
+import (
+	"encoding/json"
+)
+
+// MergePatch applies patch to doc following RFC 7396 (JSON Merge Patch):
+// object members in the patch replace the ones in doc, null members remove
+// them, and any non-object patch replaces the document entirely.
+func MergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var patchValue interface{}
+	if err := json.Unmarshal(patch, &patchValue); err != nil {
+		return nil, err
+	}
+
+	var docValue interface{}
+	if len(doc) > 0 {
+		if err := json.Unmarshal(doc, &docValue); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(mergeValue(docValue, patchValue))
+}
+
+func mergeValue(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergeValue(targetObject[key], value)
+	}
+
+	return targetObject
+}
@@ -0,0 +1,98 @@
+package helper
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "members replace and add",
+			doc:   `{"name":"Soup","price":5}`,
+			patch: `{"price":6,"tags":["hot"]}`,
+			want:  `{"name":"Soup","price":6,"tags":["hot"]}`,
+		},
+		{
+			name:  "null removes a member",
+			doc:   `{"name":"Soup","description":"Hot"}`,
+			patch: `{"description":null}`,
+			want:  `{"name":"Soup"}`,
+		},
+		{
+			name:  "nested objects merge",
+			doc:   `{"address":{"city":"Paris","zip":"75001"}}`,
+			patch: `{"address":{"zip":"75002","street":null}}`,
+			want:  `{"address":{"city":"Paris","zip":"75002"}}`,
+		},
+		{
+			name:  "arrays are replaced whole",
+			doc:   `{"tags":["a","b"]}`,
+			patch: `{"tags":["c"]}`,
+			want:  `{"tags":["c"]}`,
+		},
+		{
+			name:  "an object replaces a scalar",
+			doc:   `{"price":5}`,
+			patch: `{"price":{"amount":5}}`,
+			want:  `{"price":{"amount":5}}`,
+		},
+		{
+			name:  "a non-object patch replaces the document",
+			doc:   `{"name":"Soup"}`,
+			patch: `["Soup"]`,
+			want:  `["Soup"]`,
+		},
+		{
+			name:  "an empty document",
+			doc:   ``,
+			patch: `{"name":"Soup","price":null}`,
+			want:  `{"name":"Soup"}`,
+		},
+		{
+			name:    "invalid patch",
+			doc:     `{}`,
+			patch:   `{"name":`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid document",
+			doc:     `{"name":`,
+			patch:   `{}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("MergePatch() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MergePatch() error = %v", err)
+			}
+
+			var gotValue, wantValue interface{}
+			if err := json.Unmarshal(got, &gotValue); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &wantValue); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("MergePatch() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package helper
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Location is the restaurant's time zone, used for menu schedules. It comes
+// from RESTAURANT_TIMEZONE (an IANA name such as Europe/London) and defaults
+// to the server's local zone.
+var Location *time.Location = loadLocation()
+
+func loadLocation() *time.Location {
+	name := os.Getenv("RESTAURANT_TIMEZONE")
+	if name == "" {
+		return time.Local
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("unknown RESTAURANT_TIMEZONE %q, using local time: %v", name, err)
+		return time.Local
+	}
+	return location
+}
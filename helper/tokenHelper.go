@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"restorent-management/database"
+	"restorent-management/models"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
@@ -16,10 +17,11 @@ import (
 )
 
 type SignedDetails struct {
-	Email      string
-	First_name string
-	Last_name  string
-	Uid        string
+	Email       string
+	First_name  string
+	Last_name   string
+	Uid         string
+	Permissions []string
 	jwt.StandardClaims
 }
 
@@ -27,12 +29,13 @@ var userCollection *mongo.Collection = database.OpenCollection(database.Client,
 
 var SECRET_KEY string = os.Getenv("SECRET_KEY")
 
-func GenerateAllTokens(email string, firstName string, lastName string, uid string) (signedToken string, signedRefreshToken string, err error) {
+func GenerateAllTokens(email string, firstName string, lastName string, uid string, permissions []string) (signedToken string, signedRefreshToken string, err error) {
 	claims := &SignedDetails{
-		Email:      email,
-		First_name: firstName,
-		Last_name:  lastName,
-		Uid:        uid,
+		Email:       email,
+		First_name:  firstName,
+		Last_name:   lastName,
+		Uid:         uid,
+		Permissions: permissions,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
 		},
@@ -79,6 +82,7 @@ func UpdateAllTokens(signedToken string, signedRefreshToken string, userId strin
 		filter,
 		bson.D{
 			{"$set", updateObj},
+			{"$inc", bson.M{"version": 1}},
 		},
 		&opt,
 	)
@@ -103,21 +107,31 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 	)
 
 	//the token is invalid
+	if err != nil {
+		msg = err.Error()
+		return
+	}
 
 	claims, ok := token.Claims.(*SignedDetails)
-	if !ok {
+	if !ok || !token.Valid {
 		msg = fmt.Sprintf("the token is invalid")
-		msg = err.Error()
 		return
 	}
 
 	//the token is expired
 	if claims.ExpiresAt < time.Now().Local().Unix() {
 		msg = fmt.Sprint("token is expired")
-		msg = err.Error()
 		return
 	}
 
 	return claims, msg
 
 }
+
+// ActiveUser loads the user a token was issued to, as long as they have not
+// been deleted since it was issued.
+func ActiveUser(ctx context.Context, uid string) (models.User, error) {
+	var user models.User
+	err := userCollection.FindOne(ctx, bson.M{"user_id": uid, "deleted_at": nil}).Decode(&user)
+	return user, err
+}
@@ -0,0 +1,138 @@
+// Package imaging decodes uploaded images and makes resized copies of them
+// using only the standard library.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+
+	_ "image/gif"
+)
+
+// MaxPixels caps the decoded size of an image. A small, highly compressed
+// file can otherwise expand to gigabytes once decoded.
+const MaxPixels = 40_000_000
+
+// jpegQuality is used for every resized JPEG.
+const jpegQuality = 85
+
+// Decode reads the dimensions from the header before decoding, so images
+// over MaxPixels are refused without being decoded.
+func Decode(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, errors.New("image has no pixels")
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, fmt.Errorf("image is %dx%d, more than %d pixels", config.Width, config.Height, MaxPixels)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// Fit scales img down so that neither side is longer than maxSide, keeping
+// the aspect ratio. Images that already fit are copied at their own size,
+// never enlarged.
+func Fit(img image.Image, maxSide int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if longest := max(width, height); longest > maxSide {
+		scale := float64(maxSide) / float64(longest)
+		width = max(1, int(math.Round(float64(width)*scale)))
+		height = max(1, int(math.Round(float64(height)*scale)))
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	if width == bounds.Dx() && height == bounds.Dy() {
+		return src
+	}
+	return resample(src, width, height)
+}
+
+// Encode writes img as PNG when it has transparent pixels and as JPEG
+// otherwise, returning the bytes and their content type.
+func Encode(img *image.RGBA) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if !img.Opaque() {
+		err := png.Encode(&buf, img)
+		return buf.Bytes(), "image/png", err
+	}
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	return buf.Bytes(), "image/jpeg", err
+}
+
+// contribution is the share of one source pixel in a destination pixel.
+type contribution struct {
+	index  int
+	weight float64
+}
+
+// contributions spreads srcLen pixels over dstLen by area: each
+// destination pixel averages the source pixels it covers, weighted by how
+// much of each it covers. It is only used for shrinking.
+func contributions(srcLen, dstLen int) [][]contribution {
+	scale := float64(srcLen) / float64(dstLen)
+	out := make([][]contribution, dstLen)
+	for i := range out {
+		start := float64(i) * scale
+		end := start + scale
+		for j := int(start); j < srcLen && float64(j) < end; j++ {
+			covered := math.Min(end, float64(j+1)) - math.Max(start, float64(j))
+			if covered > 0 {
+				out[i] = append(out[i], contribution{index: j, weight: covered / scale})
+			}
+		}
+	}
+	return out
+}
+
+// resample shrinks src to width x height with an area average, first
+// across and then down. RGBA is alpha premultiplied, so averaging the
+// channels directly does not bleed the color of transparent pixels.
+func resample(src *image.RGBA, width, height int) *image.RGBA {
+	srcW, srcH := src.Rect.Dx(), src.Rect.Dy()
+
+	across := contributions(srcW, width)
+	tmp := make([]float64, width*srcH*4)
+	for y := 0; y < srcH; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, contribs := range across {
+			out := tmp[(y*width+x)*4:]
+			for _, c := range contribs {
+				for ch := 0; ch < 4; ch++ {
+					out[ch] += float64(row[c.index*4+ch]) * c.weight
+				}
+			}
+		}
+	}
+
+	down := contributions(srcH, height)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, contribs := range down {
+		row := dst.Pix[y*dst.Stride:]
+		for x := 0; x < width; x++ {
+			var sum [4]float64
+			for _, c := range contribs {
+				in := tmp[(c.index*width+x)*4:]
+				for ch := 0; ch < 4; ch++ {
+					sum[ch] += in[ch] * c.weight
+				}
+			}
+			for ch := 0; ch < 4; ch++ {
+				row[x*4+ch] = uint8(math.Min(255, math.Round(sum[ch])))
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,134 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func solid(width, height int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// gifHeader is the start of a GIF that claims to be width x height. It is
+// enough for DecodeConfig but not for Decode.
+func gifHeader(width, height uint16) []byte {
+	data := []byte("GIF89a")
+	data = binary.LittleEndian.AppendUint16(data, width)
+	data = binary.LittleEndian.AppendUint16(data, height)
+	return append(data, 0, 0, 0)
+}
+
+func TestDecode(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, solid(4, 3, color.White)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    image.Rectangle
+		wantErr bool
+	}{
+		{name: "png", data: encoded.Bytes(), want: image.Rect(0, 0, 4, 3)},
+		{name: "not an image", data: []byte("hello"), wantErr: true},
+		{name: "empty", data: nil, wantErr: true},
+		{name: "no pixels", data: gifHeader(0, 10), wantErr: true},
+		{name: "too many pixels", data: gifHeader(65535, 65535), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Decode(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Decode() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if img.Bounds() != tt.want {
+				t.Errorf("Decode() bounds = %v, want %v", img.Bounds(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name    string
+		width   int
+		height  int
+		maxSide int
+		want    image.Point
+	}{
+		{"landscape", 1000, 500, 100, image.Pt(100, 50)},
+		{"portrait", 300, 900, 90, image.Pt(30, 90)},
+		{"already fits", 40, 20, 100, image.Pt(40, 20)},
+		{"never enlarged", 10, 10, 100, image.Pt(10, 10)},
+		{"thin sides keep a pixel", 3, 1000, 100, image.Pt(1, 100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rect(5, 5, 5+tt.width, 5+tt.height))
+			want := image.Rectangle{Max: tt.want}
+			if got := Fit(src, tt.maxSide).Bounds(); got != want {
+				t.Errorf("Fit() bounds = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFitAveragesPixels(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{A: 255})
+	src.Set(1, 0, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+
+	got := Fit(src, 1).RGBAAt(0, 0)
+	if want := (color.RGBA{R: 128, G: 128, B: 128, A: 255}); got != want {
+		t.Errorf("Fit() pixel = %v, want %v", got, want)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		img  *image.RGBA
+		want string
+	}{
+		{"opaque is jpeg", solid(8, 8, color.White), "image/jpeg"},
+		{"transparent is png", solid(8, 8, color.RGBA{}), "image/png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, contentType, err := Encode(tt.img)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if contentType != tt.want {
+				t.Errorf("Encode() content type = %q, want %q", contentType, tt.want)
+			}
+			img, err := Decode(data)
+			if err != nil {
+				t.Fatalf("Decode() of encoded image error = %v", err)
+			}
+			if img.Bounds() != tt.img.Bounds() {
+				t.Errorf("Encode() bounds = %v, want %v", img.Bounds(), tt.img.Bounds())
+			}
+		})
+	}
+}
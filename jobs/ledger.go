@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"restorent-management/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ledger is a collection of entries and the collection of accounts whose
+// balances they move.
+type ledger struct {
+	entries  string
+	accounts string
+	key      string
+	pending  string
+	balance  []string
+}
+
+var ledgers = []ledger{
+	{entries: "giftCardEntry", accounts: "giftCard", key: "gift_card_id", pending: "pending_entries", balance: []string{"balance"}},
+	{entries: "loyaltyEntry", accounts: "customer", key: "customer_id", pending: "loyalty.pending_entries", balance: []string{"loyalty", "points"}},
+}
+
+// SettleLedgers settles the ledger entries left pending before cutoff by a
+// move that was cut off half way. An entry whose account still holds its
+// id is in the balance and is marked applied, with the balance as it is
+// now; any other entry never moved the balance and is marked failed. It
+// returns how many entries were settled.
+func SettleLedgers(ctx context.Context, db *mongo.Database, cutoff time.Time) (int, error) {
+	settled := 0
+	for _, l := range ledgers {
+		entries, accounts := db.Collection(l.entries), db.Collection(l.accounts)
+		cursor, err := entries.Find(ctx, bson.M{"status": models.EntryPending, "created_at": bson.M{"$lt": cutoff}})
+		if err != nil {
+			return settled, err
+		}
+		var pending []bson.Raw
+		if err := cursor.All(ctx, &pending); err != nil {
+			return settled, err
+		}
+
+		for _, entry := range pending {
+			entryID := entry.Lookup("entry_id").StringValue()
+			accountID := entry.Lookup(l.key).StringValue()
+
+			var account bson.Raw
+			err := accounts.FindOne(ctx, bson.M{l.key: accountID, l.pending: entryID}).Decode(&account)
+			if err != nil && err != mongo.ErrNoDocuments {
+				return settled, err
+			}
+			applied := err == nil
+
+			set := bson.M{"status": models.EntryFailed}
+			if applied {
+				set = bson.M{"status": models.EntryApplied, "balance_after": account.Lookup(l.balance...)}
+			}
+			if _, err := entries.UpdateOne(ctx, bson.M{"entry_id": entryID, "status": models.EntryPending}, bson.M{"$set": set}); err != nil {
+				return settled, err
+			}
+			if applied {
+				update := bson.M{"$pull": bson.M{l.pending: entryID}, "$inc": bson.M{"version": 1}}
+				if _, err := accounts.UpdateOne(ctx, bson.M{l.key: accountID}, update); err != nil {
+					return settled, err
+				}
+			}
+			settled++
+		}
+	}
+	return settled, nil
+}
+
+// StartLedgerSettler runs SettleLedgers every interval in the background,
+// for entries that have been pending for longer than any request runs.
+func StartLedgerSettler(db *mongo.Database, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			settled, err := SettleLedgers(ctx, db, time.Now().Add(-10*time.Minute))
+			cancel()
+			if err != nil {
+				log.Printf("settling ledger entries: %v", err)
+			}
+			if settled > 0 {
+				log.Printf("settled %d pending ledger entries", settled)
+			}
+
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,114 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"restorent-management/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ExpirePoints expires the loyalty points of members who have neither
+// earned nor redeemed any for the expiry period of the loyalty settings,
+// recording an expire entry in each member's ledger. It returns how many
+// members' points expired.
+func ExpirePoints(ctx context.Context, db *mongo.Database, now time.Time) (int, error) {
+	settings := models.DefaultLoyaltySettings()
+	err := db.Collection("loyaltySettings").FindOne(ctx, bson.M{"_id": models.LoyaltySettingsID}).Decode(&settings)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+	if settings.Expiry_days == 0 {
+		return 0, nil
+	}
+
+	customers := db.Collection("customer")
+	cutoff := now.AddDate(0, 0, -settings.Expiry_days)
+	cursor, err := customers.Find(ctx, bson.M{"loyalty.points": bson.M{"$gt": 0}, "loyalty.last_activity_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return 0, err
+	}
+	var members []models.Customer
+	if err := cursor.All(ctx, &members); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	entries := db.Collection("loyaltyEntry")
+	for _, member := range members {
+		// The entry is written first, as moves through the ledger are, and
+		// settled by SettleLedgers if this is cut off
+		entry := models.LoyaltyEntry{
+			ID:          primitive.NewObjectID(),
+			Customer_id: member.Customer_id,
+			Type:        models.LoyaltyExpire,
+			Points:      -member.Loyalty.Points,
+			Status:      models.EntryPending,
+			Created_at:  now,
+			Created_by:  "system",
+		}
+		entry.Entry_id = entry.ID.Hex()
+		if _, err := entries.InsertOne(ctx, entry); err != nil {
+			return expired, err
+		}
+
+		// Only expire the points as read, in case the member earned or
+		// redeemed some since
+		filter := bson.M{
+			"customer_id":              member.Customer_id,
+			"loyalty.points":           member.Loyalty.Points,
+			"loyalty.last_activity_at": member.Loyalty.Last_activity_at,
+		}
+		update := bson.M{
+			"$set":  bson.M{"loyalty.points": 0, "updated_at": now},
+			"$push": bson.M{"loyalty.pending_entries": entry.Entry_id},
+			"$inc":  bson.M{"version": 1},
+		}
+		result, err := customers.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return expired, err
+		}
+		status := bson.M{"status": models.EntryApplied, "balance_after": 0}
+		if result.ModifiedCount == 0 {
+			status = bson.M{"status": models.EntryFailed}
+		}
+		if _, err := entries.UpdateOne(ctx, bson.M{"entry_id": entry.Entry_id}, bson.M{"$set": status}); err != nil {
+			return expired, err
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+
+		update = bson.M{"$pull": bson.M{"loyalty.pending_entries": entry.Entry_id}, "$inc": bson.M{"version": 1}}
+		if _, err := customers.UpdateOne(ctx, bson.M{"customer_id": member.Customer_id}, update); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// StartPointsExpiry runs ExpirePoints every interval in the background.
+func StartPointsExpiry(db *mongo.Database, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			expired, err := ExpirePoints(ctx, db, time.Now())
+			cancel()
+			if err != nil {
+				log.Printf("expiring loyalty points: %v", err)
+			}
+			if expired > 0 {
+				log.Printf("expired the loyalty points of %d members", expired)
+			}
+
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,57 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PurgedCollections are the collections whose soft deleted documents are
+// purged once past the retention window. Orders, order items, invoices
+// and customers are soft deleted too but kept for good: ledgers, stock
+// movements, sales reports and other customers' merges point at them.
+var PurgedCollections = []string{"user", "menu", "food", "table", "ingredient", "recipe", "supplier", "purchaseOrder", "promotion", "coupon"}
+
+// PurgeDeleted permanently removes documents soft deleted before cutoff and
+// returns how many were removed from each collection.
+func PurgeDeleted(ctx context.Context, db *mongo.Database, cutoff time.Time) (map[string]int64, error) {
+	purged := make(map[string]int64, len(PurgedCollections))
+	filter := bson.M{"deleted_at": bson.M{"$ne": nil, "$lt": cutoff}}
+
+	for _, name := range PurgedCollections {
+		result, err := db.Collection(name).DeleteMany(ctx, filter)
+		if err != nil {
+			return purged, err
+		}
+		purged[name] = result.DeletedCount
+	}
+	return purged, nil
+}
+
+// StartPurger runs PurgeDeleted every interval in the background, removing
+// records that have been deleted for longer than retention.
+func StartPurger(db *mongo.Database, retention time.Duration, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			purged, err := PurgeDeleted(ctx, db, time.Now().Add(-retention))
+			cancel()
+			if err != nil {
+				log.Printf("purging soft deleted records: %v", err)
+			}
+			for name, count := range purged {
+				if count > 0 {
+					log.Printf("purged %d deleted %s records", count, name)
+				}
+			}
+
+			<-ticker.C
+		}
+	}()
+}
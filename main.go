@@ -1,14 +1,49 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"os"
+	"restorent-management/database"
+	"restorent-management/jobs"
 	middleware "restorent-management/middleware"
+	"restorent-management/migrations"
+	"restorent-management/models"
 	routes "restorent-management/routes"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "purge" {
+		runPurgeCommand()
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		runAdminCommand(os.Args[2:])
+		return
+	}
+
+	if os.Getenv("MIGRATE_ON_START") == "true" {
+		if err := migrate(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	jobs.StartPurger(database.OpenDatabase(database.Client), retention(), time.Hour)
+	jobs.StartPointsExpiry(database.OpenDatabase(database.Client), time.Hour)
+	jobs.StartLedgerSettler(database.OpenDatabase(database.Client), 5*time.Minute)
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -16,7 +51,11 @@ func main() {
 
 	router := gin.New()
 	router.Use(gin.Logger())
+	router.Use(middleware.ErrorHandler())
 	routes.UserRoutes(router)
+	// Images are linked from menus shown to guests, so they need no token
+	routes.ImageRoutes(router)
+	routes.GuestFeedbackRoutes(router)
 	router.Use(middleware.Authentication())
 
 	routes.FoodRoutes(router)
@@ -25,7 +64,113 @@ func main() {
 	routes.OrderRoutes(router)
 	routes.OrderItemRoutes(router)
 	routes.InvoiceRoutes(router)
+	routes.IngredientRoutes(router)
+	routes.RecipeRoutes(router)
+	routes.SupplierRoutes(router)
+	routes.PurchaseOrderRoutes(router)
+	routes.PromotionRoutes(router)
+	routes.CouponRoutes(router)
+	routes.GiftCardRoutes(router)
+	routes.CustomerRoutes(router)
+	routes.LoyaltyRoutes(router)
+	routes.FeedbackRoutes(router)
 
 	router.Run(":" + port)
 
 }
+
+func migrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	return migrations.Run(ctx, database.OpenDatabase(database.Client))
+}
+
+// runMigrateCommand handles `migrate` (apply pending migrations) and
+// `migrate status` (list every migration and whether it has run).
+func runMigrateCommand(args []string) {
+	if len(args) == 0 || args[0] == "up" {
+		if err := migrate(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("migrations are up to date")
+		return
+	}
+
+	if args[0] != "status" {
+		log.Fatalf("unknown migrate command %q, expected up or status", args[0])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	applied, err := migrations.Applied(ctx, database.OpenDatabase(database.Client))
+	if err != nil {
+		log.Fatal(err)
+	}
+	appliedAt := make(map[int]time.Time, len(applied))
+	for _, a := range applied {
+		appliedAt[a.Version] = a.Applied_at
+	}
+
+	for _, m := range migrations.All() {
+		status := "pending"
+		if at, ok := appliedAt[m.Version]; ok {
+			status = "applied " + at.Format(time.RFC3339)
+		}
+		fmt.Printf("%4d  %-50s %s\n", m.Version, m.Description, status)
+	}
+}
+
+// retention is how long soft deleted records are kept before being purged,
+// taken from SOFT_DELETE_RETENTION_DAYS and defaulting to 30 days.
+func retention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("SOFT_DELETE_RETENTION_DAYS"))
+	if err != nil || days < 1 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// runPurgeCommand handles `purge`, removing soft deleted records past the
+// retention window once instead of waiting for the background job.
+func runPurgeCommand() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	purged, err := jobs.PurgeDeleted(ctx, database.OpenDatabase(database.Client), time.Now().Add(-retention()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range jobs.PurgedCollections {
+		fmt.Printf("%-10s %d\n", name, purged[name])
+	}
+}
+
+// runAdminCommand handles `admin <email>`, letting a user manage users.
+// Only users who can manage users grant that permission over the API, so
+// the first one is set up here.
+func runAdminCommand(args []string) {
+	if len(args) != 1 {
+		log.Fatal("expected the email of the user to make an admin")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	users := database.OpenCollection(database.Client, "user")
+	// Users who were never granted anything have null permissions
+	update := bson.A{bson.M{"$set": bson.M{
+		"permissions": bson.M{"$setUnion": bson.A{bson.M{"$ifNull": bson.A{"$permissions", bson.A{}}}, bson.A{models.PermissionManageUsers}}},
+		"version":     bson.M{"$add": bson.A{"$version", 1}},
+		"updated_at":  "$$NOW",
+	}}}
+	result, err := users.UpdateOne(ctx, bson.M{"email": args[0], "deleted_at": nil}, update)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if result.MatchedCount == 0 {
+		log.Fatalf("no user has the email %q", args[0])
+	}
+	fmt.Printf("%s can now manage users\n", args[0])
+}
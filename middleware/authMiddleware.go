@@ -1,25 +1,43 @@
 package middleware
 
 import (
-	"fmt"
-	"net/http"
+	"context"
+	"restorent-management/apperrors"
 	"restorent-management/helper"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func Authentication() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		clientToken := c.Request.Header.Get("token")
 		if clientToken == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("No Authorization header provided")})
+			c.Error(apperrors.Unauthorized("No Authorization header provided"))
 			c.Abort()
 			return
 		}
 
-		claims, err := helper.ValidateToken(clientToken)
-		if err != "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		claims, msg := helper.ValidateToken(clientToken)
+		if msg != "" {
+			c.Error(apperrors.Unauthorized(msg))
+			c.Abort()
+			return
+		}
+
+		// Tokens of deleted users stop working straight away rather than
+		// when they expire, and permissions are the user's as they are now
+		// rather than when the token was issued
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		user, err := helper.ActiveUser(ctx, claims.Uid)
+		if err == mongo.ErrNoDocuments {
+			c.Error(apperrors.Unauthorized("the user this token was issued to no longer exists"))
+			c.Abort()
+			return
+		} else if err != nil {
+			c.Error(apperrors.Internal(err, "error occured while checking the token's user"))
 			c.Abort()
 			return
 		}
@@ -28,6 +46,7 @@ func Authentication() gin.HandlerFunc {
 		c.Set("first_name", claims.First_name)
 		c.Set("last_name", claims.Last_name)
 		c.Set("uid", claims.Uid)
+		c.Set("permissions", user.Permissions)
 
 		c.Next()
 	}
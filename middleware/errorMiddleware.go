@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"restorent-management/apperrors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorHandler renders the last error a handler attached with c.Error as
+// an application/problem+json response. Handlers that already wrote a
+// response are left alone.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		appErr := apperrors.From(c.Errors.Last().Err)
+		if appErr.Status >= http.StatusInternalServerError {
+			log.Printf("%s %s: %v", c.Request.Method, c.Request.URL.Path, appErr)
+		}
+
+		body, err := json.Marshal(apperrors.ToProblem(appErr, c.Request.URL.Path))
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Data(appErr.Status, apperrors.ProblemContentType, body)
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"restorent-management/apperrors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HasPermission reports whether the signed in user's token grants
+// permission. Permissions are read from the token, so a change takes
+// effect the next time the user logs in.
+func HasPermission(c *gin.Context, permission string) bool {
+	for _, granted := range c.GetStringSlice("permissions") {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission rejects requests from users without permission. It
+// must run after Authentication.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasPermission(c, permission) {
+			c.Error(apperrors.Forbidden("the " + permission + " permission is required"))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,166 @@
+package migrations
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register(Migration{
+		Version:     1,
+		Description: "move capitalised fields written by UpdateTable and UpdateUser",
+		Up:          renameStrayFields,
+	})
+	register(Migration{
+		Version:     4,
+		Description: "start document versions at 1",
+		Up:          backfillVersions,
+	})
+	register(Migration{
+		Version:     19,
+		Description: "make orders without a type dine-in",
+		Up:          backfillOrderTypes,
+	})
+	register(Migration{
+		Version:     22,
+		Description: "store the ending of gift card codes",
+		Up:          backfillGiftCardEndings,
+	})
+}
+
+// strayFields lists the keys UpdateTable and UpdateUser used to write with
+// the Go field name instead of the stored one. The capitalised value is the
+// most recent write, so it replaces the lowercase field.
+var strayFields = map[string]map[string]string{
+	"table": {
+		"Number_of_guests": "number_of_guests",
+		"Table_number":     "table_number",
+	},
+	"user": {
+		"First_name":    "first_name",
+		"Last_name":     "last_name",
+		"Password":      "password",
+		"Email":         "email",
+		"Avatar":        "avatar",
+		"Phone":         "phone",
+		"Token":         "token",
+		"Refresh_Token": "refresh_token",
+		"Created_at":    "created_at",
+		"Updated_at":    "updated_at",
+		"User_id":       "user_id",
+	},
+}
+
+func renameStrayFields(ctx context.Context, db *mongo.Database) error {
+	for name, fields := range strayFields {
+		collection := db.Collection(name)
+		for from, to := range fields {
+			filter := bson.M{from: bson.M{"$exists": true}}
+			update := bson.M{"$rename": bson.M{from: to}}
+			if _, err := collection.UpdateMany(ctx, filter, update); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeDuplicateUsers makes emails and phone numbers unique, as signup
+// only started checking them once they were indexed. Of the users sharing
+// one, the most recently updated is kept and given the others' permissions.
+// The others are deleted and lose the email or phone, so they can neither
+// sign in nor collide on the index, and every merge is logged for review.
+func mergeDuplicateUsers(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection("user")
+	for _, field := range []string{"email", "phone"} {
+		cursor, err := users.Aggregate(ctx, bson.A{
+			bson.M{"$match": bson.M{field: nonEmptyString}},
+			bson.M{"$sort": bson.M{"updated_at": -1}},
+			bson.M{"$group": bson.M{
+				"_id":   "$" + field,
+				"users": bson.M{"$push": bson.M{"user_id": "$user_id", "permissions": "$permissions"}},
+			}},
+			bson.M{"$match": bson.M{"users.1": bson.M{"$exists": true}}},
+		})
+		if err != nil {
+			return err
+		}
+		var groups []struct {
+			Value string `bson:"_id"`
+			Users []struct {
+				User_id     string
+				Permissions []string
+			}
+		}
+		if err := cursor.All(ctx, &groups); err != nil {
+			return err
+		}
+
+		for _, group := range groups {
+			keep := group.Users[0]
+			permissions := keep.Permissions
+			var merged []string
+			for _, user := range group.Users[1:] {
+				merged = append(merged, user.User_id)
+				for _, permission := range user.Permissions {
+					if !slices.Contains(permissions, permission) {
+						permissions = append(permissions, permission)
+					}
+				}
+			}
+			log.Printf("migrations: users %v share the %s %q and are merged into user %s", merged, field, group.Value, keep.User_id)
+
+			now := time.Now()
+			if _, err := users.UpdateOne(ctx, bson.M{"user_id": keep.User_id}, bson.M{"$set": bson.M{"permissions": permissions}}); err != nil {
+				return err
+			}
+			update := bson.M{"$set": bson.M{field: "", "deleted_at": now, "deleted_by": "migration", "merged_into": keep.User_id, "updated_at": now}}
+			if _, err := users.UpdateMany(ctx, bson.M{"user_id": bson.M{"$in": merged}}, update); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// versionedCollections hold documents that carry a version for optimistic
+// concurrency control.
+var versionedCollections = []string{"user", "menu", "food", "table", "order", "orderItem", "invoice"}
+
+func backfillVersions(ctx context.Context, db *mongo.Database) error {
+	for _, name := range versionedCollections {
+		filter := bson.M{"version": bson.M{"$exists": false}}
+		update := bson.M{"$set": bson.M{"version": 1}}
+		if _, err := db.Collection(name).UpdateMany(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillOrderTypes types the orders saved before takeaway and delivery
+// orders were added, which were all at a table.
+func backfillOrderTypes(ctx context.Context, db *mongo.Database) error {
+	filter := bson.M{"order_type": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"order_type": "dine_in"}}
+	_, err := db.Collection("order").UpdateMany(ctx, filter, update)
+	return err
+}
+
+// backfillGiftCardEndings stores the last four characters of each gift
+// card's code, which is shown in place of the code itself.
+func backfillGiftCardEndings(ctx context.Context, db *mongo.Database) error {
+	filter := bson.M{"card_ending": bson.M{"$exists": false}}
+	update := bson.A{bson.M{"$set": bson.M{"card_ending": bson.M{"$substrCP": bson.A{
+		"$code",
+		bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{bson.M{"$strLenCP": "$code"}, 4}}}},
+		4,
+	}}}}}
+	_, err := db.Collection("giftCard").UpdateMany(ctx, filter, update)
+	return err
+}
@@ -0,0 +1,689 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"restorent-management/search"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func init() {
+	register(Migration{
+		Version:     2,
+		Description: "create unique and compound indexes",
+		Up:          createIndexes,
+	})
+	register(Migration{
+		Version:     5,
+		Description: "index deleted_at for the purge job",
+		Up:          createDeletedAtIndexes,
+	})
+	register(Migration{
+		Version:     6,
+		Description: "index the default sort order of list endpoints",
+		Up:          createListSortIndexes,
+	})
+	register(Migration{
+		Version:     7,
+		Description: "create the food search text index",
+		Up:          createFoodTextIndex,
+	})
+	register(Migration{
+		Version:     8,
+		Description: "create inventory indexes",
+		Up:          createInventoryIndexes,
+	})
+	register(Migration{
+		Version:     9,
+		Description: "create supplier and purchase order indexes",
+		Up:          createPurchasingIndexes,
+	})
+	register(Migration{
+		Version:     10,
+		Description: "index the display order of foods in menu sections",
+		Up:          createMenuSectionIndex,
+	})
+	register(Migration{
+		Version:     11,
+		Description: "create promotion indexes",
+		Up:          createPromotionIndexes,
+	})
+	register(Migration{
+		Version:     12,
+		Description: "create coupon indexes",
+		Up:          createCouponIndexes,
+	})
+	register(Migration{
+		Version:     13,
+		Description: "create gift card indexes",
+		Up:          createGiftCardIndexes,
+	})
+	register(Migration{
+		Version:     15,
+		Description: "create customer indexes",
+		Up:          createCustomerIndexes,
+	})
+	register(Migration{
+		Version:     16,
+		Description: "create loyalty indexes",
+		Up:          createLoyaltyIndexes,
+	})
+	register(Migration{
+		Version:     18,
+		Description: "create feedback indexes",
+		Up:          createFeedbackIndexes,
+	})
+	register(Migration{
+		Version:     21,
+		Description: "index orders by type",
+		Up:          createOrderTypeIndexes,
+	})
+	register(Migration{
+		Version:     23,
+		Description: "index pending gift card ledger entries",
+		Up:          createPendingEntryIndex("giftCardEntry"),
+	})
+	register(Migration{
+		Version:     24,
+		Description: "index pending and per-invoice loyalty ledger entries",
+		Up:          createLoyaltyEntryIndexes,
+	})
+	register(Migration{
+		Version:     25,
+		Description: "index stock movements by order item",
+		Up:          createStockMovementOrderItemIndex,
+	})
+	register(Migration{
+		Version:     26,
+		Description: "let deleted customers share a phone or email with a new one",
+		Up:          rebuildCustomerContactIndexes,
+	})
+}
+
+// nonEmptyString limits a unique index to documents where the field is a
+// real value, so users created without a phone number do not collide on "".
+var nonEmptyString = bson.M{"$type": "string", "$gt": ""}
+
+// notDeletedValue limits an index to documents that are not soft deleted.
+// Partial indexes cannot filter on equality with null, but every document
+// is saved with a deleted_at, so its type tells the two apart.
+var notDeletedValue = bson.M{"$type": "null"}
+
+var collectionIndexes = map[string][]mongo.IndexModel{
+	"user": {
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetName("user_id_unique").SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("user_email_unique").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"email": nonEmptyString}),
+		},
+		{
+			Keys: bson.D{{Key: "phone", Value: 1}},
+			Options: options.Index().SetName("user_phone_unique").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"phone": nonEmptyString}),
+		},
+	},
+	"menu": {
+		{
+			Keys:    bson.D{{Key: "menu_id", Value: 1}},
+			Options: options.Index().SetName("menu_id_unique").SetUnique(true),
+		},
+	},
+	"food": {
+		{
+			Keys:    bson.D{{Key: "food_id", Value: 1}},
+			Options: options.Index().SetName("food_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "menu_id", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetName("food_menu_name"),
+		},
+	},
+	"table": {
+		{
+			Keys:    bson.D{{Key: "table_id", Value: 1}},
+			Options: options.Index().SetName("table_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "table_number", Value: 1}},
+			Options: options.Index().SetName("table_number"),
+		},
+	},
+	"order": {
+		{
+			Keys:    bson.D{{Key: "order_id", Value: 1}},
+			Options: options.Index().SetName("order_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "table_id", Value: 1}, {Key: "order_date", Value: -1}},
+			Options: options.Index().SetName("order_table_date"),
+		},
+	},
+	"orderItem": {
+		{
+			Keys:    bson.D{{Key: "order_item_id", Value: 1}},
+			Options: options.Index().SetName("order_item_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "order_id", Value: 1}, {Key: "food_id", Value: 1}},
+			Options: options.Index().SetName("order_item_order_food"),
+		},
+		{
+			Keys:    bson.D{{Key: "food_id", Value: 1}},
+			Options: options.Index().SetName("order_item_food"),
+		},
+	},
+	"invoice": {
+		{
+			Keys:    bson.D{{Key: "invoice_id", Value: 1}},
+			Options: options.Index().SetName("invoice_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "order_id", Value: 1}},
+			Options: options.Index().SetName("invoice_order"),
+		},
+		{
+			Keys:    bson.D{{Key: "payment_status", Value: 1}, {Key: "payment_due_date", Value: 1}},
+			Options: options.Index().SetName("invoice_status_due"),
+		},
+	},
+}
+
+func createIndexes(ctx context.Context, db *mongo.Database) error {
+	// Emails and phone numbers have to be unique before they can be
+	// indexed as such
+	if err := mergeDuplicateUsers(ctx, db); err != nil {
+		return err
+	}
+	for name, indexes := range collectionIndexes {
+		if err := ensureCollection(ctx, db, name); err != nil {
+			return err
+		}
+		if _, err := db.Collection(name).Indexes().CreateMany(ctx, indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var softDeleteCollections = []string{"user", "menu", "food", "table", "order", "orderItem", "invoice"}
+
+func createDeletedAtIndexes(ctx context.Context, db *mongo.Database) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+		Options: options.Index().SetName("deleted_at").SetSparse(true),
+	}
+	for _, name := range softDeleteCollections {
+		if _, err := db.Collection(name).Indexes().CreateOne(ctx, index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listSortIndexes back the default sort of each list endpoint. The _id
+// suffix matches the tie-breaker the query package adds to every sort.
+var listSortIndexes = map[string]bson.D{
+	"food":      {{Key: "name", Value: 1}, {Key: "_id", Value: 1}},
+	"menu":      {{Key: "name", Value: 1}, {Key: "_id", Value: 1}},
+	"table":     {{Key: "table_number", Value: 1}, {Key: "_id", Value: 1}},
+	"order":     {{Key: "order_date", Value: -1}, {Key: "_id", Value: 1}},
+	"orderItem": {{Key: "created_at", Value: -1}, {Key: "_id", Value: 1}},
+	"invoice":   {{Key: "created_at", Value: -1}, {Key: "_id", Value: 1}},
+	"user":      {{Key: "created_at", Value: -1}, {Key: "_id", Value: 1}},
+}
+
+func createListSortIndexes(ctx context.Context, db *mongo.Database) error {
+	for name, keys := range listSortIndexes {
+		index := mongo.IndexModel{Keys: keys, Options: options.Index().SetName("list_default_sort")}
+		if _, err := db.Collection(name).Indexes().CreateOne(ctx, index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createFoodTextIndex backs GET /foods/search. The weights must match the
+// ones search.MemoryIndex scores with.
+func createFoodTextIndex(ctx context.Context, db *mongo.Database) error {
+	index := mongo.IndexModel{
+		Keys: bson.D{{Key: "name", Value: "text"}, {Key: "tags", Value: "text"}, {Key: "description", Value: "text"}},
+		Options: options.Index().SetName("food_text").SetWeights(bson.M{
+			"name":        search.NameWeight,
+			"tags":        search.TagsWeight,
+			"description": search.DescriptionWeight,
+		}),
+	}
+	_, err := db.Collection("food").Indexes().CreateOne(ctx, index)
+	return err
+}
+
+var inventoryIndexes = map[string][]mongo.IndexModel{
+	"ingredient": {
+		{
+			Keys:    bson.D{{Key: "ingredient_id", Value: 1}},
+			Options: options.Index().SetName("ingredient_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("list_default_sort"),
+		},
+		{
+			Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+			Options: options.Index().SetName("deleted_at").SetSparse(true),
+		},
+	},
+	"recipe": {
+		{
+			Keys:    bson.D{{Key: "recipe_id", Value: 1}},
+			Options: options.Index().SetName("recipe_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "food_id", Value: 1}, {Key: "size", Value: 1}},
+			Options: options.Index().SetName("recipe_food_size"),
+		},
+		{
+			Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+			Options: options.Index().SetName("deleted_at").SetSparse(true),
+		},
+	},
+	"stockMovement": {
+		{
+			Keys:    bson.D{{Key: "ingredient_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("stock_movement_ingredient_date"),
+		},
+	},
+}
+
+func createInventoryIndexes(ctx context.Context, db *mongo.Database) error {
+	for name, indexes := range inventoryIndexes {
+		if err := ensureCollection(ctx, db, name); err != nil {
+			return err
+		}
+		if _, err := db.Collection(name).Indexes().CreateMany(ctx, indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var purchasingIndexes = map[string][]mongo.IndexModel{
+	"supplier": {
+		{
+			Keys:    bson.D{{Key: "supplier_id", Value: 1}},
+			Options: options.Index().SetName("supplier_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("list_default_sort"),
+		},
+		{
+			Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+			Options: options.Index().SetName("deleted_at").SetSparse(true),
+		},
+	},
+	"purchaseOrder": {
+		{
+			Keys:    bson.D{{Key: "purchase_order_id", Value: 1}},
+			Options: options.Index().SetName("purchase_order_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expected_delivery_date", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("list_default_sort"),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "supplier_id", Value: 1}},
+			Options: options.Index().SetName("purchase_order_status_supplier"),
+		},
+		{
+			Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+			Options: options.Index().SetName("deleted_at").SetSparse(true),
+		},
+	},
+	"orderItem": {
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}, {Key: "food_id", Value: 1}, {Key: "quantity", Value: 1}},
+			Options: options.Index().SetName("order_item_sales_by_date"),
+		},
+	},
+}
+
+func createPurchasingIndexes(ctx context.Context, db *mongo.Database) error {
+	for name, indexes := range purchasingIndexes {
+		if err := ensureCollection(ctx, db, name); err != nil {
+			return err
+		}
+		if _, err := db.Collection(name).Indexes().CreateMany(ctx, indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createMenuSectionIndex backs GET /menus/:menu_id/full, which reads a
+// menu's foods in display order.
+func createMenuSectionIndex(ctx context.Context, db *mongo.Database) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "menu_id", Value: 1}, {Key: "position", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetName("food_menu_position"),
+	}
+	_, err := db.Collection("food").Indexes().CreateOne(ctx, index)
+	return err
+}
+
+var promotionIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "promotion_id", Value: 1}},
+		Options: options.Index().SetName("promotion_id_unique").SetUnique(true),
+	},
+	{
+		Keys:    bson.D{{Key: "priority", Value: -1}, {Key: "_id", Value: 1}},
+		Options: options.Index().SetName("list_default_sort"),
+	},
+	{
+		Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+		Options: options.Index().SetName("deleted_at").SetSparse(true),
+	},
+}
+
+func createPromotionIndexes(ctx context.Context, db *mongo.Database) error {
+	if err := ensureCollection(ctx, db, "promotion"); err != nil {
+		return err
+	}
+	_, err := db.Collection("promotion").Indexes().CreateMany(ctx, promotionIndexes)
+	return err
+}
+
+var couponIndexes = map[string][]mongo.IndexModel{
+	"coupon": {
+		{
+			Keys:    bson.D{{Key: "coupon_id", Value: 1}},
+			Options: options.Index().SetName("coupon_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "code", Value: 1}},
+			Options: options.Index().SetName("coupon_code_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "code", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("list_default_sort"),
+		},
+		{
+			Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+			Options: options.Index().SetName("deleted_at").SetSparse(true),
+		},
+	},
+	"couponRedemption": {
+		{
+			Keys:    bson.D{{Key: "redemption_id", Value: 1}},
+			Options: options.Index().SetName("redemption_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "coupon_id", Value: 1}, {Key: "redeemed_at", Value: -1}},
+			Options: options.Index().SetName("redemption_coupon_date"),
+		},
+		{
+			Keys:    bson.D{{Key: "redeemed_at", Value: 1}},
+			Options: options.Index().SetName("redemption_date"),
+		},
+	},
+}
+
+func createCouponIndexes(ctx context.Context, db *mongo.Database) error {
+	for name, indexes := range couponIndexes {
+		if err := ensureCollection(ctx, db, name); err != nil {
+			return err
+		}
+		if _, err := db.Collection(name).Indexes().CreateMany(ctx, indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var giftCardIndexes = map[string][]mongo.IndexModel{
+	"giftCard": {
+		{
+			Keys:    bson.D{{Key: "gift_card_id", Value: 1}},
+			Options: options.Index().SetName("gift_card_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "code", Value: 1}},
+			Options: options.Index().SetName("gift_card_code_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("list_default_sort"),
+		},
+	},
+	"giftCardEntry": {
+		{
+			Keys:    bson.D{{Key: "entry_id", Value: 1}},
+			Options: options.Index().SetName("entry_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "gift_card_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("gift_card_entry_card_date"),
+		},
+	},
+}
+
+func createGiftCardIndexes(ctx context.Context, db *mongo.Database) error {
+	for name, indexes := range giftCardIndexes {
+		if err := ensureCollection(ctx, db, name); err != nil {
+			return err
+		}
+		if _, err := db.Collection(name).Indexes().CreateMany(ctx, indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var customerIndexes = map[string][]mongo.IndexModel{
+	"customer": {
+		{
+			Keys:    bson.D{{Key: "customer_id", Value: 1}},
+			Options: options.Index().SetName("customer_id_unique").SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "phone", Value: 1}},
+			Options: options.Index().SetName("customer_phone_unique").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"phone": nonEmptyString, "deleted_at": notDeletedValue}),
+		},
+		{
+			Keys: bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("customer_email_unique").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"email": nonEmptyString, "deleted_at": notDeletedValue}),
+		},
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("list_default_sort"),
+		},
+		{
+			Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+			Options: options.Index().SetName("deleted_at").SetSparse(true),
+		},
+	},
+	"order": {
+		{
+			Keys:    bson.D{{Key: "customer_id", Value: 1}, {Key: "order_date", Value: -1}},
+			Options: options.Index().SetName("order_customer_date").SetSparse(true),
+		},
+	},
+}
+
+func createCustomerIndexes(ctx context.Context, db *mongo.Database) error {
+	for name, indexes := range customerIndexes {
+		if err := ensureCollection(ctx, db, name); err != nil {
+			return err
+		}
+		if _, err := db.Collection(name).Indexes().CreateMany(ctx, indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var loyaltyIndexes = map[string][]mongo.IndexModel{
+	"loyaltyEntry": {
+		{
+			Keys:    bson.D{{Key: "entry_id", Value: 1}},
+			Options: options.Index().SetName("entry_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "customer_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("loyalty_entry_customer_date"),
+		},
+	},
+	"customer": {
+		{
+			Keys:    bson.D{{Key: "loyalty.last_activity_at", Value: 1}},
+			Options: options.Index().SetName("customer_loyalty_activity").SetSparse(true),
+		},
+	},
+	"invoice": {
+		{
+			Keys:    bson.D{{Key: "customer_id", Value: 1}},
+			Options: options.Index().SetName("invoice_customer").SetSparse(true),
+		},
+	},
+}
+
+func createLoyaltyIndexes(ctx context.Context, db *mongo.Database) error {
+	for name, indexes := range loyaltyIndexes {
+		if err := ensureCollection(ctx, db, name); err != nil {
+			return err
+		}
+		if _, err := db.Collection(name).Indexes().CreateMany(ctx, indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var feedbackIndexes = map[string][]mongo.IndexModel{
+	"feedback": {
+		{
+			Keys:    bson.D{{Key: "feedback_id", Value: 1}},
+			Options: options.Index().SetName("feedback_id_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "invoice_id", Value: 1}},
+			Options: options.Index().SetName("feedback_invoice_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("feedback_created_at"),
+		},
+		{
+			Keys:    bson.D{{Key: "dishes.food_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("feedback_food_date"),
+		},
+	},
+	"invoice": {
+		{
+			Keys:    bson.D{{Key: "feedback_token.token_hash", Value: 1}},
+			Options: options.Index().SetName("invoice_feedback_token").SetSparse(true),
+		},
+	},
+}
+
+func createFeedbackIndexes(ctx context.Context, db *mongo.Database) error {
+	for name, indexes := range feedbackIndexes {
+		if err := ensureCollection(ctx, db, name); err != nil {
+			return err
+		}
+		if _, err := db.Collection(name).Indexes().CreateMany(ctx, indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var orderTypeIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "order_type", Value: 1}, {Key: "order_date", Value: -1}},
+		Options: options.Index().SetName("order_type_date"),
+	},
+	{
+		Keys:    bson.D{{Key: "pickup_time", Value: 1}},
+		Options: options.Index().SetName("order_pickup_time").SetSparse(true),
+	},
+}
+
+func createOrderTypeIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("order").Indexes().CreateMany(ctx, orderTypeIndexes)
+	return err
+}
+
+// createPendingEntryIndex indexes the entries of a ledger that are still
+// pending, which are the only ones jobs.SettleLedgers looks for.
+func createPendingEntryIndex(name string) func(context.Context, *mongo.Database) error {
+	return func(ctx context.Context, db *mongo.Database) error {
+		index := mongo.IndexModel{
+			Keys: bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetName(name + "_pending").
+				SetPartialFilterExpression(bson.M{"status": "pending"}),
+		}
+		_, err := db.Collection(name).Indexes().CreateOne(ctx, index)
+		return err
+	}
+}
+
+func createLoyaltyEntryIndexes(ctx context.Context, db *mongo.Database) error {
+	if err := createPendingEntryIndex("loyaltyEntry")(ctx, db); err != nil {
+		return err
+	}
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "invoice_id", Value: 1}},
+		Options: options.Index().SetName("loyalty_entry_invoice").SetSparse(true),
+	}
+	_, err := db.Collection("loyaltyEntry").Indexes().CreateOne(ctx, index)
+	return err
+}
+
+// createStockMovementOrderItemIndex serves voids, which put back what the
+// movements of an order item took out.
+func createStockMovementOrderItemIndex(ctx context.Context, db *mongo.Database) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "order_item_id", Value: 1}},
+		Options: options.Index().SetName("stock_movement_order_item").SetSparse(true),
+	}
+	_, err := db.Collection("stockMovement").Indexes().CreateOne(ctx, index)
+	return err
+}
+
+// rebuildCustomerContactIndexes rebuilds the unique phone and email
+// indexes of databases that built them before soft deleted customers were
+// left out of them.
+func rebuildCustomerContactIndexes(ctx context.Context, db *mongo.Database) error {
+	customers := db.Collection("customer")
+	for _, index := range customerIndexes["customer"] {
+		name := *index.Options.Name
+		if name != "customer_phone_unique" && name != "customer_email_unique" {
+			continue
+		}
+		// A database that never built the index has nothing to drop
+		if _, err := customers.Indexes().DropOne(ctx, name); err != nil && !isIndexNotFound(err) {
+			return err
+		}
+		if _, err := customers.Indexes().CreateOne(ctx, index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isIndexNotFound reports whether err is the server saying there is no
+// index to drop.
+func isIndexNotFound(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(27)
+}
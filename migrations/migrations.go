@@ -0,0 +1,111 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is a single versioned change to the database. Versions are
+// applied in ascending order and each one is recorded in the migrations
+// collection once it succeeds, so Up must be safe to re-run if it fails
+// halfway through.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+}
+
+// AppliedMigration is the record stored for every migration that has run.
+type AppliedMigration struct {
+	Version     int       `bson:"_id" json:"version"`
+	Description string    `bson:"description" json:"description"`
+	Applied_at  time.Time `bson:"applied_at" json:"applied_at"`
+}
+
+const collectionName = "migrations"
+
+var registry []Migration
+
+func register(m Migration) {
+	registry = append(registry, m)
+}
+
+// All returns every known migration sorted by version.
+func All() []Migration {
+	all := make([]Migration, len(registry))
+	copy(all, registry)
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all
+}
+
+// Applied returns the migrations already recorded in the database.
+func Applied(ctx context.Context, db *mongo.Database) ([]AppliedMigration, error) {
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	cursor, err := db.Collection(collectionName).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var applied []AppliedMigration
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// Run applies every pending migration in version order and stops at the
+// first failure.
+func Run(ctx context.Context, db *mongo.Database) error {
+	applied, err := Applied(ctx, db)
+	if err != nil {
+		return fmt.Errorf("reading applied migrations: %w", err)
+	}
+
+	done := make(map[int]bool, len(applied))
+	for _, a := range applied {
+		done[a.Version] = true
+	}
+
+	for _, m := range All() {
+		if done[m.Version] {
+			continue
+		}
+
+		log.Printf("applying migration %d: %s", m.Version, m.Description)
+		if err := m.Up(ctx, db); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+
+		record := AppliedMigration{
+			Version:     m.Version,
+			Description: m.Description,
+			Applied_at:  time.Now(),
+		}
+		if _, err := db.Collection(collectionName).InsertOne(ctx, record); err != nil {
+			return fmt.Errorf("recording migration %d: %w", m.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// ensureCollection creates the named collection if it does not exist yet,
+// since collMod and index builds need it to be there.
+func ensureCollection(ctx context.Context, db *mongo.Database, name string) error {
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": name})
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		return nil
+	}
+	return db.CreateCollection(ctx, name)
+}
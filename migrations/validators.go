@@ -0,0 +1,177 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	register(Migration{
+		Version:     3,
+		Description: "add JSON schema validators",
+		Up:          applyValidators,
+	})
+	register(Migration{
+		Version:     14,
+		Description: "allow gift card payments in the invoice validator",
+		Up:          applyGiftCardInvoiceValidator,
+	})
+	register(Migration{
+		Version:     17,
+		Description: "allow refunded invoices in the invoice validator",
+		Up:          applyRefundInvoiceValidator,
+	})
+	register(Migration{
+		Version:     20,
+		Description: "add order types to the order validator",
+		Up:          applyOrderTypeValidator,
+	})
+}
+
+var (
+	stringType         = bson.M{"bsonType": "string"}
+	nullableStringType = bson.M{"bsonType": bson.A{"string", "null"}}
+	dateType           = bson.M{"bsonType": "date"}
+	nullableDateType   = bson.M{"bsonType": bson.A{"date", "null"}}
+	numberTypes        = bson.A{"double", "int", "long", "decimal"}
+	intTypes           = bson.A{"int", "long"}
+	numberType         = bson.M{"bsonType": numberTypes}
+	intType            = bson.M{"bsonType": intTypes}
+)
+
+// initialSchemas only describe the fields the handlers rely on. Extra
+// fields are allowed so that older documents and new optional fields keep
+// validating. Each validator migration applies the schema as it was when
+// the migration was written, so migrating a new database still ends with
+// the latest one.
+var initialSchemas = map[string]bson.M{
+	"user": {
+		"bsonType": "object",
+		"required": bson.A{"user_id", "email", "password"},
+		"properties": bson.M{
+			"user_id":    stringType,
+			"email":      stringType,
+			"phone":      stringType,
+			"password":   stringType,
+			"created_at": dateType,
+			"updated_at": dateType,
+		},
+	},
+	"menu": {
+		"bsonType": "object",
+		"required": bson.A{"menu_id", "name", "category"},
+		"properties": bson.M{
+			"menu_id":    stringType,
+			"name":       stringType,
+			"category":   stringType,
+			"start_date": nullableDateType,
+			"end_date":   nullableDateType,
+		},
+	},
+	"food": {
+		"bsonType": "object",
+		"required": bson.A{"food_id", "name", "price", "menu_id"},
+		"properties": bson.M{
+			"food_id": stringType,
+			"name":    stringType,
+			"price":   bson.M{"bsonType": numberTypes, "minimum": 0},
+			"menu_id": stringType,
+		},
+	},
+	"table": {
+		"bsonType": "object",
+		"required": bson.A{"table_id", "number_of_guests", "table_number"},
+		"properties": bson.M{
+			"table_id":         stringType,
+			"number_of_guests": bson.M{"bsonType": intTypes, "minimum": 0},
+			"table_number":     intType,
+		},
+	},
+	"order": orderSchema(nil),
+	"orderItem": {
+		"bsonType": "object",
+		"required": bson.A{"order_item_id", "order_id", "food_id", "quantity"},
+		"properties": bson.M{
+			"order_item_id": stringType,
+			"order_id":      stringType,
+			"food_id":       stringType,
+			"quantity":      bson.M{"enum": bson.A{"S", "M", "L"}},
+			"unit_price":    numberType,
+		},
+	},
+	"invoice": invoiceSchema(bson.A{"PENDING", "PAID"}, bson.A{"CARD", "CASH", "", nil}),
+}
+
+// orderSchema is the order schema, with the order types allowed if there
+// are any.
+func orderSchema(types bson.A) bson.M {
+	properties := bson.M{
+		"order_id":   stringType,
+		"order_date": dateType,
+		"table_id":   nullableStringType,
+	}
+	if types != nil {
+		properties["order_type"] = bson.M{"enum": types}
+	}
+	return bson.M{
+		"bsonType":   "object",
+		"required":   bson.A{"order_id", "order_date"},
+		"properties": properties,
+	}
+}
+
+// invoiceSchema is the invoice schema with the payment statuses and
+// methods allowed.
+func invoiceSchema(statuses, methods bson.A) bson.M {
+	return bson.M{
+		"bsonType": "object",
+		"required": bson.A{"invoice_id", "order_id", "payment_status"},
+		"properties": bson.M{
+			"invoice_id":     stringType,
+			"order_id":       stringType,
+			"payment_status": bson.M{"enum": statuses},
+			"payment_method": bson.M{"enum": methods},
+		},
+	}
+}
+
+func applyValidators(ctx context.Context, db *mongo.Database) error {
+	for name, schema := range initialSchemas {
+		if err := applyValidator(ctx, db, name, schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyGiftCardInvoiceValidator(ctx context.Context, db *mongo.Database) error {
+	schema := invoiceSchema(bson.A{"PENDING", "PAID"}, bson.A{"CARD", "CASH", "GIFT_CARD", "", nil})
+	return applyValidator(ctx, db, "invoice", schema)
+}
+
+func applyRefundInvoiceValidator(ctx context.Context, db *mongo.Database) error {
+	schema := invoiceSchema(bson.A{"PENDING", "PAID", "REFUNDED"}, bson.A{"CARD", "CASH", "GIFT_CARD", "", nil})
+	return applyValidator(ctx, db, "invoice", schema)
+}
+
+func applyOrderTypeValidator(ctx context.Context, db *mongo.Database) error {
+	return applyValidator(ctx, db, "order", orderSchema(bson.A{"dine_in", "takeaway", "delivery"}))
+}
+
+func applyValidator(ctx context.Context, db *mongo.Database, name string, schema bson.M) error {
+	if err := ensureCollection(ctx, db, name); err != nil {
+		return err
+	}
+
+	// "moderate" leaves existing invalid documents alone until they are
+	// next written, so the migration cannot fail on legacy data.
+	cmd := bson.D{
+		{Key: "collMod", Value: name},
+		{Key: "validator", Value: bson.M{"$jsonSchema": schema}},
+		{Key: "validationLevel", Value: "moderate"},
+		{Key: "validationAction", Value: "error"},
+	}
+	return db.RunCommand(ctx, cmd).Err()
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Allergens are the 14 allergens that EU food law requires to be declared.
+var Allergens = []string{
+	"celery", "gluten", "crustacean", "egg", "fish", "lupin", "milk",
+	"mollusc", "mustard", "tree_nut", "peanut", "sesame", "soy", "sulphite",
+}
+
+var DietaryTags = []string{
+	"vegan", "vegetarian", "pescatarian", "halal", "kosher", "gluten_free", "dairy_free", "nut_free",
+}
+
+// allergenWords maps words guests use in notes to allergen keys. Plurals
+// are handled by mentionedAllergens.
+var allergenWords = map[string]string{
+	"celery": "celery", "celeriac": "celery",
+	"gluten": "gluten", "wheat": "gluten", "barley": "gluten", "rye": "gluten", "coeliac": "gluten", "celiac": "gluten",
+	"crustacean": "crustacean", "shellfish": "crustacean", "shrimp": "crustacean", "prawn": "crustacean", "crab": "crustacean", "lobster": "crustacean",
+	"egg":   "egg",
+	"fish":  "fish",
+	"lupin": "lupin",
+	"milk":  "milk", "dairy": "milk", "lactose": "milk",
+	"mollusc": "mollusc", "squid": "mollusc", "mussel": "mollusc", "oyster": "mollusc", "clam": "mollusc", "octopus": "mollusc",
+	"mustard": "mustard",
+	"nut":     "tree_nut", "almond": "tree_nut", "walnut": "tree_nut", "cashew": "tree_nut", "hazelnut": "tree_nut", "pecan": "tree_nut", "pistachio": "tree_nut",
+	"peanut": "peanut", "groundnut": "peanut",
+	"sesame": "sesame",
+	"soy":    "soy", "soya": "soy", "tofu": "soy",
+	"sulphite": "sulphite", "sulfite": "sulphite",
+}
+
+// allergyWords mark a note as being about an allergy even when it does not
+// name a specific allergen.
+var allergyWords = []string{"allerg", "intoleran", "anaphyla", "epipen", "coeliac", "celiac"}
+
+// avoidWords make a note that names an allergen a request to leave it out,
+// as in "no nuts", rather than a request for more of it.
+var avoidWords = []string{"no", "without", "free", "avoid", "hold"}
+
+// AllergyNote reports whether a free text note mentions an allergy and
+// which allergens it names. Naming an allergen only counts as an allergy
+// when the note also asks for it to be left out.
+func AllergyNote(note string) (bool, []string) {
+	words := strings.FieldsFunc(strings.ToLower(note), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+
+	mentioned, avoid := false, false
+	var allergens []string
+	for _, word := range words {
+		for _, prefix := range allergyWords {
+			if strings.HasPrefix(word, prefix) {
+				mentioned = true
+			}
+		}
+		if containsString(avoidWords, word) {
+			avoid = true
+		}
+
+		key, ok := allergenWords[word]
+		if !ok {
+			key, ok = allergenWords[strings.TrimSuffix(word, "s")]
+		}
+		if ok && !containsString(allergens, key) {
+			allergens = append(allergens, key)
+		}
+	}
+	return mentioned || (avoid && len(allergens) > 0), allergens
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"fmt"
+	"math"
+)
+
+// FoodBundle makes a food a combo meal, such as a burger, fries and a
+// drink sold together for the food's price. Each slot is filled with one of
+// its options when the bundle is ordered.
+type FoodBundle struct {
+	Slots []BundleSlot `json:"slots" validate:"required,min=1,unique=Name,dive"`
+}
+
+type BundleSlot struct {
+	Slot_id string         `json:"slot_id"`
+	Name    string         `json:"name" validate:"required,max=100"`
+	Options []BundleOption `json:"options" validate:"required,min=1,unique=Food_id,dive"`
+}
+
+// BundleOption is a food that can fill a slot. Size fixes the size it is
+// served in; without it the food comes in the size the bundle was ordered
+// in. Price_delta is charged on top of the bundle price, for example for
+// swapping fries for onion rings.
+type BundleOption struct {
+	Food_id     string  `json:"food_id" validate:"required"`
+	Size        *string `json:"size" validate:"omitempty,oneof=S M L"`
+	Price_delta float64 `json:"price_delta" validate:"min=0"`
+}
+
+// BundleComponent is the food chosen for one slot of an ordered bundle.
+// Clients send the slot and food IDs; the rest is filled in when the item
+// is saved. Allocated_price is the component's share of the bundle's unit
+// price, which is what sales of the food are reported at.
+type BundleComponent struct {
+	Slot_id          string  `json:"slot_id" validate:"required"`
+	Slot_name        string  `json:"slot_name"`
+	Food_id          string  `json:"food_id" validate:"required"`
+	Food_name        string  `json:"food_name"`
+	Size             string  `json:"size"`
+	Price_delta      float64 `json:"price_delta"`
+	Standalone_price float64 `json:"standalone_price"`
+	Allocated_price  float64 `json:"allocated_price"`
+}
+
+// ResolveBundle checks the foods chosen for an ordered bundle against its
+// slots and returns one component per slot, in slot order. Slots with a
+// single option may be left out and are filled with it. size is the size
+// the bundle was ordered in.
+func (f Food) ResolveBundle(chosen []BundleComponent, size string) ([]BundleComponent, error) {
+	if f.Bundle == nil {
+		if len(chosen) > 0 {
+			return nil, fmt.Errorf("%s is not a bundle and takes no components", *f.Name)
+		}
+		return nil, nil
+	}
+
+	picks := make(map[string]string, len(chosen))
+	for _, pick := range chosen {
+		if _, ok := f.Bundle.slot(pick.Slot_id); !ok {
+			return nil, fmt.Errorf("slot %s is not part of %s", pick.Slot_id, *f.Name)
+		}
+		if _, ok := picks[pick.Slot_id]; ok {
+			return nil, fmt.Errorf("slot %s of %s was filled more than once", pick.Slot_id, *f.Name)
+		}
+		picks[pick.Slot_id] = pick.Food_id
+	}
+
+	components := make([]BundleComponent, 0, len(f.Bundle.Slots))
+	for _, slot := range f.Bundle.Slots {
+		foodID, ok := picks[slot.Slot_id]
+		if !ok {
+			if len(slot.Options) > 1 {
+				return nil, fmt.Errorf("%s needs a choice for %s", *f.Name, slot.Name)
+			}
+			foodID = slot.Options[0].Food_id
+		}
+
+		option, ok := slot.option(foodID)
+		if !ok {
+			return nil, fmt.Errorf("food %s is not a choice for %s in %s", foodID, slot.Name, *f.Name)
+		}
+		component := BundleComponent{
+			Slot_id:     slot.Slot_id,
+			Slot_name:   slot.Name,
+			Food_id:     option.Food_id,
+			Size:        size,
+			Price_delta: option.Price_delta,
+		}
+		if option.Size != nil {
+			component.Size = *option.Size
+		}
+		components = append(components, component)
+	}
+	return components, nil
+}
+
+func (b FoodBundle) slot(slotID string) (BundleSlot, bool) {
+	for _, slot := range b.Slots {
+		if slot.Slot_id == slotID {
+			return slot, true
+		}
+	}
+	return BundleSlot{}, false
+}
+
+func (s BundleSlot) option(foodID string) (BundleOption, bool) {
+	for _, option := range s.Options {
+		if option.Food_id == foodID {
+			return option, true
+		}
+	}
+	return BundleOption{}, false
+}
+
+// ComponentsTotal is the sum of the price deltas of the chosen components.
+func (o OrderItem) ComponentsTotal() float64 {
+	total := 0.0
+	for _, component := range o.Components {
+		total += component.Price_delta
+	}
+	return total
+}
+
+// AllocateBundlePrice splits the unit price of a bundle across its
+// components. Each component keeps its own price delta and the rest is
+// shared in proportion to what the components cost on their own, or
+// evenly when none of them has a price. The last component takes the
+// rounding difference so the shares add up to the unit price.
+func (o *OrderItem) AllocateBundlePrice() {
+	if len(o.Components) == 0 || o.Unit_price == nil {
+		return
+	}
+
+	shared := *o.Unit_price - o.ComponentsTotal()
+	standalone := 0.0
+	for _, component := range o.Components {
+		standalone += component.Standalone_price
+	}
+
+	remaining := *o.Unit_price
+	last := len(o.Components) - 1
+	for i := range o.Components {
+		component := &o.Components[i]
+		if i == last {
+			component.Allocated_price = toCents(remaining)
+			break
+		}
+
+		share := shared / float64(len(o.Components))
+		if standalone > 0 {
+			share = shared * component.Standalone_price / standalone
+		}
+		component.Allocated_price = toCents(share + component.Price_delta)
+		remaining -= component.Allocated_price
+	}
+}
+
+func toCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
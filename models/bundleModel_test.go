@@ -0,0 +1,137 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveBundle(t *testing.T) {
+	name, large := "Burger Meal", "L"
+	meal := Food{Name: &name, Bundle: &FoodBundle{Slots: []BundleSlot{
+		{Slot_id: "main", Name: "Main", Options: []BundleOption{{Food_id: "burger"}}},
+		{Slot_id: "side", Name: "Side", Options: []BundleOption{{Food_id: "fries"}, {Food_id: "rings", Price_delta: 1}}},
+		{Slot_id: "drink", Name: "Drink", Options: []BundleOption{{Food_id: "cola", Size: &large}}},
+	}}}
+	pick := func(slot, food string) BundleComponent {
+		return BundleComponent{Slot_id: slot, Food_id: food}
+	}
+
+	tests := []struct {
+		name    string
+		food    Food
+		chosen  []BundleComponent
+		want    []BundleComponent
+		wantErr bool
+	}{
+		{
+			name:   "single option slots are filled, in slot order",
+			food:   meal,
+			chosen: []BundleComponent{pick("side", "rings")},
+			want: []BundleComponent{
+				{Slot_id: "main", Slot_name: "Main", Food_id: "burger", Size: "M"},
+				{Slot_id: "side", Slot_name: "Side", Food_id: "rings", Size: "M", Price_delta: 1},
+				{Slot_id: "drink", Slot_name: "Drink", Food_id: "cola", Size: "L"},
+			},
+		},
+		{
+			name:    "a slot with a choice must be filled",
+			food:    meal,
+			chosen:  []BundleComponent{pick("main", "burger")},
+			wantErr: true,
+		},
+		{
+			name:    "a food that is not an option",
+			food:    meal,
+			chosen:  []BundleComponent{pick("side", "salad")},
+			wantErr: true,
+		},
+		{
+			name:    "an unknown slot",
+			food:    meal,
+			chosen:  []BundleComponent{pick("side", "fries"), pick("dessert", "pie")},
+			wantErr: true,
+		},
+		{
+			name:    "a slot filled twice",
+			food:    meal,
+			chosen:  []BundleComponent{pick("side", "fries"), pick("side", "rings")},
+			wantErr: true,
+		},
+		{
+			name: "a food that is not a bundle",
+			food: Food{Name: &name},
+			want: nil,
+		},
+		{
+			name:    "components for a food that is not a bundle",
+			food:    Food{Name: &name},
+			chosen:  []BundleComponent{pick("side", "fries")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.food.ResolveBundle(tt.chosen, "M")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ResolveBundle() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveBundle() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveBundle() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocateBundlePrice(t *testing.T) {
+	component := func(standalone, delta float64) BundleComponent {
+		return BundleComponent{Standalone_price: standalone, Price_delta: delta}
+	}
+
+	tests := []struct {
+		name       string
+		unitPrice  float64
+		components []BundleComponent
+		want       []float64
+	}{
+		{
+			name:       "in proportion to standalone prices",
+			unitPrice:  10,
+			components: []BundleComponent{component(8, 0), component(4, 0)},
+			want:       []float64{6.67, 3.33},
+		},
+		{
+			name:       "price deltas stay with their component",
+			unitPrice:  11,
+			components: []BundleComponent{component(5, 0), component(5, 1)},
+			want:       []float64{5, 6},
+		},
+		{
+			name:       "evenly without standalone prices",
+			unitPrice:  10,
+			components: []BundleComponent{component(0, 0), component(0, 0), component(0, 0)},
+			want:       []float64{3.33, 3.33, 3.34},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := OrderItem{Unit_price: &tt.unitPrice, Components: tt.components}
+			item.AllocateBundlePrice()
+
+			got := make([]float64, len(item.Components))
+			for i, c := range item.Components {
+				got[i] = c.Allocated_price
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AllocateBundlePrice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
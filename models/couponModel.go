@@ -0,0 +1,92 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	CouponPercent = "percent"
+	CouponAmount  = "amount"
+)
+
+// Coupon is a code a guest can redeem against an invoice before paying.
+// Used_count and Discount_total are kept by the server as coupons are
+// redeemed and reversed; Customer_uses counts the redemptions of each
+// customer for Per_customer_limit and is not sent to clients.
+type Coupon struct {
+	ID                 primitive.ObjectID `bson:"_id"`
+	Code               *string            `json:"code" validate:"required,min=3,max=32"`
+	Description        *string            `json:"description" validate:"omitempty,max=500"`
+	Discount_type      *string            `json:"discount_type" validate:"required,oneof=percent amount"`
+	Percent            *float64           `json:"percent" validate:"required_if=Discount_type percent,omitempty,gt=0,lte=100"`
+	Amount             *float64           `json:"amount" validate:"required_if=Discount_type amount,omitempty,gt=0"`
+	Max_discount       *float64           `json:"max_discount" validate:"omitempty,gt=0"`
+	Min_spend          float64            `json:"min_spend" validate:"min=0"`
+	Usage_limit        *int               `json:"usage_limit" validate:"omitempty,min=1"`
+	Per_customer_limit *int               `json:"per_customer_limit" validate:"omitempty,min=1"`
+	Starts_at          *time.Time         `json:"starts_at"`
+	Expires_at         *time.Time         `json:"expires_at"`
+	Used_count         int                `json:"used_count"`
+	Discount_total     float64            `json:"discount_total"`
+	Customer_uses      map[string]int     `json:"-"`
+	Created_at         time.Time          `json:"created_at"`
+	Updated_at         time.Time          `json:"updated_at"`
+	Coupon_id          string             `json:"coupon_id"`
+	Version            int64              `json:"version"`
+	Deleted_at         *time.Time         `json:"deleted_at"`
+	Deleted_by         *string            `json:"deleted_by"`
+}
+
+// CouponRedemption records a coupon redeemed against an invoice. A
+// redemption that is taken off the invoice again is kept with Reversed_at
+// set, and no longer counts towards the coupon's limits.
+type CouponRedemption struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Redemption_id string             `json:"redemption_id"`
+	Coupon_id     string             `json:"coupon_id"`
+	Code          string             `json:"code"`
+	Invoice_id    string             `json:"invoice_id"`
+	Order_id      string             `json:"order_id"`
+	Customer_id   *string            `json:"customer_id"`
+	Discount      float64            `json:"discount"`
+	Redeemed_at   time.Time          `json:"redeemed_at"`
+	Redeemed_by   string             `json:"redeemed_by"`
+	Reversed_at   *time.Time         `json:"reversed_at"`
+	Reversed_by   *string            `json:"reversed_by"`
+}
+
+// CheckRedeemable returns an error explaining why the coupon cannot be
+// redeemed at t against a bill of spend. Usage limits are not checked here;
+// they are enforced when the use is counted.
+func (c Coupon) CheckRedeemable(at time.Time, spend float64) error {
+	if c.Starts_at != nil && at.Before(*c.Starts_at) {
+		return fmt.Errorf("coupon %s cannot be used before %s", *c.Code, c.Starts_at.Format(time.RFC3339))
+	}
+	if c.Expires_at != nil && !at.Before(*c.Expires_at) {
+		return fmt.Errorf("coupon %s expired on %s", *c.Code, c.Expires_at.Format(time.RFC3339))
+	}
+	if spend < c.Min_spend {
+		return fmt.Errorf("coupon %s needs a spend of at least %.2f", *c.Code, c.Min_spend)
+	}
+	return nil
+}
+
+// DiscountOn is what the coupon takes off a bill of spend, never more than
+// the bill itself.
+func (c Coupon) DiscountOn(spend float64) float64 {
+	var discount float64
+	switch *c.Discount_type {
+	case CouponPercent:
+		discount = spend * *c.Percent / 100
+		if c.Max_discount != nil {
+			discount = math.Min(discount, *c.Max_discount)
+		}
+	case CouponAmount:
+		discount = *c.Amount
+	}
+	return math.Round(math.Min(discount, spend)*100) / 100
+}
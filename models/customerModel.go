@@ -0,0 +1,65 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Customer is a guest the restaurant keeps a profile for. Customers are
+// told apart by phone and email, so every customer has at least one and no
+// two customers share either. A customer merged into another is deleted,
+// with Merged_into naming the customer that was kept. Merging_from names
+// the duplicate of a merge that has not finished moving its records.
+type Customer struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Name         *string            `json:"name" validate:"required,min=1,max=100"`
+	Phone        *string            `json:"phone" validate:"required_without=Email,omitempty,e164"`
+	Email        *string            `json:"email" validate:"required_without=Phone,omitempty,email,max=254"`
+	Preferences  []string           `json:"preferences" validate:"max=20,unique,dive,min=1,max=100"`
+	Allergies    []string           `json:"allergies" validate:"unique,dive,oneof=celery gluten crustacean egg fish lupin milk mollusc mustard tree_nut peanut sesame soy sulphite"`
+	Notes        *string            `json:"notes" validate:"omitempty,max=1000"`
+	Merged_into  *string            `json:"merged_into"`
+	Merging_from *string            `json:"merging_from"`
+	Loyalty      LoyaltyAccount     `json:"loyalty"`
+	Created_at   time.Time          `json:"created_at"`
+	Updated_at   time.Time          `json:"updated_at"`
+	Customer_id  string             `json:"customer_id"`
+	Version      int64              `json:"version"`
+	Deleted_at   *time.Time         `json:"deleted_at"`
+	Deleted_by   *string            `json:"deleted_by"`
+}
+
+var phoneSeparators = regexp.MustCompile(`[\s().-]`)
+
+// NormalizePhone drops the spaces, dots, dashes and brackets people type in
+// phone numbers, so the same number is always stored the same way.
+func NormalizePhone(phone string) string {
+	return phoneSeparators.ReplaceAllString(strings.TrimSpace(phone), "")
+}
+
+// NormalizeEmail makes emails compare case-insensitively.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Normalize puts the customer's phone and email in their stored form. An
+// empty phone or email is dropped.
+func (c *Customer) Normalize() {
+	if c.Phone != nil {
+		phone := NormalizePhone(*c.Phone)
+		c.Phone = &phone
+		if phone == "" {
+			c.Phone = nil
+		}
+	}
+	if c.Email != nil {
+		email := NormalizeEmail(*c.Email)
+		c.Email = &email
+		if email == "" {
+			c.Email = nil
+		}
+	}
+}
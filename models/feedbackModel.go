@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Feedback is what a guest said about a meal, given through the one-time
+// link on the invoice's receipt.
+type Feedback struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Feedback_id    string             `json:"feedback_id"`
+	Invoice_id     string             `json:"invoice_id"`
+	Order_id       string             `json:"order_id"`
+	Customer_id    *string            `json:"customer_id"`
+	Overall_rating int                `json:"overall_rating" validate:"required,min=1,max=5"`
+	Comment        *string            `json:"comment" validate:"omitempty,max=2000"`
+	Dishes         []DishRating       `json:"dishes" validate:"max=50,dive"`
+	Created_at     time.Time          `json:"created_at"`
+}
+
+// DishRating is a guest's rating of one item of their order.
+type DishRating struct {
+	Order_item_id string  `json:"order_item_id" validate:"required"`
+	Food_id       string  `json:"food_id"`
+	Food_name     string  `json:"food_name"`
+	Rating        int     `json:"rating" validate:"required,min=1,max=5"`
+	Comment       *string `json:"comment" validate:"omitempty,max=500"`
+}
+
+// FeedbackToken is the one-time token of an invoice's feedback link. Only
+// its hash is stored, so the link cannot be rebuilt from the database.
+type FeedbackToken struct {
+	Token_hash string     `json:"-"`
+	Issued_at  time.Time  `json:"issued_at"`
+	Expires_at time.Time  `json:"expires_at"`
+	Used_at    *time.Time `json:"used_at"`
+}
+
+// FoodRating is the running total of a food's dish ratings.
+type FoodRating struct {
+	Count   int     `json:"count"`
+	Total   int     `json:"total"`
+	Average float64 `json:"average"`
+}
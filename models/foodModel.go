@@ -1,18 +1,251 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Food struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	Name       *string            `json:"name" validate:"required,min=2,max=100"`
-	Price      *float64           `json:"price" validate:"required"`
-	Food_image *string            `json:"food_image" validate:"required"`
-	Created_at time.Time          `json:"created_at"`
-	Updated_at time.Time          `json:"updated_at"`
-	Food_id    string             `json:"food_id"`
-	Menu_id    *string            `json:"menu_id" validate:"required"`
+	ID              primitive.ObjectID `bson:"_id"`
+	Name            *string            `json:"name" validate:"required,min=2,max=100"`
+	Price           *float64           `json:"price" validate:"required"`
+	Description     *string            `json:"description" validate:"omitempty,max=1000"`
+	Tags            []string           `json:"tags" validate:"dive,min=1,max=50"`
+	Food_image      *string            `json:"food_image"`
+	Image           *FoodImage         `json:"image"`
+	Variants        []FoodVariant      `json:"variants" validate:"unique=Size,dive"`
+	Allergens       []string           `json:"allergens" validate:"unique,dive,oneof=celery gluten crustacean egg fish lupin milk mollusc mustard tree_nut peanut sesame soy sulphite"`
+	Dietary_tags    []string           `json:"dietary_tags" validate:"unique,dive,oneof=vegan vegetarian pescatarian halal kosher gluten_free dairy_free nut_free"`
+	Modifier_groups []ModifierGroup    `json:"modifier_groups" validate:"dive"`
+	Bundle          *FoodBundle        `json:"bundle"`
+	Availability    *FoodAvailability  `json:"availability"`
+	Rating          FoodRating         `json:"rating"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+	Food_id         string             `json:"food_id"`
+	Menu_id         *string            `json:"menu_id" validate:"required"`
+	Section_id      *string            `json:"section_id"`
+	Position        int                `json:"position" validate:"min=0"`
+	Version         int64              `json:"version"`
+	Deleted_at      *time.Time         `json:"deleted_at"`
+	Deleted_by      *string            `json:"deleted_by"`
+}
+
+// FoodImage is an image uploaded through POST /foods/:food_id/image. The
+// original is kept as uploaded and resized copies are made from it. Food_image
+// holds the URL of the original.
+type FoodImage struct {
+	Key          string         `json:"key"`
+	Url          string         `json:"url"`
+	Content_type string         `json:"content_type"`
+	Size         int64          `json:"size"`
+	Width        int            `json:"width"`
+	Height       int            `json:"height"`
+	Variants     []ImageVariant `json:"variants"`
+	Uploaded_at  time.Time      `json:"uploaded_at"`
+	Uploaded_by  string         `json:"uploaded_by"`
+}
+
+// ImageVariant is a resized copy of an uploaded image.
+type ImageVariant struct {
+	Name         string `json:"name"`
+	Key          string `json:"key"`
+	Url          string `json:"url"`
+	Content_type string `json:"content_type"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// Keys lists the stored objects of the image and its variants.
+func (i FoodImage) Keys() []string {
+	keys := []string{i.Key}
+	for _, variant := range i.Variants {
+		keys = append(keys, variant.Key)
+	}
+	return keys
+}
+
+const (
+	AvailabilityAvailable = "available"
+	AvailabilitySoldOut   = "sold_out"
+	AvailabilityLimited   = "limited"
+)
+
+// FoodAvailability is the kitchen's 86 state for a food. A sold out food
+// comes back by itself at Sold_out_until, or stays sold out until changed
+// when that is not set. A limited food can be ordered until Remaining
+// portions have been sold. Foods without an availability are available.
+type FoodAvailability struct {
+	Status         string     `json:"status" validate:"required,oneof=available sold_out limited"`
+	Sold_out_until *time.Time `json:"sold_out_until"`
+	Remaining      *int       `json:"remaining" validate:"required_if=Status limited,omitempty,min=0"`
+	Updated_at     time.Time  `json:"updated_at"`
+	Updated_by     string     `json:"updated_by"`
+}
+
+// CheckAvailable returns an error explaining why count portions of the
+// food cannot be ordered at t.
+func (f Food) CheckAvailable(at time.Time, count int) error {
+	if f.Availability == nil {
+		return nil
+	}
+
+	switch f.Availability.Status {
+	case AvailabilitySoldOut:
+		until := f.Availability.Sold_out_until
+		if until == nil {
+			return fmt.Errorf("%s is sold out", *f.Name)
+		}
+		if at.Before(*until) {
+			return fmt.Errorf("%s is sold out until %s", *f.Name, until.Format(time.RFC3339))
+		}
+	case AvailabilityLimited:
+		remaining := 0
+		if f.Availability.Remaining != nil {
+			remaining = *f.Availability.Remaining
+		}
+		if remaining == 0 {
+			return fmt.Errorf("%s is sold out", *f.Name)
+		}
+		if remaining < count {
+			return fmt.Errorf("only %d portion(s) of %s are left", remaining, *f.Name)
+		}
+	}
+	return nil
+}
+
+// IsOrderableAt reports whether at least one portion of the food, in at
+// least one size, can be ordered at t.
+func (f Food) IsOrderableAt(at time.Time) bool {
+	_, ok := f.OrderableVariants()
+	return ok && f.CheckAvailable(at, 1) == nil
+}
+
+// FoodVariant prices one size of a food. A variant with Available set to
+// false stays on the food but cannot be ordered; leaving it unset means the
+// variant is available.
+type FoodVariant struct {
+	Size      string   `json:"size" validate:"required,eq=S|eq=M|eq=L"`
+	Price     *float64 `json:"price" validate:"required,min=0"`
+	Available *bool    `json:"available"`
+}
+
+func (v FoodVariant) IsAvailable() bool {
+	return v.Available == nil || *v.Available
+}
+
+// PriceFor returns the price of the food in the given size. Foods without
+// variants are sold at their base price in every size.
+func (f Food) PriceFor(size string) (float64, error) {
+	if len(f.Variants) == 0 {
+		return *f.Price, nil
+	}
+	for _, variant := range f.Variants {
+		if variant.Size != size {
+			continue
+		}
+		if !variant.IsAvailable() {
+			return 0, fmt.Errorf("%s is not available in size %s", *f.Name, size)
+		}
+		return *variant.Price, nil
+	}
+	return 0, fmt.Errorf("%s is not offered in size %s", *f.Name, size)
+}
+
+// OrderableVariants leaves out the variants that cannot be ordered. It
+// reports false when the food has variants but none of them is available.
+func (f Food) OrderableVariants() (Food, bool) {
+	if len(f.Variants) == 0 {
+		return f, true
+	}
+	available := make([]FoodVariant, 0, len(f.Variants))
+	for _, variant := range f.Variants {
+		if variant.IsAvailable() {
+			available = append(available, variant)
+		}
+	}
+	f.Variants = available
+	return f, len(available) > 0
+}
+
+// ModifierGroup is a set of options a guest can pick for a food, such as
+// "Choice of side" or "Extras". A Max_select of 0 means no upper limit.
+type ModifierGroup struct {
+	Group_id   string           `json:"group_id"`
+	Name       string           `json:"name" validate:"required"`
+	Required   bool             `json:"required"`
+	Min_select int              `json:"min_select" validate:"min=0"`
+	Max_select int              `json:"max_select" validate:"min=0"`
+	Options    []ModifierOption `json:"options" validate:"required,min=1,dive"`
+}
+
+type ModifierOption struct {
+	Option_id   string  `json:"option_id"`
+	Name        string  `json:"name" validate:"required"`
+	Price_delta float64 `json:"price_delta"`
+}
+
+// MinSelections is the fewest options a guest must pick from the group.
+func (g ModifierGroup) MinSelections() int {
+	if g.Required && g.Min_select < 1 {
+		return 1
+	}
+	return g.Min_select
+}
+
+// ResolveModifiers checks the options picked for an order item against the
+// food's modifier groups and returns them with names and price deltas
+// copied from the food, so later menu changes do not alter the order.
+func (f Food) ResolveModifiers(selected []SelectedModifier) ([]SelectedModifier, error) {
+	counts := make(map[string]int, len(f.Modifier_groups))
+	seen := make(map[string]bool, len(selected))
+	resolved := make([]SelectedModifier, 0, len(selected))
+
+	for _, pick := range selected {
+		group, option, ok := f.findModifier(pick.Group_id, pick.Option_id)
+		if !ok {
+			return nil, fmt.Errorf("modifier %s/%s is not offered for %s", pick.Group_id, pick.Option_id, *f.Name)
+		}
+		if seen[pick.Group_id+"/"+pick.Option_id] {
+			return nil, fmt.Errorf("%s was selected more than once", option.Name)
+		}
+		seen[pick.Group_id+"/"+pick.Option_id] = true
+		counts[group.Group_id]++
+
+		resolved = append(resolved, SelectedModifier{
+			Group_id:    group.Group_id,
+			Group_name:  group.Name,
+			Option_id:   option.Option_id,
+			Name:        option.Name,
+			Price_delta: option.Price_delta,
+		})
+	}
+
+	for _, group := range f.Modifier_groups {
+		count := counts[group.Group_id]
+		if min := group.MinSelections(); count < min {
+			return nil, fmt.Errorf("%s needs at least %d selection(s) for %s", *f.Name, min, group.Name)
+		}
+		if group.Max_select > 0 && count > group.Max_select {
+			return nil, fmt.Errorf("%s allows at most %d selection(s) for %s", *f.Name, group.Max_select, group.Name)
+		}
+	}
+
+	return resolved, nil
+}
+
+func (f Food) findModifier(groupID string, optionID string) (ModifierGroup, ModifierOption, bool) {
+	for _, group := range f.Modifier_groups {
+		if group.Group_id != groupID {
+			continue
+		}
+		for _, option := range group.Options {
+			if option.Option_id == optionID {
+				return group, option, true
+			}
+		}
+	}
+	return ModifierGroup{}, ModifierOption{}, false
 }
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveModifiers(t *testing.T) {
+	name := "Burger"
+	burger := Food{Name: &name, Modifier_groups: []ModifierGroup{
+		{Group_id: "side", Name: "Choice of side", Required: true, Max_select: 1, Options: []ModifierOption{
+			{Option_id: "fries", Name: "Fries"},
+			{Option_id: "salad", Name: "Salad", Price_delta: 1},
+		}},
+		{Group_id: "extras", Name: "Extras", Max_select: 2, Options: []ModifierOption{
+			{Option_id: "cheese", Name: "Cheese", Price_delta: 0.5},
+			{Option_id: "bacon", Name: "Bacon", Price_delta: 1.5},
+			{Option_id: "egg", Name: "Egg", Price_delta: 1},
+		}},
+	}}
+	pick := func(group, option string) SelectedModifier {
+		return SelectedModifier{Group_id: group, Option_id: option, Name: "sent by the client", Price_delta: 99}
+	}
+
+	tests := []struct {
+		name     string
+		selected []SelectedModifier
+		want     []SelectedModifier
+		wantErr  bool
+	}{
+		{
+			name:     "names and prices come from the food",
+			selected: []SelectedModifier{pick("side", "salad"), pick("extras", "bacon")},
+			want: []SelectedModifier{
+				{Group_id: "side", Group_name: "Choice of side", Option_id: "salad", Name: "Salad", Price_delta: 1},
+				{Group_id: "extras", Group_name: "Extras", Option_id: "bacon", Name: "Bacon", Price_delta: 1.5},
+			},
+		},
+		{
+			name:     "optional groups may be left out",
+			selected: []SelectedModifier{pick("side", "fries")},
+			want:     []SelectedModifier{{Group_id: "side", Group_name: "Choice of side", Option_id: "fries", Name: "Fries"}},
+		},
+		{
+			name:     "a required group must be picked",
+			selected: []SelectedModifier{pick("extras", "cheese")},
+			wantErr:  true,
+		},
+		{
+			name:     "no more than the maximum",
+			selected: []SelectedModifier{pick("side", "fries"), pick("extras", "cheese"), pick("extras", "bacon"), pick("extras", "egg")},
+			wantErr:  true,
+		},
+		{
+			name:     "the same option twice",
+			selected: []SelectedModifier{pick("side", "fries"), pick("extras", "cheese"), pick("extras", "cheese")},
+			wantErr:  true,
+		},
+		{
+			name:     "an option from another group",
+			selected: []SelectedModifier{pick("side", "cheese")},
+			wantErr:  true,
+		},
+		{
+			name:     "an unknown group",
+			selected: []SelectedModifier{pick("side", "fries"), pick("sauce", "ketchup")},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := burger.ResolveModifiers(tt.selected)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ResolveModifiers() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveModifiers() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveModifiers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinSelections(t *testing.T) {
+	tests := []struct {
+		name  string
+		group ModifierGroup
+		want  int
+	}{
+		{"optional", ModifierGroup{}, 0},
+		{"required needs one", ModifierGroup{Required: true}, 1},
+		{"minimum wins over required", ModifierGroup{Required: true, Min_select: 2}, 2},
+		{"minimum without required", ModifierGroup{Min_select: 2}, 2},
+	}
+
+	for _, tt := range tests {
+		if got := tt.group.MinSelections(); got != tt.want {
+			t.Errorf("%s: MinSelections() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
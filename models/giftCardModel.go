@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	GiftCardIssue  = "issue"
+	GiftCardTopUp  = "top_up"
+	GiftCardRedeem = "redeem"
+	GiftCardRefund = "refund"
+)
+
+// GiftCard is a stored-value card. Its balance only changes through the
+// ledger, one GiftCardEntry per change, and cannot go below zero. The code
+// is only shown once, when the card is issued; after that the card is told
+// apart by its ending. Pending_entries are the entries already in the
+// balance that are not marked applied yet.
+type GiftCard struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Code            string             `json:"-"`
+	Card_ending     string             `json:"card_ending"`
+	Initial_balance *float64           `json:"initial_balance" validate:"required,gt=0,lte=10000"`
+	Balance         float64            `json:"balance"`
+	Customer_id     *string            `json:"customer_id" validate:"omitempty,alphanum,max=64"`
+	Expires_at      *time.Time         `json:"expires_at"`
+	Disabled        bool               `json:"disabled"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+	Gift_card_id    string             `json:"gift_card_id"`
+	Version         int64              `json:"version"`
+	Pending_entries []string           `bson:"pending_entries,omitempty" json:"-"`
+}
+
+// GiftCardEntry is one change to a gift card's balance. Entries are only
+// ever appended: a payment that is given back is a refund entry, not a
+// removed redeem entry. Amount is negative when the balance goes down.
+type GiftCardEntry struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Entry_id      string             `json:"entry_id"`
+	Gift_card_id  string             `json:"gift_card_id"`
+	Type          string             `json:"type"`
+	Amount        float64            `json:"amount"`
+	Balance_after float64            `json:"balance_after"`
+	Invoice_id    *string            `json:"invoice_id"`
+	Status        string             `json:"status"`
+	Created_at    time.Time          `json:"created_at"`
+	Created_by    string             `json:"created_by"`
+}
+
+// UsableAt reports whether the card can be paid with at t.
+func (g GiftCard) UsableAt(at time.Time) bool {
+	return !g.Disabled && (g.Expires_at == nil || at.Before(*g.Expires_at))
+}
+
+// Ending is the last characters of the card's code, enough for staff and
+// guests to tell cards apart without being able to spend them.
+func (g GiftCard) Ending() string {
+	if len(g.Code) <= 4 {
+		return g.Code
+	}
+	return g.Code[len(g.Code)-4:]
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ingredient is a stock item used by recipes. Stock is kept in Unit and is
+// only changed by sales, voids, deliveries and adjustments, never by a plain
+// update. Stock is reordered up to Par_level from the preferred supplier.
+type Ingredient struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Name            *string            `json:"name" validate:"required,min=2,max=100"`
+	Unit            *string            `json:"unit" validate:"required,oneof=g kg ml l pcs"`
+	Stock           float64            `json:"stock"`
+	Low_stock_level float64            `json:"low_stock_level" validate:"min=0"`
+	Unit_cost       float64            `json:"unit_cost" validate:"min=0"`
+	Par_level       float64            `json:"par_level" validate:"min=0"`
+	Supplier_id     *string            `json:"supplier_id"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+	Ingredient_id   string             `json:"ingredient_id"`
+	Version         int64              `json:"version"`
+	Deleted_at      *time.Time         `json:"deleted_at"`
+	Deleted_by      *string            `json:"deleted_by"`
+}
+
+const (
+	StockSale       = "sale"
+	StockVoid       = "void"
+	StockAdjustment = "adjustment"
+	StockReceipt    = "receipt"
+)
+
+// StockMovement records one change to an ingredient's stock.
+type StockMovement struct {
+	ID                primitive.ObjectID `bson:"_id"`
+	Ingredient_id     string             `json:"ingredient_id"`
+	Change            float64            `json:"change"`
+	Reason            string             `json:"reason"`
+	Order_item_id     *string            `json:"order_item_id"`
+	Purchase_order_id *string            `json:"purchase_order_id"`
+	Note              *string            `json:"note"`
+	Unit_cost         float64            `json:"unit_cost"`
+	Created_at        time.Time          `json:"created_at"`
+	Created_by        string             `json:"created_by"`
+}
@@ -7,12 +7,50 @@ import (
 )
 
 type Invoice struct {
-	ID               primitive.ObjectID `bson:"_id"`
-	Invoice_id       string             `json:"invoice_id"`
-	Order_id         string             `json:"order_id"`
-	Payment_method   *string            `json:"payment_method" validate:"eq=CARD|eq=CASH|eq="`
-	Payment_status   *string            `json:"payment_status" validate:"required,eq=PENDING|eq=PAID"`
-	Payment_due_date time.Time          `json:"Payment_due_date"`
-	Created_at       time.Time          `json:"created_at"`
-	Updated_at       time.Time          `json:"updated_at"`
+	ID                 primitive.ObjectID `bson:"_id"`
+	Invoice_id         string             `json:"invoice_id"`
+	Order_id           string             `json:"order_id"`
+	Payment_method     *string            `json:"payment_method" validate:"eq=CARD|eq=CASH|eq=GIFT_CARD|eq="`
+	Payment_status     *string            `json:"payment_status" validate:"required,eq=PENDING|eq=PAID|eq=REFUNDED"`
+	Payment_due_date   time.Time          `json:"Payment_due_date"`
+	Coupon             *InvoiceCoupon     `json:"coupon"`
+	Gift_cards         []InvoiceGiftCard  `json:"gift_cards"`
+	Customer_id        *string            `json:"customer_id"`
+	Points_redeemed    *InvoicePoints     `json:"points_redeemed"`
+	Points_earned      int                `json:"points_earned"`
+	Feedback_token     *FeedbackToken     `json:"feedback_token"`
+	Order_discounts    []AppliedDiscount  `json:"order_discounts"`
+	Discounts_fixed_at *time.Time         `json:"discounts_fixed_at"`
+	Created_at         time.Time          `json:"created_at"`
+	Updated_at         time.Time          `json:"updated_at"`
+	Version            int64              `json:"version"`
+	Deleted_at         *time.Time         `json:"deleted_at"`
+	Deleted_by         *string            `json:"deleted_by"`
+}
+
+// InvoiceCoupon is the coupon redeemed against an invoice and the discount
+// it gave at the time.
+type InvoiceCoupon struct {
+	Coupon_id     string  `json:"coupon_id"`
+	Code          string  `json:"code"`
+	Redemption_id string  `json:"redemption_id"`
+	Discount      float64 `json:"discount"`
+}
+
+// InvoiceGiftCard is an amount paid on an invoice from a gift card, and the
+// ledger entry that took it off the card. Only the end of the card's code
+// is kept, as the code is all it takes to spend the card.
+type InvoiceGiftCard struct {
+	Gift_card_id string    `json:"gift_card_id"`
+	Card_ending  string    `json:"card_ending"`
+	Entry_id     string    `json:"entry_id"`
+	Amount       float64   `json:"amount"`
+	Paid_at      time.Time `json:"paid_at"`
+}
+
+// InvoicePoints are loyalty points redeemed as a discount on an invoice.
+type InvoicePoints struct {
+	Points   int     `json:"points"`
+	Discount float64 `json:"discount"`
+	Entry_id string  `json:"entry_id"`
 }
@@ -0,0 +1,12 @@
+package models
+
+// Status of a gift card or loyalty ledger entry. An entry is written as
+// pending before the balance it belongs to moves, and is applied once it
+// has; entries written before statuses existed have none and count as
+// applied. A pending entry whose balance never moved is failed, and is
+// left out of the ledger.
+const (
+	EntryPending = "pending"
+	EntryApplied = "applied"
+	EntryFailed  = "failed"
+)
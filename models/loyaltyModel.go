@@ -0,0 +1,105 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	LoyaltyEarn           = "earn"
+	LoyaltyRedeem         = "redeem"
+	LoyaltyRedeemReversal = "redeem_reversal"
+	LoyaltyClawback       = "clawback"
+	LoyaltyExpire         = "expire"
+)
+
+// LoyaltySettingsID is the _id of the one loyalty settings document.
+const LoyaltySettingsID = "loyalty"
+
+// LoyaltySettings configure the loyalty program. Members earn Earn_rate
+// points per unit spent, times the multiplier of their tier, and redeem
+// points at Point_value each. Points expire once a member has neither
+// earned nor redeemed any for Expiry_days; 0 means they never expire.
+type LoyaltySettings struct {
+	ID                string        `bson:"_id" json:"-"`
+	Earn_rate         float64       `json:"earn_rate" validate:"gte=0"`
+	Point_value       float64       `json:"point_value" validate:"gt=0"`
+	Min_redeem_points int           `json:"min_redeem_points" validate:"min=0"`
+	Expiry_days       int           `json:"expiry_days" validate:"min=0"`
+	Tiers             []LoyaltyTier `json:"tiers" validate:"max=10,dive"`
+	Updated_at        time.Time     `json:"updated_at"`
+	Updated_by        string        `json:"updated_by"`
+	Version           int64         `json:"version"`
+}
+
+// LoyaltyTier is reached once a member has earned Min_points in total.
+type LoyaltyTier struct {
+	Name       string  `json:"name" validate:"required,max=50"`
+	Min_points int     `json:"min_points" validate:"min=0"`
+	Multiplier float64 `json:"multiplier" validate:"gt=0,lte=10"`
+}
+
+// DefaultLoyaltySettings apply until the settings are first saved.
+func DefaultLoyaltySettings() LoyaltySettings {
+	return LoyaltySettings{
+		ID:          LoyaltySettingsID,
+		Earn_rate:   1,
+		Point_value: 0.01,
+		Expiry_days: 365,
+		Tiers:       []LoyaltyTier{{Name: "Member", Min_points: 0, Multiplier: 1}},
+	}
+}
+
+// SortTiers puts the tiers in the order they are reached.
+func (s *LoyaltySettings) SortTiers() {
+	sort.SliceStable(s.Tiers, func(i, j int) bool { return s.Tiers[i].Min_points < s.Tiers[j].Min_points })
+}
+
+// TierFor returns the highest tier reached with lifetime points. Members
+// below every tier earn at a multiplier of 1.
+func (s LoyaltySettings) TierFor(lifetime int) LoyaltyTier {
+	tier := LoyaltyTier{Multiplier: 1}
+	for _, t := range s.Tiers {
+		if lifetime >= t.Min_points && t.Min_points >= tier.Min_points {
+			tier = t
+		}
+	}
+	return tier
+}
+
+// PointsFor is what spending amount earns in tier. Part points are not
+// given.
+func (s LoyaltySettings) PointsFor(amount float64, tier LoyaltyTier) int {
+	return int(math.Floor(amount*s.Earn_rate*tier.Multiplier + 1e-9))
+}
+
+// LoyaltyAccount is a customer's standing in the loyalty program. Points
+// can go below zero when points already spent are clawed back by a
+// refund; the member then earns them back before redeeming again.
+// Pending_entries are the entries already in the points that are not
+// marked applied yet.
+type LoyaltyAccount struct {
+	Points           int        `json:"points"`
+	Lifetime_points  int        `json:"lifetime_points"`
+	Last_activity_at *time.Time `json:"last_activity_at"`
+	Pending_entries  []string   `bson:"pending_entries,omitempty" json:"-"`
+}
+
+// LoyaltyEntry is one change to a member's points. Entries are only ever
+// appended, so the ledger of a customer adds up to their balance.
+type LoyaltyEntry struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Entry_id      string             `json:"entry_id"`
+	Customer_id   string             `json:"customer_id"`
+	Type          string             `json:"type"`
+	Points        int                `json:"points"`
+	Balance_after int                `json:"balance_after"`
+	Invoice_id    *string            `json:"invoice_id"`
+	Tier          string             `json:"tier,omitempty"`
+	Status        string             `json:"status"`
+	Created_at    time.Time          `json:"created_at"`
+	Created_by    string             `json:"created_by"`
+}
@@ -12,7 +12,108 @@ type Menu struct {
 	Category   string             `json:"category" validate:"required"`
 	Start_Date *time.Time         `json:"start_date"`
 	End_Date   *time.Time         `json:"end_date"`
+	Schedules  []MenuSchedule     `json:"schedules" validate:"dive"`
+	Categories []MenuCategory     `json:"categories" validate:"unique=Name,dive"`
 	Created_at time.Time          `json:"created_at"`
 	Updated_at time.Time          `json:"updated_at"`
 	Menu_id    string             `json:"food_id"`
+	Version    int64              `json:"version"`
+	Deleted_at *time.Time         `json:"deleted_at"`
+	Deleted_by *string            `json:"deleted_by"`
+}
+
+// MenuSchedule is a recurring serving window, such as breakfast from 07:00
+// to 11:00 on weekdays. Times are wall clock times in the restaurant's
+// time zone.
+type MenuSchedule struct {
+	Days       []string `json:"days" validate:"required,min=1,dive,oneof=MON TUE WED THU FRI SAT SUN"`
+	Start_time string   `json:"start_time" validate:"required,datetime=15:04"`
+	End_time   string   `json:"end_time" validate:"required,datetime=15:04"`
+}
+
+// MenuCategory groups the sections of a menu, such as Starters or Drinks.
+// Categories and their sections are shown in Position order.
+type MenuCategory struct {
+	Category_id string        `json:"category_id"`
+	Name        string        `json:"name" validate:"required,max=100"`
+	Position    int           `json:"position" validate:"min=0"`
+	Sections    []MenuSection `json:"sections" validate:"unique=Name,dive"`
+}
+
+// MenuSection is a heading within a category that foods are placed under.
+type MenuSection struct {
+	Section_id  string  `json:"section_id"`
+	Name        string  `json:"name" validate:"required,max=100"`
+	Description *string `json:"description" validate:"omitempty,max=500"`
+	Position    int     `json:"position" validate:"min=0"`
+}
+
+// HasSection reports whether one of the menu's categories has the section.
+func (m Menu) HasSection(sectionID string) bool {
+	for _, category := range m.Categories {
+		for _, section := range category.Sections {
+			if section.Section_id == sectionID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CategoriesOf lists the categories a food on the menu belongs to: the
+// menu's own category and the category of the food's section.
+func (m Menu) CategoriesOf(food Food) []string {
+	categories := []string{m.Category}
+	if food.Section_id == nil {
+		return categories
+	}
+	for _, category := range m.Categories {
+		for _, section := range category.Sections {
+			if section.Section_id == *food.Section_id {
+				return append(categories, category.Name)
+			}
+		}
+	}
+	return categories
+}
+
+var weekdayCodes = [...]string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+// Includes reports whether the schedule serves at t, which must already be
+// in the restaurant's time zone.
+func (s MenuSchedule) Includes(t time.Time) bool {
+	day := weekdayCodes[t.Weekday()]
+	served := false
+	for _, d := range s.Days {
+		if d == day {
+			served = true
+			break
+		}
+	}
+	if !served {
+		return false
+	}
+
+	clock := t.Format("15:04")
+	return clock >= s.Start_time && clock < s.End_time
+}
+
+// IsActiveAt reports whether the menu is served at t: inside its date range
+// and, when it has schedules, inside one of them.
+func (m Menu) IsActiveAt(t time.Time) bool {
+	if m.Start_Date != nil && t.Before(*m.Start_Date) {
+		return false
+	}
+	if m.End_Date != nil && !t.Before(*m.End_Date) {
+		return false
+	}
+	if len(m.Schedules) == 0 {
+		return true
+	}
+	for _, schedule := range m.Schedules {
+		if schedule.Includes(t) {
+			return true
+		}
+	}
+	return false
 }
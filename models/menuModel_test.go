@@ -0,0 +1,65 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMenuScheduleIncludes(t *testing.T) {
+	breakfast := MenuSchedule{Days: []string{"MON", "TUE", "WED", "THU", "FRI"}, Start_time: "07:00", End_time: "11:00"}
+	at := func(day, hour, min int) time.Time { // March 2024 starts on a Friday
+		return time.Date(2024, 3, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"start is included", at(4, 7, 0), true},
+		{"inside the window", at(6, 9, 30), true},
+		{"end is excluded", at(4, 11, 0), false},
+		{"before the window", at(4, 6, 59), false},
+		{"sunday is not served", at(3, 9, 0), false},
+		{"saturday is not served", at(2, 9, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := breakfast.Includes(tt.at); got != tt.want {
+				t.Errorf("Includes(%v) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMenuIsActiveAt(t *testing.T) {
+	monday := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	before := monday.Add(-24 * time.Hour)
+	after := monday.Add(24 * time.Hour)
+	lunch := []MenuSchedule{{Days: []string{"MON"}, Start_time: "11:30", End_time: "14:30"}}
+	dinner := []MenuSchedule{{Days: []string{"MON"}, Start_time: "18:00", End_time: "22:00"}}
+
+	tests := []struct {
+		name string
+		menu Menu
+		want bool
+	}{
+		{"no range or schedules", Menu{}, true},
+		{"inside the date range", Menu{Start_Date: &before, End_Date: &after}, true},
+		{"before the start date", Menu{Start_Date: &after}, false},
+		{"end date is excluded", Menu{End_Date: &monday}, false},
+		{"inside a schedule", Menu{Schedules: lunch}, true},
+		{"outside every schedule", Menu{Schedules: dinner}, false},
+		{"any schedule will do", Menu{Schedules: append(dinner, lunch...)}, true},
+		{"schedules only apply inside the range", Menu{End_Date: &before, Schedules: lunch}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.menu.IsActiveAt(monday); got != tt.want {
+				t.Errorf("IsActiveAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
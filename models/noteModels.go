@@ -13,4 +13,7 @@ type Note struct {
 	Created_at time.Time          `json:"created_at"`
 	Updated_at time.Time          `json:"updated_at"`
 	Note_id    string             `json:"note_id"`
+	Version    int64              `json:"version"`
+	Deleted_at *time.Time         `json:"deleted_at"`
+	Deleted_by *string            `json:"deleted_by"`
 }
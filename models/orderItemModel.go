@@ -7,12 +7,57 @@ import (
 )
 
 type OrderItem struct {
-	ID            primitive.ObjectID `bson:"_id"`
-	Quantity      *string            `json:"quantity" validate:"required,eq=S|eq=M|eq=L"`
-	Unit_price    *float64           `json:"unit_price" validate:"required"`
-	Created_at    time.Time          `json:"created_at"`
-	Updated_at    time.Time          `json:"updated_at"`
-	Food_id       *string            `json:"food_id" validate:"required"`
-	Order_item_id string             `json:"order_item_id"`
-	Order_id      string             `json:"order_id" validate:"required"`
+	ID             primitive.ObjectID `bson:"_id"`
+	Quantity       *string            `json:"quantity" validate:"required,eq=S|eq=M|eq=L"`
+	Unit_price     *float64           `json:"unit_price"`
+	Price_override *PriceOverride     `json:"price_override"`
+	Discounts      []AppliedDiscount  `json:"discounts"`
+	Modifiers      []SelectedModifier `json:"modifiers" validate:"dive"`
+	Components     []BundleComponent  `json:"components" validate:"dive"`
+	Note           *string            `json:"note" validate:"omitempty,max=500"`
+	Created_at     time.Time          `json:"created_at"`
+	Updated_at     time.Time          `json:"updated_at"`
+	Food_id        *string            `json:"food_id" validate:"required"`
+	Order_item_id  string             `json:"order_item_id"`
+	Order_id       string             `json:"order_id" validate:"required"`
+	Version        int64              `json:"version"`
+	Deleted_at     *time.Time         `json:"deleted_at"`
+	Deleted_by     *string            `json:"deleted_by"`
+}
+
+// SelectedModifier is an option picked for an order item. Clients send the
+// group and option IDs; the names and price delta are filled in from the
+// food when the item is saved.
+type SelectedModifier struct {
+	Group_id    string  `json:"group_id" validate:"required"`
+	Group_name  string  `json:"group_name"`
+	Option_id   string  `json:"option_id" validate:"required"`
+	Name        string  `json:"name"`
+	Price_delta float64 `json:"price_delta"`
+}
+
+// PriceOverride records that a user charged something other than the
+// catalog price for an order item.
+type PriceOverride struct {
+	Catalog_price float64   `json:"catalog_price"`
+	Overridden_by string    `json:"overridden_by"`
+	Overridden_at time.Time `json:"overridden_at"`
+}
+
+// ModifiersTotal is the sum of the price deltas of the selected modifiers.
+func (o OrderItem) ModifiersTotal() float64 {
+	total := 0.0
+	for _, modifier := range o.Modifiers {
+		total += modifier.Price_delta
+	}
+	return total
+}
+
+// DiscountsTotal is the sum of the discounts applied to an order item.
+func (o OrderItem) DiscountsTotal() float64 {
+	total := 0.0
+	for _, discount := range o.Discounts {
+		total += discount.Amount
+	}
+	return total
 }
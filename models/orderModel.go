@@ -1,16 +1,59 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Order types. Orders saved before types were added are dine-in.
+const (
+	OrderDineIn   = "dine_in"
+	OrderTakeaway = "takeaway"
+	OrderDelivery = "delivery"
+)
+
 type Order struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	Order_Date time.Time          `json:"order_date" validate:"required"`
-	Created_at time.Time          `json:"created_at"`
-	Updated_at time.Time          `json:"updated_at"`
-	Order_id   string             `json:"order_id"`
-	Table_id   *string            `json:"table_id" validate:"required"`
+	ID               primitive.ObjectID `bson:"_id"`
+	Order_Date       time.Time          `json:"order_date" validate:"required"`
+	Created_at       time.Time          `json:"created_at"`
+	Updated_at       time.Time          `json:"updated_at"`
+	Order_id         string             `json:"order_id"`
+	Order_type       string             `json:"order_type" validate:"oneof=dine_in takeaway delivery"`
+	Table_id         *string            `json:"table_id"`
+	Pickup_time      *time.Time         `json:"pickup_time"`
+	Contact          *OrderContact      `json:"contact"`
+	Delivery_address *DeliveryAddress   `json:"delivery_address"`
+	Customer_id      *string            `json:"customer_id"`
+	Version          int64              `json:"version"`
+	Deleted_at       *time.Time         `json:"deleted_at"`
+	Deleted_by       *string            `json:"deleted_by"`
+}
+
+// OrderContact is who to call about a takeaway or delivery order.
+type OrderContact struct {
+	Name  string `json:"name" validate:"required,max=100"`
+	Phone string `json:"phone" validate:"required,e164"`
+}
+
+// DeliveryAddress is where a delivery order is taken.
+type DeliveryAddress struct {
+	Line1        string  `json:"line1" validate:"required,max=200"`
+	Line2        *string `json:"line2" validate:"omitempty,max=200"`
+	City         string  `json:"city" validate:"required,max=100"`
+	Postal_code  string  `json:"postal_code" validate:"required,max=20"`
+	Instructions *string `json:"instructions" validate:"omitempty,max=500"`
+}
+
+// Normalize makes orders without a type dine-in and puts the contact's
+// phone in its stored form.
+func (o *Order) Normalize() {
+	if o.Order_type == "" {
+		o.Order_type = OrderDineIn
+	}
+	if o.Contact != nil {
+		o.Contact.Name = strings.TrimSpace(o.Contact.Name)
+		o.Contact.Phone = NormalizePhone(o.Contact.Phone)
+	}
 }
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PromotionPercentOff = "percent_off"
+	PromotionBuyXGetY   = "buy_x_get_y"
+	PromotionAmountOff  = "amount_off"
+)
+
+// Promotion is a pricing rule that is applied without anyone asking for
+// it. A percent_off rule takes a percentage off each targeted item when it
+// is priced, which with schedules makes a happy hour. buy_x_get_y and
+// amount_off look at the whole order and are applied on the invoice.
+//
+// Rules are applied in descending Priority. A rule that is not Stackable is
+// only applied where no other promotion has been, and nothing is applied
+// after it.
+type Promotion struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Name         *string            `json:"name" validate:"required,min=2,max=100"`
+	Description  *string            `json:"description" validate:"omitempty,max=500"`
+	Type         *string            `json:"type" validate:"required,oneof=percent_off buy_x_get_y amount_off"`
+	Percent      *float64           `json:"percent" validate:"required_if=Type percent_off,omitempty,gt=0,lte=100"`
+	Buy_quantity *int               `json:"buy_quantity" validate:"required_if=Type buy_x_get_y,omitempty,min=1"`
+	Get_quantity *int               `json:"get_quantity" validate:"required_if=Type buy_x_get_y,omitempty,min=1"`
+	Amount_off   *float64           `json:"amount_off" validate:"required_if=Type amount_off,omitempty,gt=0"`
+	Min_subtotal float64            `json:"min_subtotal" validate:"min=0"`
+	Food_ids     []string           `json:"food_ids" validate:"unique,dive,required"`
+	Menu_ids     []string           `json:"menu_ids" validate:"unique,dive,required"`
+	Categories   []string           `json:"categories" validate:"unique,dive,required"`
+	Start_Date   *time.Time         `json:"start_date"`
+	End_Date     *time.Time         `json:"end_date"`
+	Schedules    []MenuSchedule     `json:"schedules" validate:"dive"`
+	Priority     int                `json:"priority"`
+	Stackable    bool               `json:"stackable"`
+	Created_at   time.Time          `json:"created_at"`
+	Updated_at   time.Time          `json:"updated_at"`
+	Promotion_id string             `json:"promotion_id"`
+	Version      int64              `json:"version"`
+	Deleted_at   *time.Time         `json:"deleted_at"`
+	Deleted_by   *string            `json:"deleted_by"`
+}
+
+// AppliedDiscount is the amount a promotion took off an order item or an
+// order.
+type AppliedDiscount struct {
+	Promotion_id string  `json:"promotion_id"`
+	Name         string  `json:"name"`
+	Amount       float64 `json:"amount"`
+	Stackable    bool    `json:"stackable"`
+}
+
+// IsActiveAt reports whether the promotion runs at t, which must already be
+// in the restaurant's time zone: inside its date range and, when it has
+// schedules, inside one of them.
+func (p Promotion) IsActiveAt(t time.Time) bool {
+	if p.Start_Date != nil && t.Before(*p.Start_Date) {
+		return false
+	}
+	if p.End_Date != nil && !t.Before(*p.End_Date) {
+		return false
+	}
+	if len(p.Schedules) == 0 {
+		return true
+	}
+	for _, schedule := range p.Schedules {
+		if schedule.Includes(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Targets reports whether the promotion covers a food. A promotion without
+// foods, menus or categories covers every food; otherwise the food must
+// match one of them. Categories are compared without regard to case.
+func (p Promotion) Targets(foodID string, menuID string, categories []string) bool {
+	if len(p.Food_ids) == 0 && len(p.Menu_ids) == 0 && len(p.Categories) == 0 {
+		return true
+	}
+	for _, id := range p.Food_ids {
+		if id == foodID {
+			return true
+		}
+	}
+	for _, id := range p.Menu_ids {
+		if id == menuID {
+			return true
+		}
+	}
+	for _, wanted := range p.Categories {
+		for _, category := range categories {
+			if strings.EqualFold(wanted, category) {
+				return true
+			}
+		}
+	}
+	return false
+}
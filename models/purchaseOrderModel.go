@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PurchaseOrderOpen              = "open"
+	PurchaseOrderPartiallyReceived = "partially_received"
+	PurchaseOrderReceived          = "received"
+	PurchaseOrderCancelled         = "cancelled"
+)
+
+// PurchaseOrder is stock ordered from a supplier. Its status follows the
+// receipts booked against it and is never set by clients directly.
+type PurchaseOrder struct {
+	ID                     primitive.ObjectID  `bson:"_id"`
+	Supplier_id            *string             `json:"supplier_id" validate:"required"`
+	Status                 string              `json:"status"`
+	Expected_delivery_date *time.Time          `json:"expected_delivery_date" validate:"required"`
+	Lines                  []PurchaseOrderLine `json:"lines" validate:"required,min=1,unique=Ingredient_id,dive"`
+	Receipts               []Receipt           `json:"receipts"`
+	Notes                  *string             `json:"notes" validate:"omitempty,max=1000"`
+	Total_cost             float64             `json:"total_cost"`
+	Created_at             time.Time           `json:"created_at"`
+	Updated_at             time.Time           `json:"updated_at"`
+	Purchase_order_id      string              `json:"purchase_order_id"`
+	Version                int64               `json:"version"`
+	Deleted_at             *time.Time          `json:"deleted_at"`
+	Deleted_by             *string             `json:"deleted_by"`
+}
+
+type PurchaseOrderLine struct {
+	Ingredient_id     string  `json:"ingredient_id" validate:"required"`
+	Quantity          float64 `json:"quantity" validate:"gt=0"`
+	Unit_cost         float64 `json:"unit_cost" validate:"min=0"`
+	Received_quantity float64 `json:"received_quantity"`
+}
+
+// Outstanding is how much of the line is still to be delivered.
+func (l PurchaseOrderLine) Outstanding() float64 {
+	if l.Received_quantity >= l.Quantity {
+		return 0
+	}
+	return l.Quantity - l.Received_quantity
+}
+
+// Receipt is one delivery booked against a purchase order.
+type Receipt struct {
+	Received_at time.Time     `json:"received_at"`
+	Received_by string        `json:"received_by"`
+	Lines       []ReceiptLine `json:"lines"`
+}
+
+type ReceiptLine struct {
+	Ingredient_id string  `json:"ingredient_id"`
+	Quantity      float64 `json:"quantity"`
+	Unit_cost     float64 `json:"unit_cost"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Recipe lists the ingredients that go into one portion of a food. A
+// recipe with a Size applies to that variant only; one without a Size
+// applies to every size that has no recipe of its own.
+type Recipe struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Food_id     *string            `json:"food_id" validate:"required"`
+	Size        *string            `json:"size" validate:"omitempty,eq=S|eq=M|eq=L"`
+	Ingredients []RecipeIngredient `json:"ingredients" validate:"required,min=1,unique=Ingredient_id,dive"`
+	Created_at  time.Time          `json:"created_at"`
+	Updated_at  time.Time          `json:"updated_at"`
+	Recipe_id   string             `json:"recipe_id"`
+	Version     int64              `json:"version"`
+	Deleted_at  *time.Time         `json:"deleted_at"`
+	Deleted_by  *string            `json:"deleted_by"`
+}
+
+type RecipeIngredient struct {
+	Ingredient_id string  `json:"ingredient_id" validate:"required"`
+	Quantity      float64 `json:"quantity" validate:"gt=0"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Supplier struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Name           *string            `json:"name" validate:"required,min=2,max=100"`
+	Email          *string            `json:"email" validate:"omitempty,email"`
+	Phone          *string            `json:"phone"`
+	Lead_time_days int                `json:"lead_time_days" validate:"min=0"`
+	Notes          *string            `json:"notes" validate:"omitempty,max=1000"`
+	Created_at     time.Time          `json:"created_at"`
+	Updated_at     time.Time          `json:"updated_at"`
+	Supplier_id    string             `json:"supplier_id"`
+	Version        int64              `json:"version"`
+	Deleted_at     *time.Time         `json:"deleted_at"`
+	Deleted_by     *string            `json:"deleted_by"`
+}
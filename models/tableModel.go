@@ -13,4 +13,7 @@ type Table struct {
 	Created_at       time.Time          `json:"created_at"`
 	Updated_at       time.Time          `json:"updated_at"`
 	Table_id         string             `json:"table_id"`
+	Version          int64              `json:"version"`
+	Deleted_at       *time.Time         `json:"deleted_at"`
+	Deleted_by       *string            `json:"deleted_by"`
 }
@@ -6,17 +6,29 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// User is a member of staff. The password hash and tokens are never sent
+// in responses; signup and login read them through their own requests.
 type User struct {
 	ID            primitive.ObjectID `bson:"_id"`
-	First_name    string             `bson:"first_name"`
-	Last_name     string             `bson:"last_name"`
-	Password      string             `bson:"password"`
-	Email         string             `bson:"email"`
+	First_name    string             `bson:"first_name" json:"first_name"`
+	Last_name     string             `bson:"last_name" json:"last_name"`
+	Password      string             `bson:"password" json:"-"`
+	Email         string             `bson:"email" json:"email"`
 	Avatar        *string            `json:"avatar"`
-	Phone         string             `bson:"phone"`
-	Token         string             `bson:"token"`
-	Refresh_Token string             `bson:"refresh_token"`
+	Phone         string             `bson:"phone" json:"phone"`
+	Token         string             `bson:"token" json:"-"`
+	Refresh_Token string             `bson:"refresh_token" json:"-"`
 	Created_at    time.Time          `json:"created_at"`
 	Updated_at    time.Time          `json:"updated_at"`
 	User_id       string             `json:"user_id"`
+	Permissions   []string           `json:"permissions" validate:"dive,oneof=price_override manage_users"`
+	Version       int64              `json:"version"`
+	Deleted_at    *time.Time         `json:"deleted_at"`
+	Deleted_by    *string            `json:"deleted_by"`
 }
+
+// Permissions a user can be granted on top of being signed in.
+const (
+	PermissionPriceOverride = "price_override"
+	PermissionManageUsers   = "manage_users"
+)
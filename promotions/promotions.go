@@ -0,0 +1,183 @@
+// Package promotions works out the discounts that promotion rules give.
+// It only does arithmetic; loading the rules and the order is left to the
+// caller.
+package promotions
+
+import (
+	"math"
+	"restorent-management/models"
+	"sort"
+	"time"
+)
+
+// Line is an order item as the rules see it. Price is what the item
+// currently costs, after the discounts already applied to it.
+type Line struct {
+	Food_id    string
+	Menu_id    string
+	Categories []string
+	Price      float64
+	Discounts  []models.AppliedDiscount
+}
+
+// Running returns the rules of the given types that run at t, which must be
+// in the restaurant's time zone, highest priority first. Rules of the same
+// priority keep the order they were created in.
+func Running(rules []models.Promotion, at time.Time, types ...string) []models.Promotion {
+	var running []models.Promotion
+	for _, rule := range rules {
+		if rule.IsActiveAt(at) && contains(types, *rule.Type) {
+			running = append(running, rule)
+		}
+	}
+	sort.SliceStable(running, func(i, j int) bool {
+		if running[i].Priority != running[j].Priority {
+			return running[i].Priority > running[j].Priority
+		}
+		return running[i].Created_at.Before(running[j].Created_at)
+	})
+	return running
+}
+
+// ItemDiscounts applies the percent_off rules to one item. Percentages
+// that stack are taken off one after another, each from what is left.
+func ItemDiscounts(rules []models.Promotion, line Line, at time.Time) []models.AppliedDiscount {
+	var discounts []models.AppliedDiscount
+	price := line.Price
+	for _, rule := range Running(rules, at, models.PromotionPercentOff) {
+		if !rule.Targets(line.Food_id, line.Menu_id, line.Categories) || !combines(rule, discounts) {
+			continue
+		}
+
+		amount := cents(price * *rule.Percent / 100)
+		if amount <= 0 {
+			continue
+		}
+		price -= amount
+		discounts = append(discounts, discount(rule, amount))
+		if !rule.Stackable {
+			break
+		}
+	}
+	return discounts
+}
+
+// OrderDiscounts applies the buy_x_get_y and amount_off rules to an order
+// and returns one discount per rule that took something off. Like item
+// discounts, a rule is only applied to the items it combines with, so an
+// amount_off rule that does not stack leaves out items already discounted
+// when adding up the threshold.
+func OrderDiscounts(rules []models.Promotion, lines []Line, at time.Time) []models.AppliedDiscount {
+	lines = append([]Line(nil), lines...)
+	for i := range lines {
+		lines[i].Discounts = append([]models.AppliedDiscount(nil), lines[i].Discounts...)
+	}
+
+	var discounts []models.AppliedDiscount
+	for _, rule := range Running(rules, at, models.PromotionBuyXGetY, models.PromotionAmountOff) {
+		var eligible []*Line
+		for i := range lines {
+			line := &lines[i]
+			if rule.Targets(line.Food_id, line.Menu_id, line.Categories) && combines(rule, line.Discounts) {
+				eligible = append(eligible, line)
+			}
+		}
+		if len(eligible) == 0 {
+			continue
+		}
+
+		var amount float64
+		switch *rule.Type {
+		case models.PromotionBuyXGetY:
+			amount = buyXGetY(rule, eligible)
+		case models.PromotionAmountOff:
+			amount = amountOff(rule, eligible)
+		}
+		if amount <= 0 {
+			continue
+		}
+
+		discounts = append(discounts, discount(rule, amount))
+		if !rule.Stackable {
+			break
+		}
+	}
+	return discounts
+}
+
+// buyXGetY makes the cheapest Get_quantity items of every Buy_quantity +
+// Get_quantity eligible items free, most expensive items grouped first.
+func buyXGetY(rule models.Promotion, eligible []*Line) float64 {
+	sort.SliceStable(eligible, func(i, j int) bool { return eligible[i].Price > eligible[j].Price })
+
+	group := *rule.Buy_quantity + *rule.Get_quantity
+	total := 0.0
+	for start := 0; start+group <= len(eligible); start += group {
+		for _, line := range eligible[start+*rule.Buy_quantity : start+group] {
+			total += line.Price
+			line.Discounts = append(line.Discounts, discount(rule, line.Price))
+			line.Price = 0
+		}
+	}
+	return cents(total)
+}
+
+// amountOff takes a fixed amount off the eligible items once they add up
+// to Min_subtotal, spreading it over them so later rules see the lower
+// prices. The discount never exceeds what the items cost.
+func amountOff(rule models.Promotion, eligible []*Line) float64 {
+	subtotal := 0.0
+	for _, line := range eligible {
+		subtotal += line.Price
+	}
+	if subtotal <= 0 || subtotal < rule.Min_subtotal {
+		return 0
+	}
+
+	amount := cents(math.Min(*rule.Amount_off, subtotal))
+	for _, line := range eligible {
+		share := line.Price / subtotal * amount
+		line.Discounts = append(line.Discounts, discount(rule, share))
+		line.Price -= share
+	}
+	return amount
+}
+
+// combines reports whether rule may be applied on top of the discounts
+// already given.
+func combines(rule models.Promotion, given []models.AppliedDiscount) bool {
+	if len(given) == 0 {
+		return true
+	}
+	if !rule.Stackable {
+		return false
+	}
+	for _, applied := range given {
+		if !applied.Stackable {
+			return false
+		}
+	}
+	return true
+}
+
+func discount(rule models.Promotion, amount float64) models.AppliedDiscount {
+	return models.AppliedDiscount{
+		Promotion_id: rule.Promotion_id,
+		Name:         *rule.Name,
+		Amount:       amount,
+		Stackable:    rule.Stackable,
+	}
+}
+
+func cents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package promotions
+
+import (
+	"reflect"
+	"restorent-management/models"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 3, 4, 18, 30, 0, 0, time.UTC) // a Monday
+
+func percentOffRule(id string, percent float64, priority int, stackable bool) models.Promotion {
+	kind := models.PromotionPercentOff
+	return models.Promotion{Promotion_id: id, Name: &id, Type: &kind, Percent: &percent, Priority: priority, Stackable: stackable}
+}
+
+func buyXGetYRule(id string, buy, get int, stackable bool) models.Promotion {
+	kind := models.PromotionBuyXGetY
+	return models.Promotion{Promotion_id: id, Name: &id, Type: &kind, Buy_quantity: &buy, Get_quantity: &get, Stackable: stackable}
+}
+
+func amountOffRule(id string, amount, minSubtotal float64, stackable bool) models.Promotion {
+	kind := models.PromotionAmountOff
+	return models.Promotion{Promotion_id: id, Name: &id, Type: &kind, Amount_off: &amount, Min_subtotal: minSubtotal, Stackable: stackable}
+}
+
+func ids(rules []models.Promotion) []string {
+	out := []string{}
+	for _, rule := range rules {
+		out = append(out, rule.Promotion_id)
+	}
+	return out
+}
+
+func amounts(discounts []models.AppliedDiscount) map[string]float64 {
+	out := map[string]float64{}
+	for _, d := range discounts {
+		out[d.Promotion_id] = d.Amount
+	}
+	return out
+}
+
+func TestRunning(t *testing.T) {
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	low := percentOffRule("low", 10, 1, true)
+	first := percentOffRule("first", 10, 5, true)
+	first.Created_at = earlier
+	second := percentOffRule("second", 10, 5, true)
+	second.Created_at = now
+	future := percentOffRule("future", 10, 9, true)
+	future.Start_Date = &later
+	ended := percentOffRule("ended", 10, 9, true)
+	ended.End_Date = &now
+	happyHour := percentOffRule("happy-hour", 10, 3, true)
+	happyHour.Schedules = []models.MenuSchedule{{Days: []string{"MON"}, Start_time: "17:00", End_time: "19:00"}}
+	brunch := percentOffRule("brunch", 10, 3, true)
+	brunch.Schedules = []models.MenuSchedule{{Days: []string{"SUN"}, Start_time: "10:00", End_time: "14:00"}}
+	order := amountOffRule("order", 5, 0, true)
+
+	rules := []models.Promotion{low, second, first, future, ended, happyHour, brunch, order}
+
+	tests := []struct {
+		name  string
+		types []string
+		want  []string
+	}{
+		{
+			name:  "highest priority first, ties by creation",
+			types: []string{models.PromotionPercentOff},
+			want:  []string{"first", "second", "happy-hour", "low"},
+		},
+		{
+			name:  "only the given types",
+			types: []string{models.PromotionAmountOff},
+			want:  []string{"order"},
+		},
+		{
+			name:  "no types matches nothing",
+			types: nil,
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(Running(rules, now, tt.types...)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Running() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestItemDiscounts(t *testing.T) {
+	pizza := Line{Food_id: "pizza", Menu_id: "dinner", Categories: []string{"Mains"}, Price: 20}
+	drinks := percentOffRule("drinks", 50, 9, true)
+	drinks.Categories = []string{"drinks"}
+	mains := percentOffRule("mains", 10, 1, true)
+	mains.Categories = []string{"MAINS"}
+
+	tests := []struct {
+		name  string
+		rules []models.Promotion
+		line  Line
+		want  map[string]float64
+	}{
+		{
+			name:  "stackable percentages come off what is left",
+			rules: []models.Promotion{percentOffRule("a", 10, 2, true), percentOffRule("b", 50, 1, true)},
+			line:  pizza,
+			want:  map[string]float64{"a": 2, "b": 9},
+		},
+		{
+			name:  "a rule that does not stack stops the rest",
+			rules: []models.Promotion{percentOffRule("a", 10, 2, false), percentOffRule("b", 50, 1, true)},
+			line:  pizza,
+			want:  map[string]float64{"a": 2},
+		},
+		{
+			name:  "a rule that does not stack is skipped after another",
+			rules: []models.Promotion{percentOffRule("a", 10, 2, true), percentOffRule("b", 50, 1, false)},
+			line:  pizza,
+			want:  map[string]float64{"a": 2},
+		},
+		{
+			name:  "only targeted items, categories without regard to case",
+			rules: []models.Promotion{drinks, mains},
+			line:  pizza,
+			want:  map[string]float64{"mains": 2},
+		},
+		{
+			name:  "amounts are rounded to cents",
+			rules: []models.Promotion{percentOffRule("a", 33, 1, true)},
+			line:  Line{Food_id: "tea", Price: 2.99},
+			want:  map[string]float64{"a": 0.99},
+		},
+		{
+			name:  "nothing to take off",
+			rules: []models.Promotion{percentOffRule("a", 10, 1, true)},
+			line:  Line{Food_id: "water", Price: 0},
+			want:  map[string]float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := amounts(ItemDiscounts(tt.rules, tt.line, now)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ItemDiscounts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderDiscounts(t *testing.T) {
+	lines := []Line{
+		{Food_id: "steak", Price: 30},
+		{Food_id: "pasta", Price: 15},
+		{Food_id: "salad", Price: 10},
+		{Food_id: "soup", Price: 8},
+	}
+	happy := []models.AppliedDiscount{{Promotion_id: "happy-hour", Amount: 1, Stackable: false}}
+	discounted := append([]Line(nil), lines...)
+	discounted[0].Discounts = happy
+
+	tests := []struct {
+		name  string
+		rules []models.Promotion
+		lines []Line
+		want  map[string]float64
+	}{
+		{
+			name:  "buy one get one frees the cheaper of each pair",
+			rules: []models.Promotion{buyXGetYRule("bogo", 1, 1, false)},
+			lines: lines,
+			want:  map[string]float64{"bogo": 23},
+		},
+		{
+			name:  "buy two get one needs a full group",
+			rules: []models.Promotion{buyXGetYRule("b2g1", 2, 1, false)},
+			lines: lines,
+			want:  map[string]float64{"b2g1": 10},
+		},
+		{
+			name:  "amount off once the threshold is reached",
+			rules: []models.Promotion{amountOffRule("off", 5, 50, false)},
+			lines: lines,
+			want:  map[string]float64{"off": 5},
+		},
+		{
+			name:  "amount off below the threshold",
+			rules: []models.Promotion{amountOffRule("off", 5, 100, false)},
+			lines: lines,
+			want:  map[string]float64{},
+		},
+		{
+			name:  "amount off never exceeds the items",
+			rules: []models.Promotion{amountOffRule("off", 100, 0, false)},
+			lines: lines,
+			want:  map[string]float64{"off": 63},
+		},
+		{
+			name:  "stacked rules see the lower prices",
+			rules: []models.Promotion{buyXGetYRule("bogo", 1, 1, true), amountOffRule("off", 100, 0, true)},
+			lines: lines,
+			want:  map[string]float64{"bogo": 23, "off": 40},
+		},
+		{
+			name:  "a rule that does not stack leaves discounted items out of the threshold",
+			rules: []models.Promotion{amountOffRule("off", 5, 40, false)},
+			lines: discounted,
+			want:  map[string]float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := amounts(OrderDiscounts(tt.rules, tt.lines, now)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OrderDiscounts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if lines[0].Price != 30 || lines[0].Discounts != nil {
+		t.Errorf("OrderDiscounts() changed the caller's lines: %+v", lines[0])
+	}
+}
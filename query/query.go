@@ -0,0 +1,346 @@
+package query
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+type Kind int
+
+const (
+	String Kind = iota
+	Number
+	Date
+	Bool
+)
+
+// Field is a query parameter that filters on a document field. String and
+// Bool fields match exactly, with comma separated values matching any of
+// them. Number and Date fields also accept <param>_from and <param>_to for
+// inclusive ranges; a YYYY-MM-DD <param>_to includes the whole day.
+type Field struct {
+	Param string
+	Field string
+	Kind  Kind
+}
+
+// Spec describes what a list endpoint can be filtered and sorted by.
+type Spec struct {
+	Filters     []Field
+	Sortable    []string
+	DefaultSort string
+}
+
+// List is a parsed list request. Filter holds the field filters and can be
+// extended by the handler before calling Find, for example to hide deleted
+// documents.
+type List struct {
+	Filter     bson.M
+	Projection interface{}
+	sort       bson.D
+	after      bson.D
+	limit      int64
+}
+
+// Page is the envelope every list endpoint responds with.
+type Page[T any] struct {
+	Data        []T    `json:"data"`
+	Total_count int64  `json:"total_count"`
+	Next_cursor string `json:"next_cursor,omitempty"`
+	Limit       int64  `json:"limit"`
+}
+
+// Parse reads limit, cursor, sort and the spec's filters from the request.
+func Parse(c *gin.Context, spec Spec) (*List, error) {
+	list := &List{Filter: bson.M{}, limit: DefaultLimit}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		list.limit = limit
+	}
+
+	sort, err := parseSort(c.DefaultQuery("sort", spec.DefaultSort), spec.Sortable)
+	if err != nil {
+		return nil, err
+	}
+	list.sort = sort
+
+	if raw := c.Query("cursor"); raw != "" {
+		after, err := decodeCursor(raw, sort)
+		if err != nil {
+			return nil, err
+		}
+		list.after = after
+	}
+
+	for _, field := range spec.Filters {
+		if err := field.apply(c, list.Filter); err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+// Find runs the list against collection and decodes each document into T.
+func Find[T any](ctx context.Context, collection *mongo.Collection, list *List) (Page[T], error) {
+	page := Page[T]{Data: []T{}, Limit: list.limit}
+
+	total, err := collection.CountDocuments(ctx, list.Filter)
+	if err != nil {
+		return page, err
+	}
+	page.Total_count = total
+
+	filter := list.Filter
+	if list.after != nil {
+		filter = bson.M{"$and": bson.A{list.Filter, keysetCondition(list.sort, list.after)}}
+	}
+
+	// One extra document tells us whether there is a next page.
+	opts := options.Find().SetSort(list.sort).SetLimit(list.limit + 1)
+	if list.Projection != nil {
+		opts.SetProjection(list.Projection)
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return page, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []bson.Raw
+	for cursor.Next(ctx) {
+		docs = append(docs, append(bson.Raw(nil), cursor.Current...))
+	}
+	if err := cursor.Err(); err != nil {
+		return page, err
+	}
+
+	if int64(len(docs)) > list.limit {
+		docs = docs[:list.limit]
+		next, err := encodeCursor(docs[len(docs)-1], list.sort)
+		if err != nil {
+			return page, err
+		}
+		page.Next_cursor = next
+	}
+
+	for _, doc := range docs {
+		var item T
+		if err := bson.Unmarshal(doc, &item); err != nil {
+			return page, err
+		}
+		page.Data = append(page.Data, item)
+	}
+
+	return page, nil
+}
+
+// parseSort turns "-created_at,name" into a sort document. _id is always
+// added last so that the order, and therefore the cursor, is stable.
+func parseSort(raw string, sortable []string) (bson.D, error) {
+	var sort bson.D
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		direction := 1
+		if strings.HasPrefix(part, "-") {
+			direction = -1
+			part = part[1:]
+		}
+
+		if !contains(sortable, part) {
+			return nil, fmt.Errorf("cannot sort by %q", part)
+		}
+		sort = append(sort, bson.E{Key: part, Value: direction})
+	}
+
+	return append(sort, bson.E{Key: "_id", Value: 1}), nil
+}
+
+// keysetCondition matches documents that sort after the cursor position:
+// (a > x) or (a = x and b > y) and so on, flipping $gt to $lt for
+// descending fields.
+func keysetCondition(sort bson.D, after bson.D) bson.M {
+	var or bson.A
+	for i, key := range sort {
+		clause := bson.M{}
+		for _, previous := range after[:i] {
+			clause[previous.Key] = previous.Value
+		}
+
+		condition := pastValue(key.Key, after[i].Value, key.Value == -1)
+		if condition == nil {
+			continue
+		}
+		for field, value := range condition {
+			clause[field] = value
+		}
+		or = append(or, clause)
+	}
+	return bson.M{"$or": or}
+}
+
+// pastValue matches the values of field that sort after value. $gt and $lt
+// only compare values of the same type, so null, which Mongo sorts before
+// everything else and which also stands for a missing field, is handled on
+// its own: ascending, everything that is set comes after it, and
+// descending, nothing does. A nil result matches nothing.
+func pastValue(field string, value interface{}, descending bool) bson.M {
+	switch {
+	case value == nil && descending:
+		return nil
+	case value == nil:
+		return bson.M{field: bson.M{"$ne": nil}}
+	case descending:
+		return bson.M{"$or": bson.A{bson.M{field: bson.M{"$lt": value}}, bson.M{field: nil}}}
+	default:
+		return bson.M{field: bson.M{"$gt": value}}
+	}
+}
+
+func encodeCursor(doc bson.Raw, sort bson.D) (string, error) {
+	position := make(bson.D, 0, len(sort))
+	for _, key := range sort {
+		var value interface{}
+		if raw, err := doc.LookupErr(key.Key); err == nil {
+			if err := raw.Unmarshal(&value); err != nil {
+				return "", err
+			}
+		}
+		position = append(position, bson.E{Key: key.Key, Value: value})
+	}
+
+	data, err := bson.Marshal(position)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(raw string, sort bson.D) (bson.D, error) {
+	invalid := fmt.Errorf("cursor is invalid or does not match the sort order")
+
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, invalid
+	}
+
+	var position bson.D
+	if err := bson.Unmarshal(data, &position); err != nil || len(position) != len(sort) {
+		return nil, invalid
+	}
+	for i, key := range sort {
+		if position[i].Key != key.Key {
+			return nil, invalid
+		}
+	}
+	return position, nil
+}
+
+func (f Field) apply(c *gin.Context, filter bson.M) error {
+	condition := bson.M{}
+
+	if raw := c.Query(f.Param); raw != "" {
+		var values bson.A
+		for _, part := range strings.Split(raw, ",") {
+			value, err := f.parse(strings.TrimSpace(part))
+			if err != nil {
+				return err
+			}
+			values = append(values, value)
+		}
+		if len(values) == 1 {
+			filter[f.Field] = values[0]
+			return nil
+		}
+		condition["$in"] = values
+	}
+
+	if f.Kind == Number || f.Kind == Date {
+		for suffix, operator := range map[string]string{"_from": "$gte", "_to": "$lte"} {
+			raw := c.Query(f.Param + suffix)
+			if raw == "" {
+				continue
+			}
+			value, err := f.parse(raw)
+			if err != nil {
+				return err
+			}
+			// A date without a time runs to the end of that day
+			if f.Kind == Date && suffix == "_to" && isDateOnly(raw) {
+				operator = "$lt"
+				value = value.(time.Time).AddDate(0, 0, 1)
+			}
+			condition[operator] = value
+		}
+	}
+
+	if len(condition) > 0 {
+		filter[f.Field] = condition
+	}
+	return nil
+}
+
+func (f Field) parse(raw string) (interface{}, error) {
+	switch f.Kind {
+	case Number:
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", f.Param)
+		}
+		return value, nil
+	case Date:
+		if value, err := time.Parse(time.RFC3339, raw); err == nil {
+			return value, nil
+		}
+		value, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 timestamp or a YYYY-MM-DD date", f.Param)
+		}
+		return value, nil
+	case Bool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", f.Param)
+		}
+		return value, nil
+	default:
+		return raw, nil
+	}
+}
+
+func isDateOnly(raw string) bool {
+	_, err := time.Parse(time.DateOnly, raw)
+	return err == nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
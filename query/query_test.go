@@ -0,0 +1,241 @@
+package query
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func testContext(target string) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", target, nil)
+	return c
+}
+
+func TestPastValue(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      interface{}
+		descending bool
+		want       bson.M
+	}{
+		{
+			name:  "ascending after a value",
+			value: 5,
+			want:  bson.M{"price": bson.M{"$gt": 5}},
+		},
+		{
+			name:       "descending after a value includes nulls",
+			value:      5,
+			descending: true,
+			want:       bson.M{"$or": bson.A{bson.M{"price": bson.M{"$lt": 5}}, bson.M{"price": nil}}},
+		},
+		{
+			name:  "ascending after null is every set value",
+			value: nil,
+			want:  bson.M{"price": bson.M{"$ne": nil}},
+		},
+		{
+			name:       "descending after null is nothing",
+			value:      nil,
+			descending: true,
+			want:       nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pastValue("price", tt.value, tt.descending); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pastValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	tests := []struct {
+		name  string
+		sort  bson.D
+		after bson.D
+		want  bson.M
+	}{
+		{
+			name:  "ascending field then _id",
+			sort:  bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}},
+			after: bson.D{{Key: "name", Value: "b"}, {Key: "_id", Value: "id-1"}},
+			want: bson.M{"$or": bson.A{
+				bson.M{"name": bson.M{"$gt": "b"}},
+				bson.M{"name": "b", "_id": bson.M{"$gt": "id-1"}},
+			}},
+		},
+		{
+			name:  "descending field",
+			sort:  bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: 1}},
+			after: bson.D{{Key: "created_at", Value: 10}, {Key: "_id", Value: "id-1"}},
+			want: bson.M{"$or": bson.A{
+				bson.M{"$or": bson.A{bson.M{"created_at": bson.M{"$lt": 10}}, bson.M{"created_at": nil}}},
+				bson.M{"created_at": 10, "_id": bson.M{"$gt": "id-1"}},
+			}},
+		},
+		{
+			name:  "descending past null only pages through the nulls",
+			sort:  bson.D{{Key: "price", Value: -1}, {Key: "_id", Value: 1}},
+			after: bson.D{{Key: "price", Value: nil}, {Key: "_id", Value: "id-1"}},
+			want: bson.M{"$or": bson.A{
+				bson.M{"price": nil, "_id": bson.M{"$gt": "id-1"}},
+			}},
+		},
+		{
+			name:  "ascending past null moves on to set values",
+			sort:  bson.D{{Key: "price", Value: 1}, {Key: "_id", Value: 1}},
+			after: bson.D{{Key: "price", Value: nil}, {Key: "_id", Value: "id-1"}},
+			want: bson.M{"$or": bson.A{
+				bson.M{"price": bson.M{"$ne": nil}},
+				bson.M{"price": nil, "_id": bson.M{"$gt": "id-1"}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keysetCondition(tt.sort, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keysetCondition() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	spec := Spec{
+		Filters: []Field{
+			{Param: "created_at", Field: "created_at", Kind: Date},
+			{Param: "price", Field: "price", Kind: Number},
+			{Param: "status", Field: "status", Kind: String},
+		},
+		Sortable:    []string{"created_at"},
+		DefaultSort: "-created_at",
+	}
+	day := func(s string) time.Time {
+		value, _ := time.Parse(time.DateOnly, s)
+		return value
+	}
+	at := func(s string) time.Time {
+		value, _ := time.Parse(time.RFC3339, s)
+		return value
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		want    bson.M
+		wantErr bool
+	}{
+		{
+			name:   "date-only _to includes the whole day",
+			target: "/?created_at_to=2024-03-01",
+			want:   bson.M{"created_at": bson.M{"$lt": day("2024-03-02")}},
+		},
+		{
+			name:   "timestamp _to is inclusive",
+			target: "/?created_at_to=2024-03-01T12:00:00Z",
+			want:   bson.M{"created_at": bson.M{"$lte": at("2024-03-01T12:00:00Z")}},
+		},
+		{
+			name:   "date-only range",
+			target: "/?created_at_from=2024-03-01&created_at_to=2024-03-01",
+			want:   bson.M{"created_at": bson.M{"$gte": day("2024-03-01"), "$lt": day("2024-03-02")}},
+		},
+		{
+			name:   "number range",
+			target: "/?price_from=2&price_to=8.5",
+			want:   bson.M{"price": bson.M{"$gte": 2.0, "$lte": 8.5}},
+		},
+		{
+			name:   "comma separated values match any",
+			target: "/?status=open,closed",
+			want:   bson.M{"status": bson.M{"$in": bson.A{"open", "closed"}}},
+		},
+		{
+			name:    "bad date",
+			target:  "/?created_at_to=yesterday",
+			wantErr: true,
+		},
+		{
+			name:    "unsortable field",
+			target:  "/?sort=price",
+			wantErr: true,
+		},
+		{
+			name:    "limit out of range",
+			target:  "/?limit=101",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := Parse(testContext(tt.target), spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(list.Filter, tt.want) {
+				t.Errorf("Parse() filter = %v, want %v", list.Filter, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	sort := bson.D{{Key: "price", Value: -1}, {Key: "_id", Value: 1}}
+	tests := []struct {
+		name string
+		doc  bson.M
+		want bson.D
+	}{
+		{
+			name: "set values",
+			doc:  bson.M{"_id": "id-1", "price": 4.5},
+			want: bson.D{{Key: "price", Value: 4.5}, {Key: "_id", Value: "id-1"}},
+		},
+		{
+			name: "missing field is null",
+			doc:  bson.M{"_id": "id-2"},
+			want: bson.D{{Key: "price", Value: nil}, {Key: "_id", Value: "id-2"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := bson.Marshal(tt.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cursor, err := encodeCursor(raw, sort)
+			if err != nil {
+				t.Fatalf("encodeCursor() error = %v", err)
+			}
+			got, err := decodeCursor(cursor, sort)
+			if err != nil {
+				t.Fatalf("decodeCursor() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeCursor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := decodeCursor("not a cursor", sort); err == nil {
+		t.Errorf("decodeCursor() of garbage error = nil, want an error")
+	}
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"restorent-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CouponRoutes(router *gin.Engine) {
+	couponGroup := router.Group("/coupons")
+	{
+		couponGroup.GET("", controllers.GetCoupons())
+		couponGroup.GET("/report", controllers.GetCouponReport())
+		couponGroup.GET("/:coupon_id", controllers.GetCouponByID())
+		couponGroup.GET("/:coupon_id/redemptions", controllers.GetCouponRedemptions())
+		couponGroup.POST("/create", controllers.CreateCoupon())
+		couponGroup.PATCH("/:coupon_id", controllers.UpdateCoupon())
+		couponGroup.DELETE("/:coupon_id", controllers.DeleteCoupon())
+		couponGroup.POST("/:coupon_id/restore", controllers.RestoreCoupon())
+	}
+}
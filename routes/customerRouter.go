@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"restorent-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CustomerRoutes(router *gin.Engine) {
+	customerGroup := router.Group("/customers")
+	{
+		customerGroup.GET("", controllers.GetCustomers())
+		customerGroup.GET("/:customer_id", controllers.GetCustomerByID())
+		customerGroup.GET("/:customer_id/profile", controllers.GetCustomerProfile())
+		customerGroup.GET("/:customer_id/visits", controllers.GetCustomerVisits())
+		customerGroup.GET("/:customer_id/loyalty", controllers.GetLoyaltySummary())
+		customerGroup.GET("/:customer_id/loyalty/ledger", controllers.GetLoyaltyLedger())
+		customerGroup.POST("/create", controllers.CreateCustomer())
+		customerGroup.PATCH("/:customer_id", controllers.UpdateCustomer())
+		customerGroup.POST("/:customer_id/merge", controllers.MergeCustomers())
+		customerGroup.DELETE("/:customer_id", controllers.DeleteCustomer())
+		customerGroup.POST("/:customer_id/restore", controllers.RestoreCustomer())
+	}
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"restorent-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GuestFeedbackRoutes are opened from the link on a receipt, so they are
+// registered before authentication.
+func GuestFeedbackRoutes(router *gin.Engine) {
+	router.GET("/feedback/:token", controllers.GetFeedbackForm())
+	router.POST("/feedback/:token", controllers.SubmitFeedback())
+}
+
+func FeedbackRoutes(router *gin.Engine) {
+	feedbackGroup := router.Group("/feedback")
+	{
+		feedbackGroup.GET("", controllers.GetFeedback())
+		feedbackGroup.GET("/report", controllers.GetFeedbackReport())
+	}
+}
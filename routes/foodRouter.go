@@ -10,8 +10,15 @@ func FoodRoutes(router *gin.Engine) {
 	foodGroup := router.Group("/foods")
 	{
 		foodGroup.GET("", controllers.GetFoods())
+		foodGroup.GET("/search", controllers.SearchFoods())
+		foodGroup.GET("/availability", controllers.GetUnavailableFoods())
+		foodGroup.GET("/availability/stream", controllers.StreamAvailability())
 		foodGroup.GET("/:food_id", controllers.GetFoodByID())
 		foodGroup.POST("/create", controllers.CreateFood())
 		foodGroup.PATCH("/:food_id", controllers.UpdateFood())
+		foodGroup.PUT("/:food_id/availability", controllers.SetFoodAvailability())
+		foodGroup.POST("/:food_id/image", controllers.UploadFoodImage())
+		foodGroup.DELETE("/:food_id", controllers.DeleteFood())
+		foodGroup.POST("/:food_id/restore", controllers.RestoreFood())
 	}
 }
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"restorent-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GiftCardRoutes(router *gin.Engine) {
+	giftCardGroup := router.Group("/giftCards")
+	{
+		giftCardGroup.GET("", controllers.GetGiftCards())
+		giftCardGroup.GET("/:gift_card_id", controllers.GetGiftCardByID())
+		giftCardGroup.GET("/:gift_card_id/ledger", controllers.GetGiftCardLedger())
+		giftCardGroup.POST("/create", controllers.IssueGiftCard())
+		giftCardGroup.POST("/balance", controllers.GetGiftCardBalance())
+		giftCardGroup.PATCH("/:gift_card_id", controllers.UpdateGiftCard())
+		giftCardGroup.POST("/:gift_card_id/top-up", controllers.TopUpGiftCard())
+	}
+}
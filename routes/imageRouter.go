@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"restorent-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ImageRoutes(router *gin.Engine) {
+	router.GET("/images/*key", controllers.ServeImage())
+	router.HEAD("/images/*key", controllers.ServeImage())
+}
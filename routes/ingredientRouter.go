@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"restorent-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func IngredientRoutes(router *gin.Engine) {
+	ingredientGroup := router.Group("/ingredients")
+	{
+		ingredientGroup.GET("", controllers.GetIngredients())
+		ingredientGroup.GET("/low-stock", controllers.GetLowStock())
+		ingredientGroup.GET("/valuation", controllers.GetStockValuation())
+		ingredientGroup.GET("/reorder-suggestions", controllers.GetReorderSuggestions())
+		ingredientGroup.GET("/:ingredient_id", controllers.GetIngredientByID())
+		ingredientGroup.GET("/:ingredient_id/movements", controllers.GetStockMovements())
+		ingredientGroup.POST("/create", controllers.CreateIngredient())
+		ingredientGroup.PATCH("/:ingredient_id", controllers.UpdateIngredient())
+		ingredientGroup.POST("/:ingredient_id/adjust", controllers.AdjustStock())
+		ingredientGroup.DELETE("/:ingredient_id", controllers.DeleteIngredient())
+		ingredientGroup.POST("/:ingredient_id/restore", controllers.RestoreIngredient())
+	}
+}
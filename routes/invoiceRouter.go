@@ -13,5 +13,15 @@ func InvoiceRoutes(router *gin.Engine) {
 		invoiceGroup.GET("/:invoice_id", controllers.GetInvoiceByID())
 		invoiceGroup.POST("/invoices", controllers.CreateInvoice())
 		invoiceGroup.PATCH("/:invoice_id", controllers.UpdateInvoice())
+		invoiceGroup.DELETE("/:invoice_id", controllers.DeleteInvoice())
+		invoiceGroup.POST("/:invoice_id/restore", controllers.RestoreInvoice())
+		invoiceGroup.POST("/:invoice_id/coupon", controllers.RedeemCoupon())
+		invoiceGroup.DELETE("/:invoice_id/coupon", controllers.RemoveCoupon())
+		invoiceGroup.POST("/:invoice_id/giftCards", controllers.PayWithGiftCard())
+		invoiceGroup.DELETE("/:invoice_id/giftCards/:entry_id", controllers.RefundGiftCardPayment())
+		invoiceGroup.POST("/:invoice_id/member", controllers.LinkInvoiceMember())
+		invoiceGroup.POST("/:invoice_id/points", controllers.RedeemPoints())
+		invoiceGroup.DELETE("/:invoice_id/points", controllers.ReturnPoints())
+		invoiceGroup.POST("/:invoice_id/feedbackToken", controllers.IssueFeedbackToken())
 	}
 }
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"restorent-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func LoyaltyRoutes(router *gin.Engine) {
+	loyaltyGroup := router.Group("/loyalty")
+	{
+		loyaltyGroup.GET("/settings", controllers.GetLoyaltySettings())
+		loyaltyGroup.PATCH("/settings", controllers.UpdateLoyaltySettings())
+	}
+}
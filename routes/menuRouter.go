@@ -10,8 +10,13 @@ func MenuRoutes(router *gin.Engine) {
 	menuGroup := router.Group("/menus")
 	{
 		menuGroup.GET("", controllers.GetMenus())
+		menuGroup.GET("/active", controllers.GetActiveMenus())
 		menuGroup.GET("/:menu_id", controllers.GetMenuByID())
+		menuGroup.GET("/:menu_id/full", controllers.GetFullMenu())
 		menuGroup.POST("/create", controllers.CreateMenu())
 		menuGroup.PATCH("/:menu_id", controllers.UpdateMenu())
+		menuGroup.PUT("/:menu_id/sections/:section_id/foods", controllers.SetSectionOrder())
+		menuGroup.DELETE("/:menu_id", controllers.DeleteMenu())
+		menuGroup.POST("/:menu_id/restore", controllers.RestoreMenu())
 	}
 }
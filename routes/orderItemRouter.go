@@ -10,8 +10,11 @@ func OrderItemRoutes(router *gin.Engine) {
 	orderItemGroup := router.Group("/orderItems")
 	{
 		orderItemGroup.GET("", controllers.GetOrderItems())
-		orderItemGroup.GET("/:ordeitem_id", controllers.GetOrderItemsByID())
+		orderItemGroup.GET("/sales", controllers.GetFoodSales())
+		orderItemGroup.GET("/:order_item_id", controllers.GetOrderItemsByID())
 		orderItemGroup.POST("/create", controllers.CreateOrderItems())
-		orderItemGroup.PATCH("/:ordeitem_id", controllers.UpdateOrderItems())
+		orderItemGroup.PATCH("/:order_item_id", controllers.UpdateOrderItems())
+		orderItemGroup.DELETE("/:order_item_id", controllers.DeleteOrderItem())
+		orderItemGroup.POST("/:order_item_id/restore", controllers.RestoreOrderItem())
 	}
 }
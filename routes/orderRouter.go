@@ -11,7 +11,10 @@ func OrderRoutes(router *gin.Engine) {
 	{
 		orderGroup.GET("", controllers.GetOrders())
 		orderGroup.GET("/:order_id", controllers.GetOrderByID())
+		orderGroup.GET("/:order_id/ticket", controllers.GetKitchenTicket())
 		orderGroup.POST("/orders", controllers.CreateOrder())
 		orderGroup.PATCH("/:order_id", controllers.UpdateOrder())
+		orderGroup.DELETE("/:order_id", controllers.DeleteOrder())
+		orderGroup.POST("/:order_id/restore", controllers.RestoreOrder())
 	}
 }
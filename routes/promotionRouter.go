@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"restorent-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func PromotionRoutes(router *gin.Engine) {
+	promotionGroup := router.Group("/promotions")
+	{
+		promotionGroup.GET("", controllers.GetPromotions())
+		promotionGroup.GET("/:promotion_id", controllers.GetPromotionByID())
+		promotionGroup.POST("/create", controllers.CreatePromotion())
+		promotionGroup.PATCH("/:promotion_id", controllers.UpdatePromotion())
+		promotionGroup.DELETE("/:promotion_id", controllers.DeletePromotion())
+		promotionGroup.POST("/:promotion_id/restore", controllers.RestorePromotion())
+	}
+}
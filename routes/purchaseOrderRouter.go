@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"restorent-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func PurchaseOrderRoutes(router *gin.Engine) {
+	purchaseOrderGroup := router.Group("/purchaseOrders")
+	{
+		purchaseOrderGroup.GET("", controllers.GetPurchaseOrders())
+		purchaseOrderGroup.GET("/:purchase_order_id", controllers.GetPurchaseOrderByID())
+		purchaseOrderGroup.POST("/create", controllers.CreatePurchaseOrder())
+		purchaseOrderGroup.PATCH("/:purchase_order_id", controllers.UpdatePurchaseOrder())
+		purchaseOrderGroup.POST("/:purchase_order_id/receive", controllers.ReceivePurchaseOrder())
+		purchaseOrderGroup.POST("/:purchase_order_id/cancel", controllers.CancelPurchaseOrder())
+		purchaseOrderGroup.DELETE("/:purchase_order_id", controllers.DeletePurchaseOrder())
+		purchaseOrderGroup.POST("/:purchase_order_id/restore", controllers.RestorePurchaseOrder())
+	}
+}
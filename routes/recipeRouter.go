@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"restorent-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RecipeRoutes(router *gin.Engine) {
+	recipeGroup := router.Group("/recipes")
+	{
+		recipeGroup.GET("", controllers.GetRecipes())
+		recipeGroup.GET("/:recipe_id", controllers.GetRecipeByID())
+		recipeGroup.POST("/create", controllers.CreateRecipe())
+		recipeGroup.PATCH("/:recipe_id", controllers.UpdateRecipe())
+		recipeGroup.DELETE("/:recipe_id", controllers.DeleteRecipe())
+		recipeGroup.POST("/:recipe_id/restore", controllers.RestoreRecipe())
+	}
+}
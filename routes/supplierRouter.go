@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"restorent-management/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SupplierRoutes(router *gin.Engine) {
+	supplierGroup := router.Group("/suppliers")
+	{
+		supplierGroup.GET("", controllers.GetSuppliers())
+		supplierGroup.GET("/:supplier_id", controllers.GetSupplierByID())
+		supplierGroup.POST("/create", controllers.CreateSupplier())
+		supplierGroup.PATCH("/:supplier_id", controllers.UpdateSupplier())
+		supplierGroup.DELETE("/:supplier_id", controllers.DeleteSupplier())
+		supplierGroup.POST("/:supplier_id/restore", controllers.RestoreSupplier())
+	}
+}
@@ -13,5 +13,7 @@ func TableRoutes(router *gin.Engine) {
 		tableGroup.GET("/:table_id", controllers.GetTableByID())
 		tableGroup.POST("/create", controllers.CreateTable())
 		tableGroup.PATCH("/:table_id", controllers.UpdateTable())
+		tableGroup.DELETE("/:table_id", controllers.DeleteTable())
+		tableGroup.POST("/:table_id/restore", controllers.RestoreTable())
 	}
 }
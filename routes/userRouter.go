@@ -2,6 +2,8 @@ package routes
 
 import (
 	"restorent-management/controllers"
+	"restorent-management/middleware"
+	"restorent-management/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,6 +16,13 @@ func UserRoutes(router *gin.Engine) {
 		userGroup.GET("/:user_id", controllers.GetUser())
 		userGroup.POST("/signup", controllers.SignUp())
 		userGroup.POST("/login", controllers.Login())
-		userGroup.PUT("/update/:user_id", controllers.UpdateUser())
+		userGroup.PUT("/update/:user_id", middleware.Authentication(), controllers.UpdateUser())
+		userGroup.PATCH("/:user_id", middleware.Authentication(), controllers.UpdateUser())
+		userGroup.PUT("/:user_id/permissions", middleware.Authentication(),
+			middleware.RequirePermission(models.PermissionManageUsers), controllers.UpdateUserPermissions())
+		userGroup.DELETE("/:user_id", middleware.Authentication(),
+			middleware.RequirePermission(models.PermissionManageUsers), controllers.DeleteUser())
+		userGroup.POST("/:user_id/restore", middleware.Authentication(),
+			middleware.RequirePermission(models.PermissionManageUsers), controllers.RestoreUser())
 	}
 }
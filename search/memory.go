@@ -0,0 +1,86 @@
+package search
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+
+	"restorent-management/models"
+)
+
+// MemoryIndex searches a fixed set of foods. It scores matches with the
+// same field weights as the Mongo text index but does no stemming.
+type MemoryIndex struct {
+	Foods []models.Food
+}
+
+func (m MemoryIndex) Search(ctx context.Context, q Query) ([]Result, error) {
+	terms := words(q.Text)
+	results := []Result{}
+
+	for _, food := range m.Foods {
+		if !matchesFilters(food, q) {
+			continue
+		}
+
+		score := score(food, terms, q.Prefix)
+		if len(terms) > 0 && score == 0 {
+			continue
+		}
+		results = append(results, Result{Food: food, Score: score})
+	}
+
+	return rank(results, q.Limit), nil
+}
+
+// score weighs the words of each field that match the terms.
+func score(food models.Food, terms []string, prefix bool) float64 {
+	total := 0.0
+	for _, term := range terms {
+		total += NameWeight * matches(words(stringValue(food.Name)), term, prefix)
+		total += TagsWeight * matches(words(strings.Join(food.Tags, " ")), term, prefix)
+		total += DescriptionWeight * matches(words(stringValue(food.Description)), term, prefix)
+	}
+	return total
+}
+
+// rank orders results by score, then name, and keeps the first limit.
+func rank(results []Result, limit int) []Result {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return stringValue(results[i].Name) < stringValue(results[j].Name)
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// matches counts the words equal to term, or starting with it when prefix
+// is set.
+func matches(fieldWords []string, term string, prefix bool) float64 {
+	count := 0.0
+	for _, word := range fieldWords {
+		if word == term || (prefix && strings.HasPrefix(word, term)) {
+			count++
+		}
+	}
+	return count
+}
+
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
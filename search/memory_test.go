@@ -0,0 +1,119 @@
+package search
+
+import (
+	"context"
+	"restorent-management/models"
+	"slices"
+	"testing"
+)
+
+func food(name, description string, price float64, tags ...string) models.Food {
+	menu := "menu-1"
+	return models.Food{Name: &name, Description: &description, Price: &price, Tags: tags, Menu_id: &menu}
+}
+
+func names(results []Result) []string {
+	out := make([]string, len(results))
+	for i, r := range results {
+		out[i] = *r.Name
+	}
+	return out
+}
+
+func TestMemoryIndexSearch(t *testing.T) {
+	index := MemoryIndex{Foods: []models.Food{
+		food("Margherita Pizza", "Tomato and mozzarella", 9, "pizza", "vegetarian"),
+		food("Pepperoni Pizza", "Spicy pepperoni", 11, "pizza"),
+		food("Garlic Bread", "Bread baked with garlic butter, goes with pizza", 4),
+		food("Tomato Soup", "Slow cooked tomatoes", 5, "soup"),
+		food("Pizzetta", "A small pizza", 6),
+	}}
+	price := func(p float64) *float64 { return &p }
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{
+			name:  "name matches rank above tags and descriptions",
+			query: Query{Text: "pizza"},
+			want:  []string{"Margherita Pizza", "Pepperoni Pizza", "Garlic Bread", "Pizzetta"},
+		},
+		{
+			name:  "whole words only without prefix",
+			query: Query{Text: "pizz"},
+			want:  []string{},
+		},
+		{
+			name:  "prefix matches the start of words",
+			query: Query{Text: "pizz", Prefix: true},
+			want:  []string{"Margherita Pizza", "Pepperoni Pizza", "Pizzetta", "Garlic Bread"},
+		},
+		{
+			name:  "each term adds to the score",
+			query: Query{Text: "tomato soup"},
+			want:  []string{"Tomato Soup", "Margherita Pizza"},
+		},
+		{
+			name:  "matching is case insensitive",
+			query: Query{Text: "GARLIC"},
+			want:  []string{"Garlic Bread"},
+		},
+		{
+			name:  "limit keeps the best matches",
+			query: Query{Text: "pizza", Limit: 2},
+			want:  []string{"Margherita Pizza", "Pepperoni Pizza"},
+		},
+		{
+			name:  "limit applies after ranking prefix matches",
+			query: Query{Text: "piz", Prefix: true, Limit: 3},
+			want:  []string{"Margherita Pizza", "Pepperoni Pizza", "Pizzetta"},
+		},
+		{
+			name:  "no text lists every food by name",
+			query: Query{Limit: 3},
+			want:  []string{"Garlic Bread", "Margherita Pizza", "Pepperoni Pizza"},
+		},
+		{
+			name:  "filters apply with text",
+			query: Query{Text: "pizza", Price_to: price(10)},
+			want:  []string{"Margherita Pizza", "Garlic Bread", "Pizzetta"},
+		},
+		{
+			name:  "an empty menu list matches nothing",
+			query: Query{Text: "pizza", Menu_ids: []string{}},
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := index.Search(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			if got := names(results); !slices.Equal(got, tt.want) {
+				t.Errorf("Search() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Fish & Chips", []string{"fish", "chips"}},
+		{"crème brûlée", []string{"crème", "brûlée"}},
+		{"7-Up, 330ml", []string{"7", "up", "330ml"}},
+		{"", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := words(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("words(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package search
+
+import (
+	"context"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoIndex searches the food collection. Whole word searches use the
+// food_text index and its text score. Prefix searches match the start of
+// words in the indexed fields with a regular expression and are scored
+// like MemoryIndex. Every prefix match is scored before the limit is
+// applied, so a strong match is never cut off for sorting late by name.
+type MongoIndex struct {
+	Collection *mongo.Collection
+}
+
+func (m MongoIndex) Search(ctx context.Context, q Query) ([]Result, error) {
+	filter := m.filter(q)
+	terms := words(q.Text)
+	ranked := q.Prefix && len(terms) > 0
+
+	var opts *options.FindOptions
+	switch {
+	case len(terms) == 0:
+		opts = options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	case ranked:
+		var and bson.A
+		for _, term := range terms {
+			pattern := primitive.Regex{Pattern: `\b` + regexp.QuoteMeta(term), Options: "i"}
+			and = append(and, bson.M{"$or": bson.A{bson.M{"name": pattern}, bson.M{"tags": pattern}, bson.M{"description": pattern}}})
+		}
+		filter["$and"] = and
+		opts = options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	default:
+		filter["$text"] = bson.M{"$search": q.Text}
+		score := bson.M{"$meta": "textScore"}
+		opts = options.Find().SetProjection(bson.M{"score": score}).SetSort(bson.D{{Key: "score", Value: score}, {Key: "name", Value: 1}})
+	}
+
+	cursor, err := m.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []Result{}
+	for cursor.Next(ctx) {
+		var result Result
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+		if !matchesAvailability(result.Food, q) {
+			continue
+		}
+		if ranked {
+			result.Score = score(result.Food, terms, true)
+		}
+		results = append(results, result)
+		if !ranked && q.Limit > 0 && len(results) == q.Limit {
+			break
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	if ranked {
+		return rank(results, q.Limit), nil
+	}
+	return results, nil
+}
+
+func (m MongoIndex) filter(q Query) bson.M {
+	filter := bson.M{"deleted_at": nil}
+	if q.Menu_ids != nil {
+		filter["menu_id"] = bson.M{"$in": q.Menu_ids}
+	}
+
+	price := bson.M{}
+	if q.Price_from != nil {
+		price["$gte"] = *q.Price_from
+	}
+	if q.Price_to != nil {
+		price["$lte"] = *q.Price_to
+	}
+	if len(price) > 0 {
+		filter["price"] = price
+	}
+
+	if len(q.Exclude_allergens) > 0 {
+		filter["allergens"] = bson.M{"$nin": q.Exclude_allergens}
+	}
+	if len(q.Dietary) > 0 {
+		filter["dietary_tags"] = bson.M{"$all": q.Dietary}
+	}
+	return filter
+}
@@ -0,0 +1,90 @@
+package search
+
+import (
+	"context"
+	"restorent-management/models"
+	"time"
+)
+
+// Weights of the fields a search matches on. The Mongo text index is
+// created with the same weights so both indexes rank alike.
+const (
+	NameWeight        = 10
+	TagsWeight        = 5
+	DescriptionWeight = 1
+)
+
+// Query is a food search. Text is matched as whole words, or as the start
+// of a word when Prefix is set, which is what typeahead on the POS uses.
+// Unset filters match every food; a non-nil but empty Menu_ids matches
+// none.
+type Query struct {
+	Text              string
+	Prefix            bool
+	Menu_ids          []string
+	Price_from        *float64
+	Price_to          *float64
+	Available         *bool
+	Exclude_allergens []string
+	Dietary           []string
+	Limit             int
+}
+
+// Result is a food that matched a search with its relevance score. Higher
+// scores are better matches.
+type Result struct {
+	models.Food `bson:",inline"`
+	Score       float64 `json:"score"`
+}
+
+// Index finds foods. MongoIndex is used by the API; MemoryIndex searches a
+// slice of foods and lets tests run without a database.
+type Index interface {
+	Search(ctx context.Context, q Query) ([]Result, error)
+}
+
+// matchesFilters applies the non text filters of q to a food. MongoIndex
+// applies the same filters in its query; availability is checked here for
+// both, since it depends on the food's variants, its 86 state and the
+// time.
+func matchesFilters(food models.Food, q Query) bool {
+	if food.Deleted_at != nil {
+		return false
+	}
+	if q.Menu_ids != nil && (food.Menu_id == nil || !contains(q.Menu_ids, *food.Menu_id)) {
+		return false
+	}
+	if q.Price_from != nil && (food.Price == nil || *food.Price < *q.Price_from) {
+		return false
+	}
+	if q.Price_to != nil && (food.Price == nil || *food.Price > *q.Price_to) {
+		return false
+	}
+	for _, allergen := range q.Exclude_allergens {
+		if contains(food.Allergens, allergen) {
+			return false
+		}
+	}
+	for _, tag := range q.Dietary {
+		if !contains(food.Dietary_tags, tag) {
+			return false
+		}
+	}
+	return matchesAvailability(food, q)
+}
+
+func matchesAvailability(food models.Food, q Query) bool {
+	if q.Available == nil {
+		return true
+	}
+	return food.IsOrderableAt(time.Now()) == *q.Available
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
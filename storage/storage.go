@@ -0,0 +1,32 @@
+// Package storage keeps uploaded files in a blob store. The API only uses
+// the Store interface, so the local filesystem store can be swapped for an
+// object store without touching the handlers.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned by Open and Delete when no object has the key.
+var ErrNotFound = errors.New("storage: object not found")
+
+// Store saves objects under slash separated keys such as
+// foods/<food_id>/<hash>.jpg.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Open(ctx context.Context, key string) (*Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Object is an opened object. The caller must close Body. Stores that can
+// seek return a Body that also implements io.Seeker, which lets range
+// requests be served without reading the whole object.
+type Object struct {
+	Body         io.ReadCloser
+	Content_type string
+	Size         int64
+	Modified_at  time.Time
+}